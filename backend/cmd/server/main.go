@@ -6,16 +6,21 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/noueii/nocs-log-saver/internal/application/commands"
 	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
 	"github.com/noueii/nocs-log-saver/internal/infrastructure/config"
 	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
 	"github.com/noueii/nocs-log-saver/internal/interfaces/http/handlers"
 	"github.com/noueii/nocs-log-saver/internal/interfaces/http/middleware"
+	"github.com/noueii/nocs-log-saver/internal/interfaces/udp"
 )
 
 func main() {
@@ -56,10 +61,97 @@ func main() {
 	userRepo := persistence.NewPostgresUserRepository(db)
 	sessionRepo := persistence.NewPostgresSessionRepository(db)
 	serverRepo := persistence.NewPostgresServerRepository(db)
+	parseJobRepo := persistence.NewPostgresParseJobRepository(db)
+	ingestBatchRepo := persistence.NewPostgresIngestBatchRepository(db)
+	gameSessionRepo := persistence.NewPostgresGameSessionRepository(db)
+	roundRepo := persistence.NewPostgresRoundRepository(db)
+	playerRepo := persistence.NewPostgresPlayerRepository(db)
+	reparseRepo := persistence.NewPostgresReparseRepository(db)
+	failedParseRepo := persistence.NewPostgresFailedParseRepository(db)
+	logRepo := persistence.NewPostgresLogRepository(db)
+	parsedLogRepo := persistence.NewPostgresParsedLogRepository(db)
+	parseRuleRepo := persistence.NewPostgresParseRuleRepository(db)
 
 	// Initialize services
 	jwtSecret := getEnv("JWT_SECRET", "your-secret-key-change-this-in-production")
 	authService := services.NewAuthService(userRepo, sessionRepo, jwtSecret)
+	eventTrackers := services.EventTrackers{
+		Sessions: services.NewSessionDetectorService(gameSessionRepo),
+		Rounds:   services.NewRoundTrackerService(roundRepo),
+		Players:  services.NewPlayerRegistryService(playerRepo),
+	}
+	
+	// Custom parse rules from the rules file and the database; the background
+	// workers below stop at shutdown
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	parseRules := services.NewParseRuleEngine()
+	parseRuleService := services.NewParseRuleService(parseRuleRepo, parseRules, getEnv("PARSE_RULES_FILE", ""))
+	if err := parseRuleService.Reload(workerCtx); err != nil {
+		log.Printf("Failed to load parse rules: %v", err)
+	}
+	parseRuleService.Watch(workerCtx, getEnvDuration("PARSE_RULES_RELOAD_INTERVAL", 30*time.Second))
+	
+	// Stored events are pushed to live stream clients; slow clients are dropped
+	eventHub := services.NewEventHub(getEnvInt("STREAM_BUFFER_SIZE", services.DefaultEventBufferSize))
+	
+	ingestService := services.NewIngestService(db, parsedLogRepo, failedParseRepo, parseJobRepo, eventTrackers, services.IngestConfig{
+		Workers:        getEnvInt("INGEST_WORKERS", 8),
+		QueueSize:      getEnvInt("INGEST_QUEUE_SIZE", 10000),
+		JobLease:       getEnvDuration("INGEST_JOB_LEASE", services.DefaultJobLease),
+		BatchRetention: getEnvDuration("INGEST_BATCH_RETENTION", services.DefaultBatchRetention),
+		Rules:          parseRules,
+		Events:         eventHub,
+	})
+	// Resume parse jobs left unfinished by the previous run
+	if err := ingestService.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start parse workers: %v", err)
+	}
+
+	// HTTP and UDP lines are saved and queued on the parse workers. With
+	// INGEST_IP_WHITELIST set, lines are only taken from whitelisted IPs.
+	var whitelistRepo repositories.WhitelistRepository
+	if getEnvBool("INGEST_IP_WHITELIST", false) {
+		whitelistRepo = persistence.NewPostgresWhitelistRepository(db)
+	}
+	ingestLog := commands.NewIngestLogHandler(logRepo, serverRepo, whitelistRepo, ingestService,
+		getEnvDuration("INGEST_DEDUP_WINDOW", 10*time.Minute))
+
+	// Reparses and retries classify lines with the same parse rules. Lines
+	// that parse on retry are pushed to stream clients; reparsed rows are not,
+	// as they replace events that were already streamed.
+	parserService := services.NewParserService(db)
+	parserService.UseRules(parseRules)
+	parserService.PublishTo(eventHub)
+	
+	// Resume reparse jobs interrupted by the previous run
+	reparseService := services.NewReparseService(parserService, reparseRepo)
+	if err := reparseService.ResumeUnfinished(workerCtx); err != nil {
+		log.Printf("Failed to resume reparse jobs: %v", err)
+	}
+	
+	// Retry failed parses in the background with exponential backoff
+	retryService := services.NewFailedParseRetryService(parserService, failedParseRepo, entities.RetryPolicy{
+		BaseDelay:  getEnvDuration("FAILED_PARSE_RETRY_BASE_DELAY", services.DefaultRetryPolicy.BaseDelay),
+		MaxDelay:   getEnvDuration("FAILED_PARSE_RETRY_MAX_DELAY", services.DefaultRetryPolicy.MaxDelay),
+		MaxRetries: getEnvInt("FAILED_PARSE_MAX_RETRIES", services.DefaultRetryPolicy.MaxRetries),
+	})
+	retryService.Start(workerCtx)
+
+	// Per-server ingestion rate limit; servers can override the defaults
+	rateLimiter := middleware.NewRateLimiter(
+		getEnvInt("RATE_LIMIT_PER_MINUTE", 1000),
+		getEnvInt("RATE_LIMIT_BURST", 100),
+	)
+
+	// Optional UDP listener for servers that can only use logaddress_add
+	var udpListener *udp.LogListener
+	if udpAddr := getEnv("UDP_LOG_ADDR", ""); udpAddr != "" {
+		udpListener = udp.NewLogListener(udpAddr, serverRepo, ingestLog)
+		if err := udpListener.Start(); err != nil {
+			log.Fatalf("Failed to start UDP log listener: %v", err)
+		}
+		log.Printf("UDP log listener started on %s", udpAddr)
+	}
 
 	// Initialize Gin router
 	gin.SetMode(getEnv("GIN_MODE", gin.ReleaseMode))
@@ -91,15 +183,33 @@ func main() {
 
 		// Public API routes (no auth required for viewing logs)
 		api.GET("/logs", handlers.GetLogs(db))
+		api.PUT("/logs/failed/:id/retry", middleware.AuthMiddleware(authService), middleware.RBACMiddleware("logs", "retry"), handlers.RetryFailedParse(retryService))
 		api.GET("/event-types", handlers.GetEventTypes(db)) // List event types for filtering
 		api.GET("/servers", handlers.GetServers(db)) // List servers for dropdown
+		api.GET("/batches/:id", handlers.GetBatch(ingestBatchRepo)) // Ingestion batch status
+		
+		// Live stream of parsed events over SSE or WebSocket
+		streamHandler := handlers.NewStreamHandler(eventHub, getEnvDuration("STREAM_HEARTBEAT_INTERVAL", 15*time.Second))
+		api.GET("/stream", streamHandler.Stream)
+		
+		// Game sessions detected from the logs
+		sessionHandler := handlers.NewSessionHandler(gameSessionRepo, services.NewScoreboardService(gameSessionRepo))
+		api.GET("/sessions", sessionHandler.List)
+		api.GET("/sessions/:id", sessionHandler.Get)
+		api.GET("/sessions/:id/logs", sessionHandler.Logs)
+		api.GET("/sessions/:id/scoreboard", sessionHandler.Scoreboard)
+		
+		// Player profiles built from the sessions they played in
+		playerHandler := handlers.NewPlayerHandler(services.NewPlayerProfileService(playerRepo, gameSessionRepo))
+		api.GET("/players/:steamid", playerHandler.Profile)
+		api.GET("/players/:steamid/matches", playerHandler.Matches)
 		
 		// Admin routes for server management (protected)
 		admin := api.Group("/admin")
 		admin.Use(middleware.AuthMiddleware(authService))
 		{
 			// Server management routes
-			serverHandler := handlers.NewServerHandler(serverRepo)
+			serverHandler := handlers.NewServerHandler(serverRepo, getEnvDuration("API_KEY_GRACE_PERIOD", 24*time.Hour))
 			servers := admin.Group("/servers")
 			servers.Use(middleware.RBACMiddleware("servers", "read"))
 			{
@@ -109,24 +219,61 @@ func main() {
 				servers.PUT("/:id", middleware.RBACMiddleware("servers", "update"), serverHandler.Update)
 				servers.DELETE("/:id", middleware.RBACMiddleware("servers", "delete"), serverHandler.Delete)
 				servers.POST("/:id/regenerate-key", middleware.RBACMiddleware("servers", "update"), serverHandler.RegenerateAPIKey)
+				servers.POST("/:id/regenerate-signing-secret", middleware.RBACMiddleware("servers", "update"), serverHandler.RegenerateSigningSecret)
+			}
+
+			// UDP listener packet counters
+			admin.GET("/udp/stats", middleware.RBACMiddleware("servers", "read"), handlers.GetUDPStats(udpListener))
+
+			// Ingestion rate limiter counters
+			admin.GET("/ratelimit/stats", middleware.RBACMiddleware("servers", "read"), handlers.GetRateLimitStats(rateLimiter))
+
+			// Custom parse rules, with dry runs against stored raw logs
+			parseRuleHandler := handlers.NewParseRuleHandler(parseRuleService)
+			parseRulesGroup := admin.Group("/parse-rules")
+			parseRulesGroup.Use(middleware.RBACMiddleware("parse_rules", "read"))
+			{
+				parseRulesGroup.GET("", parseRuleHandler.List)
+				parseRulesGroup.GET("/:id", parseRuleHandler.Get)
+				parseRulesGroup.POST("", middleware.RBACMiddleware("parse_rules", "create"), parseRuleHandler.Create)
+				parseRulesGroup.PUT("/:id", middleware.RBACMiddleware("parse_rules", "update"), parseRuleHandler.Update)
+				parseRulesGroup.DELETE("/:id", middleware.RBACMiddleware("parse_rules", "delete"), parseRuleHandler.Delete)
+				parseRulesGroup.POST("/reload", middleware.RBACMiddleware("parse_rules", "update"), parseRuleHandler.Reload)
+				parseRulesGroup.POST("/dry-run", parseRuleHandler.DryRun)
+			}
+
+			// Reparse stored logs with the current parser
+			reparseHandler := handlers.NewReparseHandler(workerCtx, reparseService, reparseRepo)
+			reparse := admin.Group("/reparse")
+			reparse.Use(middleware.RBACMiddleware("logs", "reparse"))
+			{
+				reparse.GET("", reparseHandler.List)
+				reparse.POST("", reparseHandler.Create)
+				reparse.GET("/:id", reparseHandler.Get)
+				reparse.POST("/:id/resume", reparseHandler.Resume)
 			}
 		}
 	}
 
 	// Log ingestion endpoint with server authentication middleware
+	maxBodyBytes := int64(getEnvInt("INGEST_MAX_BODY_BYTES", 10<<20))
+	signatureVerifier := middleware.NewSignatureVerifier(getEnvDuration("SIGNATURE_MAX_SKEW", 5*time.Minute), maxBodyBytes)
 	router.POST("/logs/:server_id", 
-		middleware.ServerAuthMiddleware(serverRepo),
-		handlers.HandleLogIngestion(db),
+		middleware.ServerAuthMiddleware(serverRepo, signatureVerifier),
+		rateLimiter.Middleware(),
+		handlers.HandleLogIngestion(ingestLog, maxBodyBytes),
 	)
 	
 	// Parse test endpoint (authenticated users only)
-	api.POST("/parse-test", handlers.HandleParseTest(db))
+	api.POST("/parse-test", handlers.HandleParseTest(db, parseRules))
 
 	// Start server with graceful shutdown
 	srv := &http.Server{
 		Addr:    ":" + getEnv("PORT", "9090"),
 		Handler: router,
 	}
+	// End open streams so shutdown does not wait on them
+	srv.RegisterOnShutdown(eventHub.Close)
 
 	// Graceful shutdown
 	go func() {
@@ -151,6 +298,22 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	if udpListener != nil {
+		udpListener.Stop()
+	}
+
+	// Running reparse jobs roll back the chunk in progress and resume on the
+	// next start; failed parse retries stop after the line in progress
+	stopWorkers()
+
+	// Finish claimed lines; anything left stays queued for the next start
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer drainCancel()
+
+	if err := ingestService.Shutdown(drainCtx); err != nil {
+		log.Printf("Parse queue not fully drained: %v", err)
+	}
+
 	log.Println("Server exited")
 }
 
@@ -159,4 +322,25 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
 }
\ No newline at end of file
@@ -2,110 +2,149 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	appservices "github.com/noueii/nocs-log-saver/internal/application/services"
 	"github.com/noueii/nocs-log-saver/internal/domain/entities"
 	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
 	"github.com/noueii/nocs-log-saver/internal/domain/services"
 )
 
-// IngestLogCommand represents the command to ingest a new log
+// ErrIPNotWhitelisted is returned when lines are sent from an address that
+// is not on the IP whitelist
+var ErrIPNotWhitelisted = errors.New("IP not whitelisted")
+
+// IngestLogCommand represents the command to ingest the log lines a server
+// sent in one request or packet
 type IngestLogCommand struct {
-	ServerID  string
-	Content   string
-	ClientIP  string
-	Timestamp time.Time
+	ServerID       string
+	Lines          []string
+	IdempotencyKey string // retries with the same key return the original batch
+	ClientIP       string // checked against the IP whitelist and recorded as the server's address
+	KeepAddress    bool   // leave the server's recorded address as it is
+	NoReceipt      bool   // save the lines without a batch record the sender could look up
 }
 
 // IngestLogHandler handles the log ingestion use case
 type IngestLogHandler struct {
-	logRepo      repositories.LogRepository
-	parsedRepo   repositories.ParsedLogRepository
-	failedRepo   repositories.FailedParseRepository
+	logRepo       repositories.LogRepository
+	serverRepo    repositories.ServerRepository
 	whitelistRepo repositories.WhitelistRepository
-	parser       services.LogParser
-	sessionDetector services.SessionDetector
+	queue         services.ParseQueue
+	dedupWindow   time.Duration
 }
 
-// NewIngestLogHandler creates a new handler with dependencies injected
+// NewIngestLogHandler creates a new handler with dependencies injected.
+// Lines are only accepted from whitelisted addresses unless whitelistRepo
+// is nil. Lines resent within dedupWindow are detected as duplicates; 0
+// disables it.
 func NewIngestLogHandler(
 	logRepo repositories.LogRepository,
-	parsedRepo repositories.ParsedLogRepository,
-	failedRepo repositories.FailedParseRepository,
+	serverRepo repositories.ServerRepository,
 	whitelistRepo repositories.WhitelistRepository,
-	parser services.LogParser,
-	sessionDetector services.SessionDetector,
+	queue services.ParseQueue,
+	dedupWindow time.Duration,
 ) *IngestLogHandler {
 	return &IngestLogHandler{
-		logRepo:      logRepo,
-		parsedRepo:   parsedRepo,
-		failedRepo:   failedRepo,
+		logRepo:       logRepo,
+		serverRepo:    serverRepo,
 		whitelistRepo: whitelistRepo,
-		parser:       parser,
-		sessionDetector: sessionDetector,
+		queue:         queue,
+		dedupWindow:   dedupWindow,
 	}
 }
 
-// Handle processes the log ingestion command
-func (h *IngestLogHandler) Handle(ctx context.Context, cmd IngestLogCommand) error {
+// Handle saves every non-empty line as a raw log together with a parse job,
+// in one transaction, so the lines are durable once it returns, and wakes
+// the parse queue. It returns the batch the lines were saved under (nil when
+// there were none), or without saving anything ErrIPNotWhitelisted when the
+// client IP is not whitelisted, ErrPipelineClosed when the parse queue is
+// shutting down and ErrQueueFull when the server's parse queue has no room.
+//
+// Lines already saved for the server within the dedup window are counted as
+// duplicates instead of being saved again. When the idempotency key was used
+// before, nothing is saved and the original batch is returned with Replayed
+// set.
+func (h *IngestLogHandler) Handle(ctx context.Context, cmd IngestLogCommand) (*entities.IngestBatch, error) {
 	// Check IP whitelist
-	allowed, err := h.whitelistRepo.IsAllowed(ctx, cmd.ClientIP)
-	if err != nil {
-		return fmt.Errorf("check whitelist: %w", err)
+	if h.whitelistRepo != nil {
+		allowed, err := h.whitelistRepo.IsAllowed(ctx, cmd.ClientIP)
+		if err != nil {
+			return nil, fmt.Errorf("check whitelist: %w", err)
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: %s", ErrIPNotWhitelisted, cmd.ClientIP)
+		}
 	}
-	if !allowed {
-		return fmt.Errorf("IP %s not whitelisted", cmd.ClientIP)
+
+	var lines []string
+	for _, line := range cmd.Lines {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
 	}
 
-	// Create and save raw log
-	log := &entities.Log{
-		ID:        uuid.New().String(),
-		ServerID:  cmd.ServerID,
-		Content:   cmd.Content,
-		CreatedAt: cmd.Timestamp,
+	if h.queue.Closed() {
+		return nil, appservices.ErrPipelineClosed
+	}
+	if !h.queue.CanAccept(cmd.ServerID, len(lines)) {
+		return nil, appservices.ErrQueueFull
 	}
 
-	if err := h.logRepo.Create(ctx, log); err != nil {
-		return fmt.Errorf("save raw log: %w", err)
+	batch, err := h.saveLines(ctx, cmd, lines)
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse asynchronously
-	go h.parseAsync(context.Background(), log)
+	// The lines are saved; a stale last seen is not worth failing over
+	if cmd.ClientIP != "" && !cmd.KeepAddress {
+		if err := h.serverRepo.UpdateLastSeen(ctx, cmd.ServerID, cmd.ClientIP); err != nil {
+			log.Printf("Failed to update last seen of server %s: %v", cmd.ServerID, err)
+		}
+	}
 
-	return nil
+	return batch, nil
 }
 
-// parseAsync handles asynchronous log parsing
-func (h *IngestLogHandler) parseAsync(ctx context.Context, log *entities.Log) {
-	// Attempt to parse
-	parsed, err := h.parser.Parse(ctx, log.Content)
-	if err != nil {
-		// Save as failed parse
-		failedParse := &entities.FailedParse{
-			ID:           uuid.New().String(),
-			RawLogID:     log.ID,
-			ErrorMessage: err.Error(),
-			RetryCount:   0,
-			Resolved:     false,
-			CreatedAt:    time.Now(),
-		}
-		_ = h.failedRepo.Create(ctx, failedParse)
-		return
+// saveLines saves the lines as a batch and wakes the parse queue
+func (h *IngestLogHandler) saveLines(ctx context.Context, cmd IngestLogCommand, lines []string) (*entities.IngestBatch, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	batch := &entities.IngestBatch{
+		ID:        uuid.New().String(),
+		ServerID:  cmd.ServerID,
+		NoReceipt: cmd.NoReceipt,
+	}
+	if cmd.IdempotencyKey != "" {
+		batch.IdempotencyKey = &cmd.IdempotencyKey
 	}
 
-	// Set additional fields
-	parsed.ID = uuid.New().String()
-	parsed.RawLogID = log.ID
-	parsed.ServerID = log.ServerID
-	parsed.CreatedAt = time.Now()
+	ingestLines := make([]entities.IngestLine, len(lines))
+	for i, line := range lines {
+		ingestLines[i] = entities.IngestLine{Content: line}
+		if h.dedupWindow > 0 {
+			ingestLines[i].Hash = appservices.LineHash(cmd.ServerID, line)
+		}
+	}
 
-	// Detect session
-	if sessionID, err := h.sessionDetector.DetectSession(ctx, parsed); err == nil {
-		parsed.SessionID = sessionID
+	dedupSince := time.Now().Add(-h.dedupWindow)
+	if _, err := h.logRepo.CreateBatch(ctx, batch, ingestLines, dedupSince); err != nil {
+		return nil, fmt.Errorf("save raw logs: %w", err)
 	}
+	if batch.Replayed || batch.SavedCount == 0 {
+		return batch, nil
+	}
+
+	// Wake the feeder; the stateful parser picks the lines up in order
+	h.queue.Notify()
 
-	// Save parsed log
-	_ = h.parsedRepo.Create(ctx, parsed)
-}
\ No newline at end of file
+	return batch, nil
+}
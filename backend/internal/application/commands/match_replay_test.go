@@ -0,0 +1,294 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+// matchTestLog is a full competitive match on de_dust2 as received over HTTP
+// log streaming, newest line first. The match ends 17:19 after 36 rounds.
+const (
+	matchTestLog      = "../../../debug/match-test.txt"
+	matchTestServerID = "18a5c248-c891-42a6-b72e-af0b184937c1"
+)
+
+// matchReplay is the state of the pipeline after replaying match-test.txt
+type matchReplay struct {
+	lines    int
+	batches  []*entities.IngestBatch
+	logs     *persistence.MemoryLogRepository
+	parsed   *persistence.MemoryParsedLogRepository
+	failed   *persistence.MemoryFailedParseRepository
+	sessions *persistence.MemoryGameSessionRepository
+	rounds   *persistence.MemoryRoundRepository
+	players  *persistence.MemoryPlayerRepository
+}
+
+// readMatchTestRequests reads match-test.txt in the order it was logged,
+// grouped into the requests it was received in
+func readMatchTestRequests(t *testing.T) [][]string {
+	t.Helper()
+
+	content, err := os.ReadFile(matchTestLog)
+	if err != nil {
+		t.Fatalf("read %s: %v", matchTestLog, err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	// Lines received in the same second came in one request
+	var requests [][]string
+	received := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		prefix, _, _ := strings.Cut(lines[i], "] ")
+		if prefix != received || len(requests) == 0 {
+			requests = append(requests, nil)
+			received = prefix
+		}
+		requests[len(requests)-1] = append(requests[len(requests)-1], lines[i])
+	}
+	return requests
+}
+
+// replayMatchTest ingests match-test.txt request by request and waits until
+// every line has been parsed
+func replayMatchTest(t *testing.T) *matchReplay {
+	t.Helper()
+
+	r := &matchReplay{
+		logs:     persistence.NewMemoryLogRepository(),
+		parsed:   persistence.NewMemoryParsedLogRepository(),
+		failed:   persistence.NewMemoryFailedParseRepository(),
+		sessions: persistence.NewMemoryGameSessionRepository(),
+		rounds:   persistence.NewMemoryRoundRepository(),
+		players:  persistence.NewMemoryPlayerRepository(),
+	}
+	servers := persistence.NewMemoryServerRepository()
+	servers.Create(context.Background(), &entities.Server{ID: matchTestServerID, Name: "match-test", IsActive: true})
+
+	requests := readMatchTestRequests(t)
+	for _, request := range requests {
+		r.lines += len(request)
+	}
+
+	// The queue counts pending jobs as last counted, which can include jobs
+	// already claimed, so there is room for every line twice
+	ingest := services.NewIngestService(nil, r.parsed, r.failed, r.logs, services.EventTrackers{
+		Sessions: services.NewSessionDetectorService(r.sessions),
+		Rounds:   services.NewRoundTrackerService(r.rounds),
+		Players:  services.NewPlayerRegistryService(r.players),
+	}, services.IngestConfig{Workers: 1, QueueSize: 2 * r.lines})
+	if err := ingest.Start(context.Background()); err != nil {
+		t.Fatalf("start ingest: %v", err)
+	}
+
+	handler := NewIngestLogHandler(r.logs, servers, nil, ingest, 10*time.Minute)
+	for _, request := range requests {
+		batch, err := handler.Handle(context.Background(), IngestLogCommand{
+			ServerID: matchTestServerID,
+			Lines:    request,
+			ClientIP: "203.0.113.10",
+		})
+		if err != nil {
+			t.Fatalf("ingest: %v", err)
+		}
+		r.batches = append(r.batches, batch)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for r.logs.UnfinishedJobs() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d lines still unparsed after 30s", r.logs.UnfinishedJobs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ingest.Shutdown(ctx); err != nil {
+		t.Fatalf("shut down ingest: %v", err)
+	}
+	return r
+}
+
+// eventsOfType returns the parsed events of a type in the order they were
+// stored
+func (r *matchReplay) eventsOfType(eventType string) []*entities.ParsedLog {
+	var events []*entities.ParsedLog
+	for _, parsedLog := range r.parsed.List() {
+		if parsedLog.EventType == eventType {
+			events = append(events, parsedLog)
+		}
+	}
+	return events
+}
+
+func TestMatchReplay(t *testing.T) {
+	r := replayMatchTest(t)
+
+	t.Run("every line is saved and parsed", func(t *testing.T) {
+		saved, parsed, failed := 0, 0, 0
+		for _, ingested := range r.batches {
+			batch, err := r.logs.FindBatch(context.Background(), ingested.ID)
+			if err != nil {
+				t.Fatalf("batch %s: %v", ingested.ID, err)
+			}
+			if !batch.IsComplete() || batch.CompletedAt == nil {
+				t.Errorf("batch %s: %d lines pending", batch.ID, batch.PendingCount())
+			}
+			if batch.DuplicateCount != 0 {
+				t.Errorf("batch %s: %d lines counted as duplicates", batch.ID, batch.DuplicateCount)
+			}
+			saved += batch.SavedCount
+			parsed += batch.ParsedCount
+			failed += batch.FailedCount
+		}
+		if saved != r.lines {
+			t.Errorf("saved %d of %d lines", saved, r.lines)
+		}
+		if parsed+failed != saved {
+			t.Errorf("%d lines parsed and %d failed, want %d in total", parsed, failed, saved)
+		}
+		if failed != len(r.failed.List()) {
+			t.Errorf("%d lines counted as failed, %d failed parses stored", failed, len(r.failed.List()))
+		}
+	})
+
+	t.Run("event counts", func(t *testing.T) {
+		counts := make(map[string]int)
+		for _, parsedLog := range r.parsed.List() {
+			counts[parsedLog.EventType]++
+		}
+
+		// Some attacks, blinds and money changes are only found by the
+		// heuristics for lines cs2-log leaves unknown. The counts are those of
+		// a replay with the cs2-log results recorded for this log in
+		// debug/parse_results.json.
+		want := map[string]int{
+			"kill":                123,
+			"kill_assist":         14,
+			"killed_by_bomb":      2,
+			"suicide":             16,
+			"attack":              528,
+			"blinded":             120,
+			"grenade_thrown":      281,
+			"projectile_spawned":  49,
+			"purchase":            863,
+			"money_change":        1285,
+			"picked_up":           1428,
+			"bomb_planted":        8,
+			"bomb_begin_defuse":   15,
+			"bomb_got":            56,
+			"bomb_dropped":        43,
+			"round_start":         39,
+			"round_end":           41,
+			"round_restart":       2,
+			"freeze_period_start": 49,
+			"team_notice":         39,
+			"team_scored":         86,
+			"team_switch":         48,
+			"match_start":         10,
+			"game_commencing":     2,
+			"player_connect":      18,
+			"player_entered":      18,
+			"player_disconnect":   10,
+			"round_stats":         49,
+			"round_stats_ref":     49,
+		}
+		for eventType, n := range want {
+			if counts[eventType] != n {
+				t.Errorf("%s: got %d events, want %d", eventType, counts[eventType], n)
+			}
+		}
+	})
+
+	t.Run("round stats", func(t *testing.T) {
+		stats := r.eventsOfType("round_stats")
+		if len(stats) == 0 {
+			t.Fatal("no round_stats assembled")
+		}
+		for _, event := range stats {
+			if event.EventData["name"] != "round_stats" {
+				t.Errorf("round_stats from %s: got name %v", event.RawLogID, event.EventData["name"])
+			}
+			if _, ok := event.EventData["players"].(map[string]interface{}); !ok {
+				t.Errorf("round_stats from %s: players is %T, want an object", event.RawLogID, event.EventData["players"])
+			}
+		}
+
+		first, last := stats[0], stats[len(stats)-1]
+		if first.EventData["round_number"] != "1" {
+			t.Errorf("first round_stats: got round %v, want 1", first.EventData["round_number"])
+		}
+		// The stats of a round are logged before its result
+		for key, value := range map[string]string{"round_number": "36", "score_ct": "17", "score_t": "18", "map": "de_dust2"} {
+			if last.EventData[key] != value {
+				t.Errorf("last round_stats: got %s %v, want %s", key, last.EventData[key], value)
+			}
+		}
+		players := last.EventData["players"].(map[string]interface{})
+		if len(players) == 0 {
+			t.Error("last round_stats has no players")
+		}
+	})
+
+	t.Run("final score", func(t *testing.T) {
+		notices := r.eventsOfType("team_notice")
+		if len(notices) == 0 {
+			t.Fatal("no team notices parsed")
+		}
+		lastNotice := notices[len(notices)-1]
+		if lastNotice.SessionID == "" {
+			t.Fatal("last team notice has no session")
+		}
+
+		round, err := r.rounds.FindLatestBySession(context.Background(), lastNotice.SessionID)
+		if err != nil {
+			t.Fatalf("latest round: %v", err)
+		}
+		if round.RoundNumber != 36 || round.ScoreCT != 17 || round.ScoreT != 19 {
+			t.Errorf("last round: got round %d at %d:%d, want round 36 at 17:19", round.RoundNumber, round.ScoreCT, round.ScoreT)
+		}
+		if round.Winner == nil || *round.Winner != "TERRORIST" {
+			t.Errorf("last round: got winner %v, want TERRORIST", round.Winner)
+		}
+
+		var session *entities.GameSession
+		for _, s := range r.sessions.List() {
+			if s.ID == lastNotice.SessionID {
+				session = s
+			}
+		}
+		if session == nil {
+			t.Fatalf("session %s was not stored", lastNotice.SessionID)
+		}
+		// cs2-log leaves the "Game Over" line of this log unknown, and the
+		// heuristic guess for it does not end sessions, so the session stays
+		// open; its final score is the one of its last round
+		if session.MapName != "de_dust2" {
+			t.Errorf("session: got map %q, want de_dust2", session.MapName)
+		}
+	})
+
+	t.Run("players", func(t *testing.T) {
+		player, err := r.players.FindBySteamID64(context.Background(), entities.SteamID64(215888626))
+		if err != nil {
+			t.Fatalf("find player: %v", err)
+		}
+		if player.Name != "SHESKY" || len(player.Servers) != 1 || player.Servers[0].ServerID != matchTestServerID {
+			t.Errorf("player: got %q on %v, want SHESKY on %s", player.Name, player.Servers, matchTestServerID)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"sync"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// DefaultEventBufferSize is how many events a subscriber may fall behind
+// before it is dropped
+const DefaultEventBufferSize = 256
+
+// EventFilter selects the events a subscriber receives; empty fields match
+// every event
+type EventFilter struct {
+	ServerID  string
+	EventType string
+}
+
+// matches reports whether an event passes the filter
+func (f EventFilter) matches(event *entities.ParsedLog) bool {
+	return (f.ServerID == "" || f.ServerID == event.ServerID) &&
+		(f.EventType == "" || f.EventType == event.EventType)
+}
+
+// EventSubscription receives the stored events that match its filter
+type EventSubscription struct {
+	filter  EventFilter
+	events  chan *entities.ParsedLog
+	dropped bool // set by the hub before events is closed
+}
+
+// Events delivers matching events in the order they were stored. It is
+// closed when the subscription is cancelled, the subscriber fell too far
+// behind or the hub is closed.
+func (s *EventSubscription) Events() <-chan *entities.ParsedLog {
+	return s.events
+}
+
+// Dropped reports whether the subscription was ended because the subscriber
+// fell behind. Only valid once Events is closed.
+func (s *EventSubscription) Dropped() bool {
+	return s.dropped
+}
+
+// EventHub fans parsed events out to live subscribers in process. Publishing
+// never blocks the parser: each subscriber has a bounded buffer and is
+// dropped when it is full.
+type EventHub struct {
+	bufferSize int
+
+	mu          sync.Mutex
+	subscribers map[*EventSubscription]struct{}
+	closed      bool
+}
+
+// NewEventHub creates a hub that buffers up to bufferSize events per
+// subscriber
+func NewEventHub(bufferSize int) *EventHub {
+	if bufferSize < 1 {
+		bufferSize = DefaultEventBufferSize
+	}
+	return &EventHub{
+		bufferSize:  bufferSize,
+		subscribers: make(map[*EventSubscription]struct{}),
+	}
+}
+
+// Subscribe starts receiving the events that match filter. It returns nil
+// once the hub is closed.
+func (h *EventHub) Subscribe(filter EventFilter) *EventSubscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	sub := &EventSubscription{
+		filter: filter,
+		events: make(chan *entities.ParsedLog, h.bufferSize),
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops a subscription and closes its channel
+func (h *EventHub) Unsubscribe(sub *EventSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish hands a stored event to every matching subscriber. Subscribers
+// whose buffer is full are dropped. Publishing on a nil hub does nothing.
+func (h *EventHub) Publish(event *entities.ParsedLog) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.filter.matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped = true
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// Subscribers returns the number of live subscriptions
+func (h *EventHub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.subscribers)
+}
+
+// Close ends every subscription and rejects new ones, so open streams
+// finish before the HTTP server shuts down
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for sub := range h.subscribers {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	domainservices "github.com/noueii/nocs-log-saver/internal/domain/services"
+)
+
+// EventTrackers follow the game state of each server while its lines are
+// parsed, so every event can be stamped with where in a match it happened,
+// and register the players each event involves. Nil trackers are skipped.
+type EventTrackers struct {
+	Sessions domainservices.SessionDetector
+	Rounds   *RoundTrackerService
+	Players  *PlayerRegistryService
+}
+
+// eventContext is where in a match an event happened
+type eventContext struct {
+	SessionID   *string
+	RoundNumber *int
+	GamePhase   *string
+}
+
+// enabled reports whether any tracker is configured
+func (t EventTrackers) enabled() bool {
+	return t.Sessions != nil || t.Rounds != nil || t.Players != nil
+}
+
+// track runs an event through the trackers; later trackers see the session
+// found by the detector. content is the log line. Heuristic guesses are only
+// placed in the match: the trackers do not see their guessed type, so a
+// wrong guess cannot open a session or end a round.
+func (t EventTrackers) track(ctx context.Context, parsedLog *entities.ParsedLog, content string) (eventContext, error) {
+	var eventCtx eventContext
+
+	if parsedLog.ClassificationSource == entities.ClassificationHeuristic {
+		guess := *parsedLog
+		guess.EventType = ""
+		parsedLog = &guess
+	}
+
+	if t.Sessions != nil {
+		sessionID, err := t.Sessions.DetectSession(ctx, parsedLog)
+		if err != nil {
+			return eventCtx, fmt.Errorf("detect session: %w", err)
+		}
+		parsedLog.SessionID = sessionID
+		if sessionID != "" {
+			eventCtx.SessionID = &sessionID
+		}
+	}
+
+	if t.Rounds != nil {
+		position, err := t.Rounds.Track(ctx, parsedLog, content)
+		if err != nil {
+			return eventCtx, fmt.Errorf("track round: %w", err)
+		}
+		eventCtx.RoundNumber = position.RoundNumber
+		eventCtx.GamePhase = position.GamePhase
+	}
+
+	return eventCtx, nil
+}
+
+// recordPlayers registers the players of a stored event. content is the log
+// line the event was parsed from.
+func (t EventTrackers) recordPlayers(ctx context.Context, parsedLog *entities.ParsedLog, content string) error {
+	if t.Players == nil {
+		return nil
+	}
+	if err := t.Players.RecordPlayers(ctx, parsedLog.ID, parsedLog.ServerID, content, sessionEventTime(parsedLog)); err != nil {
+		return fmt.Errorf("record players: %w", err)
+	}
+	return nil
+}
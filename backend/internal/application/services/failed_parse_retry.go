@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
+)
+
+// DefaultRetryPolicy retries a failed parse after 1, 2, 4, 8 and 16 minutes
+var DefaultRetryPolicy = entities.RetryPolicy{
+	BaseDelay:  time.Minute,
+	MaxDelay:   time.Hour,
+	MaxRetries: 5,
+}
+
+const (
+	retryBatchSize    = 100
+	retryPollInterval = 10 * time.Second
+	retryClaimLease   = 5 * time.Minute // claimed retries unrecorded for this long are claimed again
+)
+
+// FailedParseRetryRepository is the queue of failed parses to retry
+type FailedParseRetryRepository interface {
+	repositories.FailedParseRepository
+
+	ClaimDue(ctx context.Context, policy entities.RetryPolicy, parserVersion string, limit int, lease time.Duration) ([]*entities.FailedParse, error)
+	RecordRetry(ctx context.Context, id, errorMsg, parserVersion string) error
+	Resolve(ctx context.Context, failed *entities.FailedParse, line *entities.ReparsedLine, parserVersion string) (*entities.ParsedLog, error)
+	RequestRetry(ctx context.Context, id string, policy entities.RetryPolicy, parserVersion string) (int64, error)
+}
+
+// FailedParseRetryService retries failed parses in the background with
+// exponential backoff. A line that parses is stored as an event, published
+// to the parser's event hub, and its failed parse is marked resolved. Lines
+// that failed under an older parser version are retried once more after an
+// upgrade, and a retry can be requested for any unresolved line.
+type FailedParseRetryService struct {
+	parser *ParserService
+	repo   FailedParseRetryRepository
+	policy entities.RetryPolicy
+	notify chan struct{}
+}
+
+// NewFailedParseRetryService creates a new retry service
+func NewFailedParseRetryService(parser *ParserService, repo FailedParseRetryRepository, policy entities.RetryPolicy) *FailedParseRetryService {
+	return &FailedParseRetryService{
+		parser: parser,
+		repo:   repo,
+		policy: policy,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Start retries due failed parses in the background until ctx is done
+func (s *FailedParseRetryService) Start(ctx context.Context) {
+	go s.run(ctx)
+}
+
+// RequestRetry queues a failed parse to be retried as soon as possible and
+// returns its 1-based position in the retry queue
+func (s *FailedParseRetryService) RequestRetry(ctx context.Context, id string) (int64, error) {
+	position, err := s.repo.RequestRetry(ctx, id, s.policy, s.parser.Version())
+	if err != nil {
+		return 0, err
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return position, nil
+}
+
+func (s *FailedParseRetryService) run(ctx context.Context) {
+	ticker := time.NewTicker(retryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		due, err := s.repo.ClaimDue(ctx, s.policy, s.parser.Version(), retryBatchSize, retryClaimLease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to find failed parses to retry: %v", err)
+		}
+
+		for _, failed := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			s.retry(ctx, failed)
+		}
+
+		// Keep retrying while the queue is backed up
+		if len(due) == retryBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// retry parses a failed line again and records the outcome
+func (s *FailedParseRetryService) retry(ctx context.Context, failed *entities.FailedParse) {
+	result := reparseLine(s.parser, &entities.ReparseLine{
+		RawLogID: failed.RawLogID,
+		ServerID: failed.ServerID,
+		Content:  failed.Content,
+	})
+
+	// round_stats lines only parse together with the rest of their block
+	if result.Skipped {
+		result.Error = failed.ErrorMessage
+	}
+
+	if result.Error != "" {
+		err := s.repo.RecordRetry(ctx, failed.ID, result.Error, s.parser.Version())
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to record retry of failed parse %s: %v", failed.ID, err)
+		}
+		return
+	}
+
+	stored, err := s.repo.Resolve(ctx, failed, result, s.parser.Version())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to record retry of failed parse %s: %v", failed.ID, err)
+		}
+		return
+	}
+	s.parser.events.Publish(stored)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	cs2log "github.com/noueii/cs2-log"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// Confidence of heuristic classifications
+const (
+	heuristicConfidenceExtracted = 0.9 // the line matched the event's full format
+	heuristicConfidencePhrase    = 0.7 // the line contains a phrase of the event
+	heuristicConfidenceLoose     = 0.4 // the line only loosely resembles the event
+)
+
+const (
+	heuristicPlayer   = `"(.+?)<(\d+)><([^>]*)><([^>]*)>"`
+	heuristicPosition = `\[(-?\d+) (-?\d+) (-?\d+)\]`
+)
+
+var (
+	heuristicPlayerPattern = regexp.MustCompile(heuristicPlayer)
+	heuristicAttackPattern = regexp.MustCompile(`^` + heuristicPlayer + ` ` + heuristicPosition + ` attacked ` +
+		heuristicPlayer + ` ` + heuristicPosition + ` with "([^"]*)" \(damage "(\d+)"\) \(damage_armor "(\d+)"\) ` +
+		`\(health "(\d+)"\) \(armor "(\d+)"\) \(hitgroup "([^"]*)"\)`)
+	heuristicBlindedPattern = regexp.MustCompile(`^` + heuristicPlayer + ` blinded for ([\d.]+) by ` +
+		heuristicPlayer + ` from \S+ entindex (\d+)`)
+	heuristicMoneyChangePattern = regexp.MustCompile(`^` + heuristicPlayer +
+		` money change (\d+)([+-]\d+) = \$(\d+)(?: \(tracked\))?(?: \(purchase: ([^)]+)\))?`)
+
+	// logTimestampPrefix is the log timestamp cs2-log strips from the raw
+	// text of unknown events; heuristics and parse rules match without it
+	logTimestampPrefix = regexp.MustCompile(`^L \d{2}/\d{2}/\d{4} - \d{2}:\d{2}:\d{2}(?:\.\d+)?:? `)
+)
+
+// classifyHeuristically guesses the event type of a line cs2-log could not
+// parse, or parsed as Unknown, with classifyUnknownEvent. Players and the
+// fields of attacks, blinds and money changes are read from the line in the
+// shape cs2-log uses, so the guesses can be browsed like parsed events; they
+// are left out of session events and player stats, so they never count
+// towards scoreboards. It returns nil when the line matches no heuristic.
+func (s *ParserService) classifyHeuristically(actualContent string, unknown *cs2log.Unknown) *parsedLine {
+	raw := logTimestampPrefix.ReplaceAllString(actualContent, "")
+	data := map[string]interface{}{}
+	if unknown != nil {
+		raw = unknown.Raw
+		json.Unmarshal([]byte(cs2log.ToJSON(*unknown)), &data)
+	}
+	data["raw"] = raw
+
+	eventType := s.classifyUnknownEvent(raw)
+	if eventType == "unknown_other" {
+		return nil
+	}
+
+	confidence := heuristicConfidencePhrase
+	switch {
+	case eventType == "attack", eventType == "blinded", eventType == "money_change":
+		if extractHeuristicFields(eventType, raw, data) {
+			confidence = heuristicConfidenceExtracted
+		} else {
+			confidence = heuristicConfidenceLoose
+		}
+	case strings.HasPrefix(eventType, "stats_"), eventType == "triggered_event", eventType == "team_triggered_event":
+		confidence = heuristicConfidenceLoose
+	}
+
+	// Link the event to the first player on the line
+	_, hasAttacker := data["attacker"]
+	_, hasPlayer := data["player"]
+	if !hasAttacker && !hasPlayer {
+		if match := heuristicPlayerPattern.FindStringSubmatch(raw); match != nil {
+			data["player"] = heuristicPlayerData(match[1:])
+		}
+	}
+
+	eventData, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	return &parsedLine{
+		ActualContent: actualContent,
+		EventType:     eventType,
+		EventData:     string(eventData),
+		Source:        entities.ClassificationHeuristic,
+		Confidence:    &confidence,
+	}
+}
+
+// extractHeuristicFields reads the fields of an attack, blind or money
+// change into data. It reports whether the line had the event's format.
+func extractHeuristicFields(eventType, raw string, data map[string]interface{}) bool {
+	switch eventType {
+	case "attack":
+		m := heuristicAttackPattern.FindStringSubmatch(raw)
+		if m == nil {
+			return false
+		}
+		data["attacker"] = heuristicPlayerData(m[1:5])
+		data["attacker_pos"] = heuristicPositionData(m[5:8])
+		data["victim"] = heuristicPlayerData(m[8:12])
+		data["victim_pos"] = heuristicPositionData(m[12:15])
+		data["weapon"] = m[15]
+		data["damage"] = heuristicInt(m[16])
+		data["damage_armor"] = heuristicInt(m[17])
+		data["health"] = heuristicInt(m[18])
+		data["armor"] = heuristicInt(m[19])
+		data["hitgroup"] = m[20]
+
+	case "blinded":
+		m := heuristicBlindedPattern.FindStringSubmatch(raw)
+		if m == nil {
+			return false
+		}
+		seconds, _ := strconv.ParseFloat(m[5], 64)
+		data["victim"] = heuristicPlayerData(m[1:5])
+		data["for"] = seconds
+		data["attacker"] = heuristicPlayerData(m[6:10])
+		data["entindex"] = heuristicInt(m[10])
+
+	case "money_change":
+		m := heuristicMoneyChangePattern.FindStringSubmatch(raw)
+		if m == nil {
+			return false
+		}
+		data["player"] = heuristicPlayerData(m[1:5])
+		data["equation"] = map[string]interface{}{
+			"a":      heuristicInt(m[5]),
+			"b":      heuristicInt(m[6]),
+			"result": heuristicInt(m[7]),
+		}
+		data["purchase"] = m[8]
+
+	default:
+		return false
+	}
+	return true
+}
+
+// heuristicPlayerData is a player's name, ID, SteamID and side as cs2-log
+// writes them
+func heuristicPlayerData(m []string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     m[0],
+		"id":       heuristicInt(m[1]),
+		"steam_id": m[2],
+		"side":     m[3],
+	}
+}
+
+// heuristicPositionData is a position as cs2-log writes it
+func heuristicPositionData(m []string) map[string]interface{} {
+	return map[string]interface{}{
+		"x": heuristicInt(m[0]),
+		"y": heuristicInt(m[1]),
+		"z": heuristicInt(m[2]),
+	}
+}
+
+func heuristicInt(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
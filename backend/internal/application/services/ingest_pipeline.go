@@ -0,0 +1,382 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+var (
+	ErrQueueFull      = errors.New("ingestion queue is full")
+	ErrPipelineClosed = errors.New("ingestion pipeline is shut down")
+)
+
+const (
+	claimBatchSize     = 500
+	claimPollInterval  = time.Second
+	completeBatchSize  = 100
+	maxParseAttempts   = 5
+	parseRetryDelay    = time.Second // doubled after every failed attempt
+	purgeInterval      = 10 * time.Minute
+	completedRetention = time.Hour
+	requeueInterval    = time.Minute
+
+	// DefaultJobLease is how long a claimed job may stay unfinished before
+	// it is handed out again
+	DefaultJobLease = 5 * time.Minute
+
+	// DefaultBatchRetention is how long the status of a completed batch
+	// can be looked up
+	DefaultBatchRetention = 24 * time.Hour
+)
+
+// ParseJobRepository is the durable queue the pipeline consumes
+type ParseJobRepository interface {
+	Claim(ctx context.Context, limit int, skipServers []string) ([]*entities.ParseJob, error)
+	Complete(ctx context.Context, parsed, unparseable []int64) error
+	Fail(ctx context.Context, id int64, errorMsg string) error
+	RequeueInFlight(ctx context.Context, lockedBefore time.Time) (int64, error)
+	PurgeCompleted(ctx context.Context, before time.Time) (int64, error)
+	PurgeBatches(ctx context.Context, before time.Time) (int64, error)
+	PendingByServer(ctx context.Context) (map[string]int, error)
+}
+
+// IngestPipeline parses saved log lines on a bounded pool of workers.
+// Lines are claimed from the durable parse_jobs queue in the order they were
+// saved. Each server is pinned to one shard, and each shard has a single
+// worker, so lines from a server are parsed in the order they were received.
+// A line that fails is retried in place, holding back the lines behind it,
+// until it parses or is given up on. Jobs for a full shard are held back by
+// the feeder, and their servers are not claimed for until the shard drains,
+// so one busy server does not hold up the other shards.
+type IngestPipeline struct {
+	parser    *StatefulParserService
+	jobs      ParseJobRepository
+	shards    []chan *entities.ParseJob
+	queueSize int
+	lease     time.Duration
+	retention time.Duration // of completed batches
+	notify    chan struct{}
+
+	stopFeeder context.CancelFunc
+	feederDone chan struct{}
+	wg         sync.WaitGroup
+
+	mu      sync.RWMutex
+	closed  bool
+	backlog map[string]int // pending jobs per server in the database, as last counted
+}
+
+// NewIngestPipeline creates a pipeline with the given number of shards. A
+// server's lines are rejected once queueSize of them wait to be parsed.
+// Jobs claimed longer than lease ago are assumed abandoned and requeued, and
+// batches are purged retention after they complete.
+func NewIngestPipeline(parser *StatefulParserService, jobs ParseJobRepository, workers, queueSize int, lease, retention time.Duration) *IngestPipeline {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	if lease <= 0 {
+		lease = DefaultJobLease
+	}
+	if retention <= 0 {
+		retention = DefaultBatchRetention
+	}
+
+	p := &IngestPipeline{
+		parser:     parser,
+		jobs:       jobs,
+		shards:     make([]chan *entities.ParseJob, workers),
+		queueSize:  queueSize,
+		lease:      lease,
+		retention:  retention,
+		notify:     make(chan struct{}, 1),
+		feederDone: make(chan struct{}),
+	}
+	for i := range p.shards {
+		p.shards[i] = make(chan *entities.ParseJob, queueSize)
+	}
+
+	return p
+}
+
+// Start requeues jobs abandoned by a previous run and starts the feeder and
+// workers. Jobs claimed by other running instances are left alone until
+// their lease expires.
+func (p *IngestPipeline) Start(ctx context.Context) error {
+	if err := p.requeueAbandoned(ctx); err != nil {
+		return err
+	}
+
+	for _, shard := range p.shards {
+		p.wg.Add(1)
+		go p.worker(shard)
+	}
+
+	feederCtx, cancel := context.WithCancel(context.Background())
+	p.stopFeeder = cancel
+	go p.feed(feederCtx)
+
+	return nil
+}
+
+// CanAccept reports whether the server's queue has room for n more lines,
+// counting the lines waiting in the database as well as those claimed. An
+// empty queue always accepts so batches larger than the queue still get
+// through; the feeder then waits while the worker drains it.
+func (p *IngestPipeline) CanAccept(serverID string, n int) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return false
+	}
+	waiting := len(p.shardFor(serverID)) + p.backlog[serverID]
+	return waiting == 0 || waiting+n <= p.queueSize
+}
+
+// Closed reports whether the pipeline has been shut down
+func (p *IngestPipeline) Closed() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.closed
+}
+
+// Notify wakes the feeder after new jobs have been committed
+func (p *IngestPipeline) Notify() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown stops claiming jobs and waits until every claimed line has been
+// parsed or the context expires. Jobs that are not finished stay in the
+// queue and are picked up again on the next start.
+func (p *IngestPipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	if p.stopFeeder != nil {
+		p.stopFeeder()
+		<-p.feederDone
+	}
+	for _, shard := range p.shards {
+		close(shard)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// feed claims pending jobs and hands them to the shard of their server
+func (p *IngestPipeline) feed(ctx context.Context) {
+	defer close(p.feederDone)
+
+	ticker := time.NewTicker(claimPollInterval)
+	defer ticker.Stop()
+	lastPurge, lastRequeue, lastCount := time.Now(), time.Now(), time.Time{}
+
+	// Claimed jobs waiting for room in their shard, in claim order. Claimed
+	// jobs left here on shutdown stay in processing and are requeued.
+	held := make([][]*entities.ParseJob, len(p.shards))
+
+	for {
+		if time.Since(lastPurge) > purgeInterval {
+			if _, err := p.jobs.PurgeCompleted(ctx, time.Now().Add(-completedRetention)); err != nil {
+				log.Printf("Failed to purge completed parse jobs: %v", err)
+			}
+			if _, err := p.jobs.PurgeBatches(ctx, time.Now().Add(-p.retention)); err != nil {
+				log.Printf("Failed to purge completed ingest batches: %v", err)
+			}
+			lastPurge = time.Now()
+		}
+		if time.Since(lastRequeue) > requeueInterval {
+			if err := p.requeueAbandoned(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to requeue abandoned parse jobs: %v", err)
+			}
+			lastRequeue = time.Now()
+		}
+		if time.Since(lastCount) >= claimPollInterval {
+			p.countBacklog(ctx)
+			lastCount = time.Now()
+		}
+
+		// Servers with held back jobs are not claimed for, so their lines
+		// stay in order and the held jobs stay bounded
+		p.handOut(held)
+		jobs, err := p.jobs.Claim(ctx, claimBatchSize, heldServers(held))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim parse jobs: %v", err)
+		}
+
+		for _, job := range jobs {
+			shard := p.shardIndex(job.ServerID)
+			held[shard] = append(held[shard], job)
+		}
+		p.handOut(held)
+
+		// Keep claiming while the queue is backed up
+		if len(jobs) == claimBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueAbandoned returns jobs whose lease expired to the queue
+func (p *IngestPipeline) requeueAbandoned(ctx context.Context) error {
+	requeued, err := p.jobs.RequeueInFlight(ctx, time.Now().Add(-p.lease))
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d abandoned parse jobs", requeued)
+	}
+	return nil
+}
+
+// countBacklog refreshes the per-server count of pending jobs that
+// CanAccept checks
+func (p *IngestPipeline) countBacklog(ctx context.Context) {
+	backlog, err := p.jobs.PendingByServer(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to count pending parse jobs: %v", err)
+		}
+		return
+	}
+
+	p.mu.Lock()
+	p.backlog = backlog
+	p.mu.Unlock()
+}
+
+// handOut moves held jobs to their shards until each shard is full
+func (p *IngestPipeline) handOut(held [][]*entities.ParseJob) {
+	for i, jobs := range held {
+		sent := 0
+	send:
+		for _, job := range jobs {
+			select {
+			case p.shards[i] <- job:
+				sent++
+			default:
+				break send
+			}
+		}
+		held[i] = jobs[sent:]
+	}
+}
+
+// heldServers lists the servers with jobs waiting for room in their shard
+func heldServers(held [][]*entities.ParseJob) []string {
+	seen := make(map[string]bool)
+	var servers []string
+	for _, jobs := range held {
+		for _, job := range jobs {
+			if !seen[job.ServerID] {
+				seen[job.ServerID] = true
+				servers = append(servers, job.ServerID)
+			}
+		}
+	}
+	return servers
+}
+
+// shardFor maps a server to its queue
+func (p *IngestPipeline) shardFor(serverID string) chan *entities.ParseJob {
+	return p.shards[p.shardIndex(serverID)]
+}
+
+// shardIndex is the index of the shard a server is pinned to
+func (p *IngestPipeline) shardIndex(serverID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(serverID))
+	return int(h.Sum32() % uint32(len(p.shards)))
+}
+
+// worker parses the jobs of one shard sequentially
+func (p *IngestPipeline) worker(jobs chan *entities.ParseJob) {
+	defer p.wg.Done()
+
+	var parsed, unparseable []int64
+	flush := func() {
+		if len(parsed) == 0 && len(unparseable) == 0 {
+			return
+		}
+		if err := p.jobs.Complete(context.Background(), parsed, unparseable); err != nil {
+			log.Printf("Failed to mark %d parse jobs done: %v", len(parsed)+len(unparseable), err)
+		}
+		parsed = parsed[:0]
+		unparseable = unparseable[:0]
+	}
+	defer flush()
+
+	for job := range jobs {
+		err := p.parse(job)
+		switch {
+		case err == nil:
+			parsed = append(parsed, job.ID)
+		case errors.Is(err, ErrLineUnparseable):
+			// Stored as a failed parse; retrying would give the same result
+			unparseable = append(unparseable, job.ID)
+		default:
+			// Given up on; the raw log is kept and can be reparsed
+			flush()
+			log.Printf("Giving up on log %s from server %s after %d attempts: %v", job.RawLogID, job.ServerID, maxParseAttempts, err)
+			if err := p.jobs.Fail(context.Background(), job.ID, err.Error()); err != nil {
+				log.Printf("Failed to record parse job failure %d: %v", job.ID, err)
+			}
+		}
+
+		// Mark jobs done in batches, or as soon as the queue runs dry
+		if len(parsed)+len(unparseable) >= completeBatchSize || len(jobs) == 0 {
+			flush()
+		}
+	}
+}
+
+// parse parses a job, retrying with backoff while storing it fails, so the
+// lines behind it are not parsed before it. Claims of the job by earlier
+// runs count as attempts.
+func (p *IngestPipeline) parse(job *entities.ParseJob) error {
+	delay := parseRetryDelay
+	for attempt := job.Attempts; ; attempt++ {
+		err := p.parser.ParseAndStore(job.RawLogID, job.ServerID, job.Content)
+		if err == nil || errors.Is(err, ErrLineUnparseable) || attempt >= maxParseAttempts {
+			return err
+		}
+		log.Printf("Failed to parse log %s from server %s, retrying in %s: %v", job.RawLogID, job.ServerID, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"regexp"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
+)
+
+// gameTimestampPattern matches the timestamp that starts every game log line,
+// either "L 01/17/2025 - 20:15:01: " (log files, UDP) or
+// "01/17/2025 - 20:15:01.123 - " (HTTP log streaming). It is anchored so a
+// date quoted later in the line, as in a chat message, is never taken for it.
+var gameTimestampPattern = regexp.MustCompile(`^\s*(?:L )?(\d{2}/\d{2}/\d{4} - \d{2}:\d{2}:\d{2}(?:\.\d+)?)(?:: | - )`)
+
+// lineHashPattern is the timestamp form line hashes were first computed
+// from. Stored hashes depend on how it splits a line into timestamp and
+// text, so that must not change. It is anchored after the "[time] uuid: "
+// prefix some senders add, so a timestamp quoted later in a streaming line
+// is never hashed in place of the line's own.
+var lineHashPattern = regexp.MustCompile(`^(?:\[[^\]]*\] )?(?:[0-9a-fA-F-]{36}: )?L (\d{2}/\d{2}/\d{4} - \d{2}:\d{2}:\d{2}(?:\.\d+)?): `)
+
+// IngestService parses saved log lines with the stateful parser. Both the
+// HTTP ingestion endpoint and the UDP listener queue lines on it, through
+// commands.IngestLogHandler, so multi-line JSON blocks are assembled by a
+// single parser instance.
+type IngestService struct {
+	statefulParser *StatefulParserService
+	pipeline       *IngestPipeline
+}
+
+// IngestConfig holds the parse worker pool settings
+type IngestConfig struct {
+	Workers        int              // number of ordered per-server queues
+	QueueSize      int              // lines waiting per server before requests are rejected
+	JobLease       time.Duration    // claimed jobs unfinished for this long are requeued; 0 for DefaultJobLease
+	BatchRetention time.Duration    // completed batches are purged after this long; 0 for DefaultBatchRetention
+	Rules          *ParseRuleEngine // custom parse rules; nil for none
+	Events         *EventHub        // live stream of stored events; nil for none
+}
+
+// NewIngestService creates a new ingest service. Parsed events and failed
+// parses are stored in parsedLogs and failedParses, stamped with the session,
+// round and phase found by trackers.
+func NewIngestService(db *sqlx.DB, parsedLogs repositories.ParsedLogRepository, failedParses repositories.FailedParseRepository, jobs ParseJobRepository, trackers EventTrackers, config IngestConfig) *IngestService {
+	statefulParser := NewStatefulParserService(db, parsedLogs, failedParses, trackers)
+	statefulParser.parser.UseRules(config.Rules)
+	statefulParser.parser.PublishTo(config.Events)
+	s := &IngestService{
+		statefulParser: statefulParser,
+		pipeline:       NewIngestPipeline(statefulParser, jobs, config.Workers, config.QueueSize, config.JobLease, config.BatchRetention),
+	}
+
+	// Start a cleanup goroutine to remove stale buffers
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.statefulParser.CleanupOldBuffers(10 * time.Minute)
+		}
+	}()
+
+	return s
+}
+
+// Start resumes unfinished parse jobs and starts the parse workers
+func (s *IngestService) Start(ctx context.Context) error {
+	return s.pipeline.Start(ctx)
+}
+
+// CanAccept reports whether the server's parse queue has room for n more
+// lines
+func (s *IngestService) CanAccept(serverID string, n int) bool {
+	return s.pipeline.CanAccept(serverID, n)
+}
+
+// Closed reports whether the parse workers have been shut down
+func (s *IngestService) Closed() bool {
+	return s.pipeline.Closed()
+}
+
+// Notify wakes the parse workers after new lines have been saved
+func (s *IngestService) Notify() {
+	s.pipeline.Notify()
+}
+
+// Shutdown stops accepting lines and waits for claimed lines to be parsed
+func (s *IngestService) Shutdown(ctx context.Context) error {
+	return s.pipeline.Shutdown(ctx)
+}
+
+// LineHash identifies a line by server, game timestamp and event text, so a
+// resent line matches even if the sender prefixed it differently. Lines
+// without a game timestamp return nil and are never treated as duplicates.
+// "L " lines hash as they always have; HTTP streaming lines, which have no
+// "L ", hash the same timestamp and text.
+func LineHash(serverID, line string) []byte {
+	loc := lineHashPattern.FindStringSubmatchIndex(line)
+	if loc == nil {
+		loc = gameTimestampPattern.FindStringSubmatchIndex(line)
+	}
+	if loc == nil {
+		return nil
+	}
+
+	h := sha256.New()
+	h.Write([]byte(serverID))
+	h.Write([]byte{0})
+	h.Write([]byte(line[loc[2]:loc[3]]))
+	h.Write([]byte{0})
+	h.Write([]byte(line[loc[1]:]))
+	return h.Sum(nil)
+}
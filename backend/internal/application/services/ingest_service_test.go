@@ -0,0 +1,55 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestLineHashMatchesStoredHashes(t *testing.T) {
+	// The hash stored for "L" lines before streaming lines were supported
+	h := sha256.New()
+	h.Write([]byte("server-1\x0001/17/2025 - 20:15:01\x00World triggered \"Round_Start\""))
+	want := h.Sum(nil)
+
+	lines := []string{
+		`L 01/17/2025 - 20:15:01: World triggered "Round_Start"`,
+		`01/17/2025 - 20:15:01 - World triggered "Round_Start"`,
+		`[2025-01-17T20:15:01Z] 18a5c248-c891-42a6-b72e-af0b184937c1: L 01/17/2025 - 20:15:01: World triggered "Round_Start"`,
+	}
+	for _, line := range lines {
+		if got := LineHash("server-1", line); !bytes.Equal(got, want) {
+			t.Errorf("LineHash(%q) = %x, want %x", line, got, want)
+		}
+	}
+}
+
+func TestLineHashIgnoresQuotedTimestamps(t *testing.T) {
+	// A chat message quoting a log line must not be hashed by the quote
+	want := LineHash("server-1", `L 01/17/2025 - 20:15:01: "Bob<2><[U:1:1]><CT>" say "L 01/18/2025 - 20:00:00: gg"`)
+	got := LineHash("server-1", `01/17/2025 - 20:15:01 - "Bob<2><[U:1:1]><CT>" say "L 01/18/2025 - 20:00:00: gg"`)
+	if !bytes.Equal(got, want) {
+		t.Errorf("streaming line hashed as %x, want %x", got, want)
+	}
+}
+
+func TestGameTimestampIsAnchored(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`L 01/17/2025 - 20:15:01: World triggered "Round_Start"`, "01/17/2025 - 20:15:01"},
+		{`01/17/2025 - 20:15:01.123 - World triggered "Round_Start"`, "01/17/2025 - 20:15:01.123"},
+		{`  L 01/17/2025 - 20:15:01: Log file started`, "01/17/2025 - 20:15:01"},
+		{`"Bob<2><[U:1:1]><CT>" say "see you 01/18/2025 - 20:00:00 - ok"`, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if match := gameTimestampPattern.FindStringSubmatch(tt.line); match != nil {
+			got = match[1]
+		}
+		if got != tt.want {
+			t.Errorf("timestamp of %q = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,575 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultParseRuleDryRunLimit = 1000
+	MaxParseRuleDryRunLimit     = 10000
+	parseRuleDryRunSamples      = 20
+)
+
+// ErrInvalidParseRule is returned for rules that cannot be compiled
+var ErrInvalidParseRule = errors.New("invalid parse rule")
+
+var parseRuleEventTypePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// parseRuleReservedFields are the event data fields every rule sets, which
+// named groups may not replace
+var parseRuleReservedFields = map[string]bool{"rule": true, "raw": true}
+
+// compiledParseRule is a validated rule with its compiled pattern
+type compiledParseRule struct {
+	rule    *entities.ParseRule
+	pattern *regexp.Regexp
+}
+
+// compileParseRule validates a rule and compiles its pattern
+func compileParseRule(rule *entities.ParseRule) (*compiledParseRule, error) {
+	switch {
+	case strings.TrimSpace(rule.Name) == "":
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidParseRule)
+	case !parseRuleEventTypePattern.MatchString(rule.EventType):
+		return nil, fmt.Errorf("%w %q: event_type must be lowercase letters, digits and underscores", ErrInvalidParseRule, rule.Name)
+	case rule.Stage != entities.ParseRuleBefore && rule.Stage != entities.ParseRuleAfter:
+		return nil, fmt.Errorf("%w %q: stage must be %q or %q", ErrInvalidParseRule, rule.Name, entities.ParseRuleBefore, entities.ParseRuleAfter)
+	}
+
+	pattern, err := regexp.Compile(rule.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidParseRule, rule.Name, err)
+	}
+
+	groups := make(map[string]bool)
+	for _, name := range pattern.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		// The rule name and raw line are always part of the event data
+		if root := strings.Split(name, "__")[0]; parseRuleReservedFields[root] {
+			return nil, fmt.Errorf("%w %q: group %q is reserved for the event data", ErrInvalidParseRule, rule.Name, name)
+		}
+		groups[name] = true
+	}
+	for field, fieldType := range rule.FieldTypes {
+		if !groups[field] {
+			return nil, fmt.Errorf("%w %q: field_types names %q, which is not a named group", ErrInvalidParseRule, rule.Name, field)
+		}
+		if !entities.ParseRuleFieldTypes[fieldType] {
+			return nil, fmt.Errorf("%w %q: unknown type %q of field %q", ErrInvalidParseRule, rule.Name, fieldType, field)
+		}
+	}
+
+	return &compiledParseRule{rule: rule, pattern: pattern}, nil
+}
+
+// eventData builds the event data of a matched line from the pattern's
+// named groups. Groups that did not take part in the match are left out.
+func (c *compiledParseRule) eventData(raw string, match []int) map[string]interface{} {
+	data := map[string]interface{}{
+		"rule": c.rule.Name,
+		"raw":  raw,
+	}
+	for i, name := range c.pattern.SubexpNames() {
+		if name == "" || match[2*i] < 0 {
+			continue
+		}
+		value := parseRuleFieldValue(raw[match[2*i]:match[2*i+1]], c.rule.FieldTypes[name])
+
+		// attacker__name is written as {"attacker": {"name": ...}}
+		path := strings.Split(name, "__")
+		parent := data
+		for _, key := range path[:len(path)-1] {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				parent[key] = child
+			}
+			parent = child
+		}
+		parent[path[len(path)-1]] = value
+	}
+	return data
+}
+
+// parseRuleFieldValue converts a group's text to its field type. Text that
+// does not convert is kept as it is.
+func parseRuleFieldValue(text, fieldType string) interface{} {
+	switch fieldType {
+	case "int":
+		if n, err := strconv.Atoi(text); err == nil {
+			return n
+		}
+	case "float":
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	case "bool":
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	return text
+}
+
+// ParseRuleEngine matches log lines against the loaded parse rules. Rules
+// can be replaced while lines are being parsed.
+type ParseRuleEngine struct {
+	mu      sync.RWMutex
+	rules   []*entities.ParseRule
+	before  []*compiledParseRule
+	after   []*compiledParseRule
+	version string
+}
+
+// NewParseRuleEngine creates an engine with no rules
+func NewParseRuleEngine() *ParseRuleEngine {
+	return &ParseRuleEngine{}
+}
+
+// Load replaces the rules with the enabled ones of rules, in order of
+// priority. The current rules are kept when any rule is invalid.
+func (e *ParseRuleEngine) Load(rules []*entities.ParseRule) error {
+	enabled := make([]*entities.ParseRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = append(enabled, rule)
+		}
+	}
+	sort.SliceStable(enabled, func(i, j int) bool {
+		return enabled[i].Priority < enabled[j].Priority
+	})
+
+	var before, after []*compiledParseRule
+	for _, rule := range enabled {
+		compiled, err := compileParseRule(rule)
+		if err != nil {
+			return err
+		}
+		if rule.Stage == entities.ParseRuleBefore {
+			before = append(before, compiled)
+		} else {
+			after = append(after, compiled)
+		}
+	}
+
+	version, err := parseRuleSetVersion(enabled)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules, e.before, e.after, e.version = enabled, before, after, version
+	return nil
+}
+
+// Version identifies the loaded rules by what they match and produce; it is
+// empty when no rules are loaded. A nil engine has no rules.
+func (e *ParseRuleEngine) Version() string {
+	if e == nil {
+		return ""
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.version
+}
+
+// parseRuleSetVersion hashes the parts of the rules, in the order they run,
+// that decide how lines are classified
+func parseRuleSetVersion(rules []*entities.ParseRule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+	h := sha256.New()
+	for _, rule := range rules {
+		// Maps are marshalled with sorted keys
+		part, err := json.Marshal([]interface{}{
+			rule.Name, rule.Pattern, rule.EventType, rule.Stage, rule.Priority, rule.FieldTypes,
+		})
+		if err != nil {
+			return "", fmt.Errorf("hash parse rule %q: %w", rule.Name, err)
+		}
+		h.Write(part)
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// Rules returns the loaded rules in the order they run
+func (e *ParseRuleEngine) Rules() []*entities.ParseRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.rules
+}
+
+// match runs the rules of a stage against a line, without its log
+// timestamp, and returns the event of the first rule that matches. A nil
+// engine matches nothing.
+func (e *ParseRuleEngine) match(stage entities.ParseRuleStage, actualContent string) *parsedLine {
+	if e == nil {
+		return nil
+	}
+	e.mu.RLock()
+	rules := e.after
+	if stage == entities.ParseRuleBefore {
+		rules = e.before
+	}
+	e.mu.RUnlock()
+
+	raw := logTimestampPrefix.ReplaceAllString(actualContent, "")
+	for _, rule := range rules {
+		match := rule.pattern.FindStringSubmatchIndex(raw)
+		if match == nil {
+			continue
+		}
+		eventData, err := json.Marshal(rule.eventData(raw, match))
+		if err != nil {
+			continue
+		}
+		return &parsedLine{
+			ActualContent: actualContent,
+			EventType:     rule.rule.EventType,
+			EventData:     string(eventData),
+			Source:        entities.ClassificationRule,
+			Rule:          rule.rule.Name,
+		}
+	}
+	return nil
+}
+
+// ParseRuleRepository stores the parse rules managed through the API
+type ParseRuleRepository interface {
+	List(ctx context.Context) ([]*entities.ParseRule, error)
+	FindEnabled(ctx context.Context) ([]*entities.ParseRule, error)
+	FindByID(ctx context.Context, id string) (*entities.ParseRule, error)
+	Version(ctx context.Context) (string, error)
+	Create(ctx context.Context, rule *entities.ParseRule) error
+	Update(ctx context.Context, rule *entities.ParseRule) error
+	Delete(ctx context.Context, id string) error
+	FindDryRunLines(ctx context.Context, serverID string, from, to *time.Time, limit int) ([]*entities.ReparseLine, error)
+}
+
+// ParseRuleService loads parse rules from a YAML or JSON file and the
+// database into an engine, and reloads them when either changes. Rules from
+// the file are read-only.
+type ParseRuleService struct {
+	repo   ParseRuleRepository
+	engine *ParseRuleEngine
+	file   string
+
+	mu          sync.Mutex
+	fileRules   []*entities.ParseRule
+	fileModTime time.Time
+	version     string
+}
+
+// NewParseRuleService creates a new parse rule service. file may be empty.
+func NewParseRuleService(repo ParseRuleRepository, engine *ParseRuleEngine, file string) *ParseRuleService {
+	return &ParseRuleService{
+		repo:   repo,
+		engine: engine,
+		file:   file,
+	}
+}
+
+// fileParseRule is a rule in the rules file; rules are enabled unless they
+// say otherwise
+type fileParseRule struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description" yaml:"description"`
+	Pattern     string            `json:"pattern" yaml:"pattern"`
+	EventType   string            `json:"event_type" yaml:"event_type"`
+	Stage       string            `json:"stage" yaml:"stage"`
+	Priority    int               `json:"priority" yaml:"priority"`
+	FieldTypes  map[string]string `json:"field_types" yaml:"field_types"`
+	Enabled     *bool             `json:"enabled" yaml:"enabled"`
+}
+
+// readFile reads the rules file; .json files are JSON and anything else YAML
+func (s *ParseRuleService) readFile() ([]*entities.ParseRule, time.Time, error) {
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read parse rules file: %w", err)
+	}
+	content, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("read parse rules file: %w", err)
+	}
+
+	var file struct {
+		Rules []fileParseRule `json:"rules" yaml:"rules"`
+	}
+	if strings.EqualFold(filepath.Ext(s.file), ".json") {
+		err = json.Unmarshal(content, &file)
+	} else {
+		err = yaml.Unmarshal(content, &file)
+	}
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("parse rules file %s: %w", s.file, err)
+	}
+
+	rules := make([]*entities.ParseRule, 0, len(file.Rules))
+	for _, r := range file.Rules {
+		rule := &entities.ParseRule{
+			ID:          "file:" + r.Name,
+			Name:        r.Name,
+			Description: r.Description,
+			Pattern:     r.Pattern,
+			EventType:   r.EventType,
+			Stage:       entities.ParseRuleStage(r.Stage),
+			Priority:    r.Priority,
+			FieldTypes:  r.FieldTypes,
+			Enabled:     r.Enabled == nil || *r.Enabled,
+			Source:      entities.ParseRuleSourceFile,
+		}
+		if rule.Stage == "" {
+			rule.Stage = entities.ParseRuleAfter
+		}
+		rules = append(rules, rule)
+	}
+	return rules, info.ModTime(), nil
+}
+
+// Reload loads the rules from the file and the database. The current rules
+// are kept when any of them is invalid.
+func (s *ParseRuleService) Reload(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Read the version first so changes made while loading trigger another
+	// reload
+	version, err := s.repo.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	var fileRules []*entities.ParseRule
+	var modTime time.Time
+	if s.file != "" {
+		if fileRules, modTime, err = s.readFile(); err != nil {
+			return err
+		}
+	}
+	dbRules, err := s.repo.FindEnabled(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := s.engine.Load(append(append([]*entities.ParseRule{}, fileRules...), dbRules...)); err != nil {
+		return err
+	}
+	s.fileRules, s.fileModTime, s.version = fileRules, modTime, version
+	return nil
+}
+
+// Watch reloads the rules in the background whenever the file or the
+// stored rules change, until ctx is done
+func (s *ParseRuleService) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			changed, err := s.changed(ctx)
+			if err != nil {
+				log.Printf("Failed to check parse rules for changes: %v", err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err := s.Reload(ctx); err != nil {
+				log.Printf("Failed to reload parse rules: %v", err)
+				continue
+			}
+			log.Printf("Reloaded %d parse rules", len(s.engine.Rules()))
+		}
+	}()
+}
+
+// changed reports whether the file or the stored rules changed since they
+// were last loaded
+func (s *ParseRuleService) changed(ctx context.Context) (bool, error) {
+	version, err := s.repo.Version(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version != s.version {
+		return true, nil
+	}
+	if s.file == "" {
+		return false, nil
+	}
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return false, err
+	}
+	return !info.ModTime().Equal(s.fileModTime), nil
+}
+
+// List lists the rules of the file and the database, enabled or not, in
+// the order they run
+func (s *ParseRuleService) List(ctx context.Context) ([]*entities.ParseRule, error) {
+	dbRules, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	rules := append(append([]*entities.ParseRule{}, s.fileRules...), dbRules...)
+	s.mu.Unlock()
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+	return rules, nil
+}
+
+// Get gets a rule of the file or the database
+func (s *ParseRuleService) Get(ctx context.Context, id string) (*entities.ParseRule, error) {
+	if strings.HasPrefix(id, "file:") {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, rule := range s.fileRules {
+			if rule.ID == id {
+				return rule, nil
+			}
+		}
+		return nil, fmt.Errorf("parse rule not found")
+	}
+	return s.repo.FindByID(ctx, id)
+}
+
+// Create validates and stores a rule, then reloads the rules
+func (s *ParseRuleService) Create(ctx context.Context, rule *entities.ParseRule) error {
+	if _, err := compileParseRule(rule); err != nil {
+		return err
+	}
+	if err := s.repo.Create(ctx, rule); err != nil {
+		return err
+	}
+	s.reloadAfterChange(ctx)
+	return nil
+}
+
+// Update validates and stores the changes to a rule, then reloads the rules
+func (s *ParseRuleService) Update(ctx context.Context, rule *entities.ParseRule) error {
+	if _, err := compileParseRule(rule); err != nil {
+		return err
+	}
+	if err := s.repo.Update(ctx, rule); err != nil {
+		return err
+	}
+	s.reloadAfterChange(ctx)
+	return nil
+}
+
+// Delete deletes a rule, then reloads the rules
+func (s *ParseRuleService) Delete(ctx context.Context, id string) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.reloadAfterChange(ctx)
+	return nil
+}
+
+// reloadAfterChange reloads the rules after a stored rule changed. The
+// change is saved either way; a rules file that no longer loads is logged.
+func (s *ParseRuleService) reloadAfterChange(ctx context.Context) {
+	if err := s.Reload(ctx); err != nil {
+		log.Printf("Failed to reload parse rules: %v", err)
+	}
+}
+
+// DryRun runs a rule, along with the loaded rules, against up to limit of
+// the most recent raw logs of a server and receive time range without
+// storing anything. A rule with the ID of a loaded rule replaces it.
+func (s *ParseRuleService) DryRun(ctx context.Context, rule *entities.ParseRule, serverID string, from, to *time.Time, limit int) (*entities.ParseRuleDryRun, error) {
+	if limit <= 0 {
+		limit = DefaultParseRuleDryRunLimit
+	}
+	if limit > MaxParseRuleDryRunLimit {
+		limit = MaxParseRuleDryRunLimit
+	}
+
+	candidate := *rule
+	candidate.Enabled = true
+	rules := []*entities.ParseRule{&candidate}
+	for _, loaded := range s.engine.Rules() {
+		if loaded.Name != candidate.Name && (candidate.ID == "" || loaded.ID != candidate.ID) {
+			rules = append(rules, loaded)
+		}
+	}
+	engine := NewParseRuleEngine()
+	if err := engine.Load(rules); err != nil {
+		return nil, err
+	}
+	parser := NewParserService(nil)
+	parser.UseRules(engine)
+
+	lines, err := s.repo.FindDryRunLines(ctx, serverID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &entities.ParseRuleDryRun{Samples: []*entities.ParseRuleDryRunMatch{}}
+	for _, line := range lines {
+		result.Scanned++
+		if isJSONStatsLine(parser.ExtractActualContent(line.Content)) {
+			continue
+		}
+		parsed, err := parser.parseLine(line.Content)
+		if err != nil || parsed.Rule != candidate.Name {
+			continue
+		}
+
+		result.Matched++
+		current := ""
+		if len(line.EventTypes) > 0 {
+			current = line.EventTypes[0]
+		}
+		if current != parsed.EventType {
+			result.Changed++
+		}
+		if len(result.Samples) < parseRuleDryRunSamples {
+			match := &entities.ParseRuleDryRunMatch{
+				RawLogID:         line.RawLogID,
+				ServerID:         line.ServerID,
+				Content:          line.Content,
+				CurrentEventType: current,
+				EventType:        parsed.EventType,
+			}
+			match.EventData, _ = decodeEventData(parsed.EventData)
+			result.Samples = append(result.Samples, match)
+		}
+	}
+	return result, nil
+}
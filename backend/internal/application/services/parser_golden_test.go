@@ -0,0 +1,213 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// update regenerates the golden files of the parser corpus:
+//
+//	go test ./internal/application/services -run TestParserGolden -update
+var update = flag.Bool("update", false, "regenerate the golden files of the parser corpus")
+
+// goldenDir holds the parser corpus. Each .log file has a .golden.json file
+// next to it with what ParseLogLine returns for each of its lines.
+const goldenDir = "testdata/parser"
+
+// maxGoldenDiffLines caps the changed lines printed per corpus file; the
+// summary of event type changes always covers every line
+const maxGoldenDiffLines = 25
+
+// goldenLine is the expected result of parsing one line of a corpus file
+type goldenLine struct {
+	EventType string          `json:"event_type,omitempty"`
+	EventData json.RawMessage `json:"event_data,omitempty"`
+	Error     string          `json:"error,omitempty"` // set when the line does not parse
+}
+
+func TestParserGolden(t *testing.T) {
+	corpus, err := filepath.Glob(filepath.Join(goldenDir, "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(corpus) == 0 {
+		t.Fatalf("no corpus files in %s", goldenDir)
+	}
+
+	parser := NewParserService(nil)
+	for _, logPath := range corpus {
+		name := strings.TrimSuffix(filepath.Base(logPath), ".log")
+		t.Run(name, func(t *testing.T) {
+			lines := readCorpus(t, logPath)
+			got := make([]goldenLine, len(lines))
+			for i, line := range lines {
+				got[i] = parseGoldenLine(parser, line)
+			}
+
+			goldenPath := strings.TrimSuffix(logPath, ".log") + ".golden.json"
+			want, err := readGolden(goldenPath)
+			if err != nil && !(*update && os.IsNotExist(err)) {
+				t.Fatalf("%v; run with -update to create it", err)
+			}
+
+			diff := diffGolden(lines, want, got)
+			if *update {
+				if err := writeGolden(goldenPath, got); err != nil {
+					t.Fatal(err)
+				}
+				if diff != "" {
+					t.Logf("updated %s:\n%s", goldenPath, diff)
+				}
+				return
+			}
+			if diff != "" {
+				t.Errorf("%s does not match %s; run with -update if the changes are intended:\n%s",
+					logPath, goldenPath, diff)
+			}
+		})
+	}
+}
+
+// readCorpus reads the non-empty lines of a corpus file
+func readCorpus(t *testing.T, path string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseGoldenLine parses a line into its golden form
+func parseGoldenLine(parser *ParserService, line string) goldenLine {
+	parsed, err := parser.ParseLogLine(line)
+	if err != nil {
+		return goldenLine{Error: err.Error()}
+	}
+	return goldenLine{
+		EventType: parsed.EventType,
+		EventData: json.RawMessage(strings.TrimSpace(parsed.EventData.(string))),
+	}
+}
+
+func readGolden(path string) ([]goldenLine, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []goldenLine
+	if err := json.Unmarshal(content, &lines); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return lines, nil
+}
+
+// writeGolden writes one line of the corpus per line of the file, so
+// changes show up line by line in review
+func writeGolden(path string, lines []goldenLine) error {
+	var b bytes.Buffer
+	b.WriteString("[\n")
+	for i, line := range lines {
+		var entry bytes.Buffer
+		enc := json.NewEncoder(&entry)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(line); err != nil {
+			return fmt.Errorf("encode line %d: %w", i+1, err)
+		}
+		b.Write(bytes.TrimSuffix(entry.Bytes(), []byte("\n")))
+		if i < len(lines)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("]\n")
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// diffGolden describes how the parsed lines differ from the expected ones:
+// a summary of how many lines moved from one event type to another, then
+// the changed lines themselves. It returns "" when nothing changed.
+func diffGolden(lines []string, want, got []goldenLine) string {
+	typeChanges := make(map[string]int)
+	var changed []string
+	for i := range got {
+		var expected goldenLine
+		if i < len(want) {
+			expected = want[i]
+		}
+		if sameGoldenLine(expected, got[i]) {
+			continue
+		}
+
+		from, to := goldenLineType(expected), goldenLineType(got[i])
+		if i >= len(want) {
+			from = "(new line)"
+		}
+		if from != to {
+			typeChanges[from+" -> "+to]++
+			changed = append(changed, fmt.Sprintf("line %d: %s -> %s\n\t%s", i+1, from, to, lines[i]))
+		} else {
+			typeChanges[to+": event data changed"]++
+			changed = append(changed, fmt.Sprintf("line %d: %s: event data changed\n\t%s\n\t- %s\n\t+ %s",
+				i+1, to, lines[i], expected.EventData, got[i].EventData))
+		}
+	}
+	if len(want) > len(got) {
+		typeChanges[fmt.Sprintf("(%d lines removed from the corpus)", len(want)-len(got))]++
+	}
+	if len(typeChanges) == 0 && len(changed) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	summary := make([]string, 0, len(typeChanges))
+	for change := range typeChanges {
+		summary = append(summary, change)
+	}
+	sort.Strings(summary)
+	for _, change := range summary {
+		fmt.Fprintf(&b, "  %4d  %s\n", typeChanges[change], change)
+	}
+	for i, line := range changed {
+		if i == maxGoldenDiffLines {
+			fmt.Fprintf(&b, "... and %d more changed lines\n", len(changed)-i)
+			break
+		}
+		fmt.Fprintf(&b, "%s\n", line)
+	}
+	return b.String()
+}
+
+// goldenLineType is the event type of a line, or why it has none
+func goldenLineType(line goldenLine) string {
+	if line.Error != "" {
+		return "(parse error)"
+	}
+	return line.EventType
+}
+
+// sameGoldenLine compares two lines, with event data compared as JSON values
+func sameGoldenLine(a, b goldenLine) bool {
+	if a.EventType != b.EventType || a.Error != b.Error {
+		return false
+	}
+	var dataA, dataB interface{}
+	json.Unmarshal(a.EventData, &dataA)
+	json.Unmarshal(b.EventData, &dataB)
+	return reflect.DeepEqual(dataA, dataB)
+}
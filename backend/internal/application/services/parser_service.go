@@ -1,64 +1,315 @@
 package services
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	cs2log "github.com/noueii/cs2-log"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
 )
 
+// ErrLineUnparseable is returned when a line could not be parsed and was
+// stored as a failed parse
+var ErrLineUnparseable = errors.New("log line could not be parsed")
+
+// parserRevision is the version of the classification done in this module.
+// Bump it when the classification changes, then reparse the stale rows.
+const parserRevision = "3"
+
+// cs2logModule is the module path of the cs2-log parser
+const cs2logModule = "github.com/noueii/cs2-log"
+
+// ParserVersion identifies how lines are parsed and classified and is stored
+// on every parsed and failed row: the local revision and the version of the
+// cs2-log module the binary was built with, as in "3+cs2-log@v0.4.1", so
+// updating the dependency makes older rows stale by itself. A cs2-log
+// replaced by a local checkout has no real version ("(devel)" or the
+// placeholder it replaces); bump parserRevision when the checkout changes.
+//
+// Rows also depend on the parse rules; ParserService.Version adds the loaded
+// rule set to this version.
+var ParserVersion = parserVersion()
+
+// parserVersion reads the cs2-log module version from the build info
+func parserVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return parserRevision
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != cs2logModule {
+			continue
+		}
+		version := dep.Version
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			version = dep.Replace.Version
+		}
+		return parserRevision + "+cs2-log@" + version
+	}
+	return parserRevision
+}
+
+// serverLocationTTL is how long a server's timezone setting is cached
+const serverLocationTTL = time.Minute
+
+// gameTimeLayout is the log clock format; parsing also accepts milliseconds
+const gameTimeLayout = "01/02/2006 - 15:04:05"
+
+type cachedLocation struct {
+	location  *time.Location
+	expiresAt time.Time
+}
+
 // ParserService handles CS2 log parsing
 type ParserService struct {
-	db *sqlx.DB
+	db           *sqlx.DB
+	parsedLogs   repositories.ParsedLogRepository
+	failedParses repositories.FailedParseRepository
+	trackers     EventTrackers
+	rules        *ParseRuleEngine
+	events       *EventHub
+
+	locationMu sync.Mutex
+	locations  map[string]cachedLocation
 }
 
 // NewParserService creates a new parser service
 func NewParserService(db *sqlx.DB) *ParserService {
 	return &ParserService{
-		db: db,
+		db:        db,
+		locations: make(map[string]cachedLocation),
 	}
 }
 
+// UseRules makes the parser classify lines with custom parse rules
+func (s *ParserService) UseRules(rules *ParseRuleEngine) {
+	s.rules = rules
+}
+
+// Version is the version stored on the rows the parser produces: the
+// ParserVersion and, when parse rules are loaded, the version of the rule
+// set, so editing a rule makes the rows it may affect stale
+func (s *ParserService) Version() string {
+	if rules := s.rules.Version(); rules != "" {
+		return ParserVersion + "+rules@" + rules
+	}
+	return ParserVersion
+}
+
+// PublishTo makes the parser publish every event it stores to a hub
+func (s *ParserService) PublishTo(events *EventHub) {
+	s.events = events
+}
+
 // ParseAndStore parses a raw log and stores the result
 func (s *ParserService) ParseAndStore(rawLogID, serverID, content string) error {
+	line, err := s.parseLine(content)
+	if err != nil {
+		// Store as failed parse
+		if storeErr := s.storeFailedParse(rawLogID, err.Error()); storeErr != nil {
+			return storeErr
+		}
+		return fmt.Errorf("%w: %v", ErrLineUnparseable, err)
+	}
+	
+	eventData, err := decodeEventData(line.EventData)
+	if err != nil {
+		return err
+	}
+	stored := s.newStoredEvent(rawLogID, serverID, line.EventType, eventData, s.EventTime(serverID, content))
+	stored.ClassificationSource = line.Source
+	stored.Confidence = line.Confidence
+	stored.ParseRule = line.Rule
+	
+	// Find the session, round and phase the event belongs to
+	if err := s.trackEvent(stored, line.ActualContent); err != nil {
+		return err
+	}
+	
+	// Store parsed log
+	if err := s.parsedLogs.Create(context.Background(), stored); err != nil {
+		if errors.Is(err, repositories.ErrParsedLogExists) {
+			// The line was parsed before its job was handed out again
+			return nil
+		}
+		return err
+	}
+	
+	// Link the event to the players it involves. The event is already
+	// stored, so a failure is logged rather than returned: retrying the line
+	// would store the event a second time.
+	if err := s.trackers.recordPlayers(context.Background(), stored, line.ActualContent); err != nil {
+		log.Printf("Failed to record players of parsed log %s: %v", stored.ID, err)
+	}
+	
+	// Stream the event once everything about it is stored
+	s.events.Publish(stored)
+	return nil
+}
+
+// parsedLine is a log line parsed into its event type and JSON event data
+type parsedLine struct {
+	ActualContent string // the line without our prefixes
+	EventType     string
+	EventData     string
+	Source        string   // entities.ClassificationParser, ClassificationRule or ClassificationHeuristic
+	Confidence    *float64 // set for heuristic classifications
+	Rule          string   // name of the parse rule that matched
+}
+
+// parseLine parses a stored log line. Parse rules of the before stage run
+// first. Lines cs2-log cannot parse, or only parses as Unknown, go through
+// the rules of the after stage and then the heuristics.
+func (s *ParserService) parseLine(content string) (*parsedLine, error) {
 	// Extract the actual CS2 log content from our custom format
 	actualContent := s.ExtractActualContent(content)
 	
+	if line := s.rules.match(entities.ParseRuleBefore, actualContent); line != nil {
+		return line, nil
+	}
+	
 	// Try to parse the log using the enhanced Parse function with custom events
 	parsedLog, err := cs2log.ParseEnhanced(actualContent)
-	
-	if err != nil {
-		// Store as failed parse
-		return s.storeFailedParse(rawLogID, err.Error())
+	var unknown *cs2log.Unknown
+	if msg, ok := parsedLog.(cs2log.Unknown); err == nil && ok {
+		unknown = &msg
+	}
+	if err != nil || unknown != nil {
+		if line := s.rules.match(entities.ParseRuleAfter, actualContent); line != nil {
+			return line, nil
+		}
+		if guess := s.classifyHeuristically(actualContent, unknown); guess != nil {
+			return guess, nil
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 	
 	// Convert to JSON using the library's ToJSON function
-	eventData := cs2log.ToJSON(parsedLog)
-	
-	// Determine event type
-	eventType := s.getEventType(parsedLog)
-	
-	// Store parsed log
-	query := `
-		INSERT INTO parsed_logs (raw_log_id, server_id, event_type, event_data, created_at)
-		VALUES ($1, $2, $3, $4, $5)
-	`
+	return &parsedLine{
+		ActualContent: actualContent,
+		EventType:     s.getEventType(parsedLog),
+		EventData:     cs2log.ToJSON(parsedLog),
+		Source:        entities.ClassificationParser,
+	}, nil
+}
+
+// trackEvent runs an event through the event trackers and stamps it with
+// where in the match it happened. content is the log line. The event is left
+// as it is when no trackers are configured.
+func (s *ParserService) trackEvent(parsed *entities.ParsedLog, content string) error {
+	if !s.trackers.enabled() {
+		return nil
+	}
 	
-	_, err = s.db.Exec(query, rawLogID, serverID, eventType, eventData, time.Now())
-	return err
+	eventCtx, err := s.trackers.track(context.Background(), parsed, content)
+	if err != nil {
+		return err
+	}
+	parsed.SessionID = ""
+	if eventCtx.SessionID != nil {
+		parsed.SessionID = *eventCtx.SessionID
+	}
+	parsed.RoundNumber = eventCtx.RoundNumber
+	parsed.GamePhase = eventCtx.GamePhase
+	return nil
+}
+
+// newStoredEvent is an event ready to be tracked and stored
+func (s *ParserService) newStoredEvent(rawLogID, serverID, eventType string, eventData map[string]interface{}, eventTime *time.Time) *entities.ParsedLog {
+	return &entities.ParsedLog{
+		RawLogID:      rawLogID,
+		ServerID:      serverID,
+		EventType:     eventType,
+		EventData:     eventData,
+		EventTime:     eventTime,
+		ParserVersion: s.Version(),
+		CreatedAt:     time.Now(),
+	}
+}
+
+// decodeEventData decodes the JSON of an event. Numbers are kept as written,
+// as SteamID64s do not fit a float64.
+func decodeEventData(eventData string) (map[string]interface{}, error) {
+	if eventData == "" {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(eventData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("decode event data: %w", err)
+	}
+	return data, nil
+}
+
+// EventTime reads the game timestamp of a log line, with milliseconds when
+// present, in the server's configured timezone. It returns nil when the line
+// has no timestamp.
+func (s *ParserService) EventTime(serverID, content string) *time.Time {
+	match := gameTimestampPattern.FindStringSubmatch(content)
+	if match == nil {
+		return nil
+	}
+
+	eventTime, err := time.ParseInLocation(gameTimeLayout, match[1], s.serverLocation(serverID))
+	if err != nil {
+		return nil
+	}
+	return &eventTime
+}
+
+// serverLocation returns the timezone a server's log clock runs in, UTC
+// unless the server has one configured
+func (s *ParserService) serverLocation(serverID string) *time.Location {
+	s.locationMu.Lock()
+	cached, ok := s.locations[serverID]
+	s.locationMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.location
+	}
+
+	// Without a database, as in tests, every server logs in UTC
+	location := time.UTC
+	var timezone sql.NullString
+	err := sql.ErrNoRows
+	if s.db != nil {
+		err = s.db.Get(&timezone, `SELECT timezone FROM servers WHERE id = $1`, serverID)
+	}
+	if err == nil && timezone.Valid && timezone.String != "" {
+		if loaded, err := time.LoadLocation(timezone.String); err == nil {
+			location = loaded
+		}
+	}
+
+	s.locationMu.Lock()
+	s.locations[serverID] = cachedLocation{location: location, expiresAt: time.Now().Add(serverLocationTTL)}
+	s.locationMu.Unlock()
+
+	return location
 }
 
 // storeFailedParse stores a failed parse attempt
 func (s *ParserService) storeFailedParse(rawLogID, errorMsg string) error {
-	query := `
-		INSERT INTO failed_parses (raw_log_id, error_message, created_at)
-		VALUES ($1, $2, $3)
-	`
-	
-	_, err := s.db.Exec(query, rawLogID, errorMsg, time.Now())
-	return err
+	return s.failedParses.Create(context.Background(), &entities.FailedParse{
+		ID:            uuid.New().String(),
+		RawLogID:      rawLogID,
+		ErrorMessage:  errorMsg,
+		ParserVersion: s.Version(),
+		CreatedAt:     time.Now(),
+	})
 }
 
 // getEventType determines the event type from parsed log
@@ -258,8 +509,10 @@ func (s *ParserService) getEventType(parsedLog cs2log.Message) string {
 
 // ParsedLog represents a parsed log result
 type ParsedLog struct {
-	EventType string      `json:"event_type"`
-	EventData interface{} `json:"event_data"`
+	EventType            string      `json:"event_type"`
+	EventData            interface{} `json:"event_data"`
+	ClassificationSource string      `json:"classification_source"`
+	Confidence           *float64    `json:"classification_confidence,omitempty"`
 }
 
 // ExtractActualContent strips prefixes from log content
@@ -318,29 +571,21 @@ func (s *ParserService) ExtractActualContent(content string) string {
 
 // ParseLogLine parses a single log line and returns the result
 func (s *ParserService) ParseLogLine(content string) (*ParsedLog, error) {
-	// Extract the actual CS2 log content from our custom format
-	actualContent := s.ExtractActualContent(content)
-	
-	// Try to parse the log using the enhanced Parse function with custom events
-	parsedLog, err := cs2log.ParseEnhanced(actualContent)
-	
+	line, err := s.parseLine(content)
 	if err != nil {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
 	
-	// Convert to JSON using the library's ToJSON function
-	eventData := cs2log.ToJSON(parsedLog)
-	
-	// Determine event type
-	eventType := s.getEventType(parsedLog)
-	
 	return &ParsedLog{
-		EventType: eventType,
-		EventData: eventData,
+		EventType:            line.EventType,
+		EventData:            line.EventData,
+		ClassificationSource: line.Source,
+		Confidence:           line.Confidence,
 	}, nil
 }
 
-// classifyUnknownEvent tries to classify unknown events based on their content
+// classifyUnknownEvent tries to classify unknown events based on their
+// content. It returns "unknown_other" when no heuristic matches.
 func (s *ParserService) classifyUnknownEvent(raw string) string {
 	// Check for common patterns in unknown events
 	switch {
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// playerMatchEventTypes are the event types, as classified by
+// ParserService.getEventType, that show a player took part in a match
+var playerMatchEventTypes = []string{"kill", "attack", "purchase"}
+
+const (
+	favouriteWeaponCount = 5
+	recentFormMatches    = 5
+)
+
+// PlayerFinder finds a player in the player registry
+type PlayerFinder interface {
+	FindBySteamID64(ctx context.Context, steamID64 string) (*entities.Player, error)
+}
+
+// PlayerSessionReader finds the sessions a player took part in, their events
+// and the stored player totals of finished sessions
+type PlayerSessionReader interface {
+	SessionEventReader
+	FindByPlayer(ctx context.Context, steamID64 string, eventTypes []string, limit, offset int) ([]*entities.GameSession, int64, error)
+	FindPlayerTotals(ctx context.Context, steamID64 string, sessionIDs []string) (map[string]*entities.SessionPlayerTotals, error)
+	SavePlayerTotals(ctx context.Context, totals []*entities.SessionPlayerTotals) error
+}
+
+// PlayerProfileService works out a player's statistics from the scoreboards
+// of the sessions they played in. The scores of a finished session are
+// stored for all its players the first time it is read, so only sessions in
+// progress are rebuilt from their events.
+type PlayerProfileService struct {
+	players  PlayerFinder
+	sessions PlayerSessionReader
+}
+
+// NewPlayerProfileService creates a new player profile service
+func NewPlayerProfileService(players PlayerFinder, sessions PlayerSessionReader) *PlayerProfileService {
+	return &PlayerProfileService{
+		players:  players,
+		sessions: sessions,
+	}
+}
+
+// Profile builds a player's lifetime statistics, favourite weapons, per-map
+// statistics and recent form over all the sessions they played in
+func (s *PlayerProfileService) Profile(ctx context.Context, steamID64 string) (*entities.PlayerProfile, error) {
+	player, err := s.players.FindBySteamID64(ctx, steamID64)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, _, err := s.sessions.FindByPlayer(ctx, steamID64, playerMatchEventTypes, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := s.matches(ctx, player, sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &entities.PlayerProfile{
+		Player:           player,
+		FavouriteWeapons: []*entities.WeaponStats{},
+		Maps:             []*entities.MapStats{},
+		RecentForm:       entities.RecentForm{Results: []entities.MatchResult{}},
+	}
+	weapons := make(map[string]*entities.WeaponStats)
+	maps := make(map[string]*entities.MapStats)
+
+	// Sessions come most recent first
+	for i, session := range sessions {
+		match := matches[i]
+		if match.Score.RoundsPlayed == 0 {
+			continue
+		}
+		addWeaponKills(weapons, match.weapons)
+
+		addMatch(&profile.Lifetime, match.PlayerMatch)
+
+		mapStats, ok := maps[session.MapName]
+		if !ok {
+			mapStats = &entities.MapStats{MapName: session.MapName}
+			maps[session.MapName] = mapStats
+			profile.Maps = append(profile.Maps, mapStats)
+		}
+		addMatch(&mapStats.PlayerStats, match.PlayerMatch)
+
+		if match.Result != "" && len(profile.RecentForm.Results) < recentFormMatches {
+			profile.RecentForm.Results = append(profile.RecentForm.Results, match.Result)
+			addMatch(&profile.RecentForm.Stats, match.PlayerMatch)
+		}
+	}
+
+	finishStats(&profile.Lifetime)
+	finishStats(&profile.RecentForm.Stats)
+	for _, mapStats := range profile.Maps {
+		finishStats(&mapStats.PlayerStats)
+	}
+	sort.SliceStable(profile.Maps, func(i, j int) bool {
+		return profile.Maps[i].Matches > profile.Maps[j].Matches
+	})
+
+	for _, weapon := range weapons {
+		if weapon.Kills > 0 {
+			weapon.HeadshotPct = round2(100 * float64(weapon.HeadshotKills) / float64(weapon.Kills))
+		}
+		profile.FavouriteWeapons = append(profile.FavouriteWeapons, weapon)
+	}
+	sort.Slice(profile.FavouriteWeapons, func(i, j int) bool {
+		a, b := profile.FavouriteWeapons[i], profile.FavouriteWeapons[j]
+		if a.Kills != b.Kills {
+			return a.Kills > b.Kills
+		}
+		return a.Weapon < b.Weapon
+	})
+	if len(profile.FavouriteWeapons) > favouriteWeaponCount {
+		profile.FavouriteWeapons = profile.FavouriteWeapons[:favouriteWeaponCount]
+	}
+
+	return profile, nil
+}
+
+// Matches lists the sessions a player played in, most recent first, with
+// their score in each, along with the total number of sessions
+func (s *PlayerProfileService) Matches(ctx context.Context, steamID64 string, limit, offset int) ([]*entities.PlayerMatch, int64, error) {
+	player, err := s.players.FindBySteamID64(ctx, steamID64)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sessions, total, err := s.sessions.FindByPlayer(ctx, steamID64, playerMatchEventTypes, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	playerMatches, err := s.matches(ctx, player, sessions)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	matches := make([]*entities.PlayerMatch, 0, len(playerMatches))
+	for _, match := range playerMatches {
+		matches = append(matches, match.PlayerMatch)
+	}
+	return matches, total, nil
+}
+
+// playerMatch is a player's match with their kills by weapon
+type playerMatch struct {
+	*entities.PlayerMatch
+	weapons []*entities.WeaponStats
+}
+
+// matches picks out the player's score in each session, from the stored
+// totals of finished sessions or else from the session's scoreboard
+func (s *PlayerProfileService) matches(ctx context.Context, player *entities.Player, sessions []*entities.GameSession) ([]*playerMatch, error) {
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Status != entities.SessionStatusActive {
+			sessionIDs = append(sessionIDs, session.ID)
+		}
+	}
+	stored, err := s.sessions.FindPlayerTotals(ctx, player.SteamID64, sessionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*playerMatch, 0, len(sessions))
+	for _, session := range sessions {
+		totals, ok := stored[session.ID]
+		if !ok {
+			totals, err = s.totals(ctx, player, session)
+			if err != nil {
+				return nil, err
+			}
+		}
+		matches = append(matches, &playerMatch{
+			PlayerMatch: &entities.PlayerMatch{
+				SessionID:  session.ID,
+				ServerID:   session.ServerID,
+				ServerName: session.ServerName,
+				MapName:    session.MapName,
+				StartedAt:  session.StartedAt,
+				EndedAt:    session.EndedAt,
+				Status:     session.Status,
+				Rounds:     totals.Rounds,
+				ScoreCT:    session.ScoreCT,
+				ScoreT:     session.ScoreT,
+				Result:     matchResult(session, totals.Score.Team),
+				Score:      totals.Score,
+			},
+			weapons: totals.Weapons,
+		})
+	}
+	return matches, nil
+}
+
+// totals builds the scoreboard of a session and returns the player's totals.
+// Once the session is over, the totals of all its players are stored.
+func (s *PlayerProfileService) totals(ctx context.Context, player *entities.Player, session *entities.GameSession) (*entities.SessionPlayerTotals, error) {
+	events, err := s.sessions.FindEvents(ctx, session.ID, scoreboardEventTypes)
+	if err != nil {
+		return nil, err
+	}
+	rounds := splitRounds(events)
+	board := buildScoreboard(session.ID, rounds)
+	weapons := weaponKills(rounds)
+
+	playerTotals := &entities.SessionPlayerTotals{
+		SessionID: session.ID,
+		SteamID64: player.SteamID64,
+		Rounds:    board.Rounds,
+		Score:     &entities.PlayerScore{SteamID: player.SteamID3, Name: player.Name},
+		Weapons:   []*entities.WeaponStats{},
+	}
+	all := make([]*entities.SessionPlayerTotals, 0, len(board.Players))
+	for _, score := range board.Players {
+		accountID, ok := entities.ParseSteamID(score.SteamID)
+		if !ok {
+			continue
+		}
+		totals := &entities.SessionPlayerTotals{
+			SessionID: session.ID,
+			SteamID64: entities.SteamID64(accountID),
+			Rounds:    board.Rounds,
+			Score:     score,
+			Weapons:   weapons[score.SteamID],
+		}
+		if totals.Weapons == nil {
+			totals.Weapons = []*entities.WeaponStats{}
+		}
+		all = append(all, totals)
+		if score.SteamID == player.SteamID3 {
+			playerTotals = totals
+		}
+	}
+
+	if session.Status != entities.SessionStatusActive {
+		// The totals are worked out again next time if they cannot be saved
+		if err := s.sessions.SavePlayerTotals(ctx, all); err != nil {
+			log.Printf("Failed to save player totals of session %s: %v", session.ID, err)
+		}
+	}
+	return playerTotals, nil
+}
+
+// matchResult works out how a finished session ended for a player on the
+// given side, from the latest team score. The side is the one the player
+// was on in the last round they played.
+func matchResult(session *entities.GameSession, side string) entities.MatchResult {
+	if session.Status == entities.SessionStatusActive || session.ScoreCT == nil || session.ScoreT == nil {
+		return ""
+	}
+	own, other := *session.ScoreCT, *session.ScoreT
+	switch side {
+	case "CT":
+	case "TERRORIST":
+		own, other = other, own
+	default:
+		return ""
+	}
+
+	switch {
+	case own > other:
+		return entities.MatchResultWin
+	case own < other:
+		return entities.MatchResultLoss
+	default:
+		return entities.MatchResultDraw
+	}
+}
+
+// weaponKills counts each player's kills of enemies by weapon, keyed by
+// SteamID3
+func weaponKills(rounds []*roundEvents) map[string][]*entities.WeaponStats {
+	byPlayer := make(map[string]map[string]*entities.WeaponStats)
+	kills := make(map[string][]*entities.WeaponStats)
+	for _, round := range rounds {
+		for _, event := range round.events {
+			if event.EventType != "kill" {
+				continue
+			}
+			attacker, _ := event.EventData["attacker"].(map[string]interface{})
+			victim, _ := event.EventData["victim"].(map[string]interface{})
+			if attacker == nil || victim == nil || eventString(attacker, "side") == eventString(victim, "side") {
+				continue
+			}
+
+			steamID := eventString(attacker, "steam_id")
+			weapons, ok := byPlayer[steamID]
+			if !ok {
+				weapons = make(map[string]*entities.WeaponStats)
+				byPlayer[steamID] = weapons
+			}
+			name := eventString(event.EventData, "weapon")
+			weapon, ok := weapons[name]
+			if !ok {
+				weapon = &entities.WeaponStats{Weapon: name}
+				weapons[name] = weapon
+				kills[steamID] = append(kills[steamID], weapon)
+			}
+			weapon.Kills++
+			if headshot, _ := event.EventData["headshot"].(bool); headshot {
+				weapon.HeadshotKills++
+			}
+		}
+	}
+	return kills
+}
+
+// addWeaponKills adds a match's kills by weapon to a player's totals
+func addWeaponKills(weapons map[string]*entities.WeaponStats, kills []*entities.WeaponStats) {
+	for _, kill := range kills {
+		weapon, ok := weapons[kill.Weapon]
+		if !ok {
+			weapon = &entities.WeaponStats{Weapon: kill.Weapon}
+			weapons[kill.Weapon] = weapon
+		}
+		weapon.Kills += kill.Kills
+		weapon.HeadshotKills += kill.HeadshotKills
+	}
+}
+
+// addMatch adds a player's match to their statistics
+func addMatch(stats *entities.PlayerStats, match *entities.PlayerMatch) {
+	stats.Matches++
+	switch match.Result {
+	case entities.MatchResultWin:
+		stats.Wins++
+	case entities.MatchResultLoss:
+		stats.Losses++
+	case entities.MatchResultDraw:
+		stats.Draws++
+	}
+
+	score := match.Score
+	stats.Rounds += match.Rounds
+	stats.RoundsPlayed += score.RoundsPlayed
+	stats.Kills += score.Kills
+	stats.Deaths += score.Deaths
+	stats.Assists += score.Assists
+	stats.HeadshotKills += score.HeadshotKills
+	stats.Damage += score.Damage
+	stats.MVPs += score.MVPs
+}
+
+// finishStats works out the ratios of a player's statistics
+func finishStats(stats *entities.PlayerStats) {
+	stats.KD = float64(stats.Kills)
+	if stats.Deaths > 0 {
+		stats.KD = round2(float64(stats.Kills) / float64(stats.Deaths))
+	}
+	if stats.Kills > 0 {
+		stats.HeadshotPct = round2(100 * float64(stats.HeadshotKills) / float64(stats.Kills))
+	}
+	if stats.Rounds > 0 {
+		stats.ADR = round2(float64(stats.Damage) / float64(stats.Rounds))
+	}
+	if finished := stats.Wins + stats.Losses + stats.Draws; finished > 0 {
+		stats.WinRate = round2(100 * float64(stats.Wins) / float64(finished))
+	}
+}
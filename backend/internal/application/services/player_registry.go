@@ -0,0 +1,58 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PlayerRepository stores the players seen in parsed events
+type PlayerRepository interface {
+	RecordSightings(ctx context.Context, parsedLogID, serverID string, players []*entities.Player, at time.Time) error
+}
+
+// playerTokenPattern matches a player with a SteamID3 in a log line, as in
+// "SHESKY<7><[U:1:215888626]><TERRORIST>"; the name is the first group and
+// the account ID the second. Players pending validation and bots have no
+// SteamID3 and are not matched.
+var playerTokenPattern = regexp.MustCompile(`"([^"]+?)<\d+><\[U:1:(\d+)\]>`)
+
+// PlayerRegistryService keeps the player registry up to date with the
+// players found in each parsed event
+type PlayerRegistryService struct {
+	players PlayerRepository
+}
+
+// NewPlayerRegistryService creates a new player registry
+func NewPlayerRegistryService(players PlayerRepository) *PlayerRegistryService {
+	return &PlayerRegistryService{players: players}
+}
+
+// RecordPlayers records the players found in a log line and links them to
+// the event parsed from it
+func (r *PlayerRegistryService) RecordPlayers(ctx context.Context, parsedLogID, serverID, content string, at time.Time) error {
+	players := ExtractPlayers(content)
+	if len(players) == 0 {
+		return nil
+	}
+	return r.players.RecordSightings(ctx, parsedLogID, serverID, players, at)
+}
+
+// ExtractPlayers returns the players in a log line, once each, in the order
+// they appear
+func ExtractPlayers(content string) []*entities.Player {
+	var players []*entities.Player
+	seen := make(map[uint64]bool)
+	for _, match := range playerTokenPattern.FindAllStringSubmatch(content, -1) {
+		accountID, err := strconv.ParseUint(match[2], 10, 32)
+		if err != nil || accountID == 0 || seen[accountID] {
+			continue
+		}
+		seen[accountID] = true
+		players = append(players, entities.NewPlayer(uint32(accountID), match[1]))
+	}
+	return players
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+const (
+	DefaultReparseChunkSize = 500
+	MaxReparseChunkSize     = 10000
+)
+
+var (
+	// ErrReparseJobRunning is returned when a job is already running in
+	// this process
+	ErrReparseJobRunning = errors.New("reparse job is already running")
+
+	// ErrReparseJobCompleted is returned when resuming a completed job
+	ErrReparseJobCompleted = errors.New("reparse job already completed")
+)
+
+// ReparseJobRepository stores reparse jobs and the rows they replace
+type ReparseJobRepository interface {
+	CreateJob(ctx context.Context, job *entities.ReparseJob) error
+	FindJob(ctx context.Context, id string) (*entities.ReparseJob, error)
+	FindUnfinishedJobs(ctx context.Context) ([]*entities.ReparseJob, error)
+	UpdateStatus(ctx context.Context, job *entities.ReparseJob) error
+	NextChunk(ctx context.Context, job *entities.ReparseJob) ([]*entities.ReparseLine, error)
+	ReplaceChunk(ctx context.Context, job *entities.ReparseJob, lines []*entities.ReparsedLine, last *entities.ReparseLine) error
+}
+
+// ReparseService reparses stored raw logs with the current parser and
+// replaces the rows parsed from them, a chunk at a time. Only the event type
+// and data of each line are brought up to date: sessions and rounds are not
+// detected again, and round_stats blocks are left as they are.
+type ReparseService struct {
+	parser *ParserService
+	jobs   ReparseJobRepository
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewReparseService creates a new reparse service
+func NewReparseService(parser *ParserService, jobs ReparseJobRepository) *ReparseService {
+	return &ReparseService{
+		parser:  parser,
+		jobs:    jobs,
+		running: make(map[string]bool),
+	}
+}
+
+// ParserVersion is the version of the parser and parse rules that reparsed
+// rows are stamped with
+func (s *ReparseService) ParserVersion() string {
+	return s.parser.Version()
+}
+
+// Create saves a new pending job for the current parser version
+func (s *ReparseService) Create(ctx context.Context, job *entities.ReparseJob) error {
+	if job.From != nil && job.To != nil && !job.From.Before(*job.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	if job.ChunkSize <= 0 {
+		job.ChunkSize = DefaultReparseChunkSize
+	}
+	if job.ChunkSize > MaxReparseChunkSize {
+		job.ChunkSize = MaxReparseChunkSize
+	}
+	job.ParserVersion = s.parser.Version()
+	job.Status = entities.ReparseJobPending
+	job.EventTypeChanges = []*entities.EventTypeChange{}
+	return s.jobs.CreateJob(ctx, job)
+}
+
+// Start runs a job in the background until it completes or ctx is done. A
+// job stopped by ctx stays running and is resumed by ResumeUnfinished.
+func (s *ReparseService) Start(ctx context.Context, jobID string) error {
+	job, err := s.jobs.FindJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status == entities.ReparseJobCompleted {
+		return ErrReparseJobCompleted
+	}
+	if !s.claim(jobID) {
+		return ErrReparseJobRunning
+	}
+
+	go func() {
+		defer s.release(jobID)
+		if err := s.run(ctx, job, nil); err != nil {
+			log.Printf("Reparse job %s stopped: %v", jobID, err)
+		}
+	}()
+	return nil
+}
+
+// Run runs a job until it completes, resuming after the last chunk it
+// reparsed. progress, when not nil, is called after every chunk.
+func (s *ReparseService) Run(ctx context.Context, jobID string, progress func(*entities.ReparseJob)) (*entities.ReparseJob, error) {
+	if !s.claim(jobID) {
+		return nil, ErrReparseJobRunning
+	}
+	defer s.release(jobID)
+
+	job, err := s.jobs.FindJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == entities.ReparseJobCompleted {
+		return job, ErrReparseJobCompleted
+	}
+	return job, s.run(ctx, job, progress)
+}
+
+// ResumeUnfinished starts the jobs a previous run left pending or running
+func (s *ReparseService) ResumeUnfinished(ctx context.Context) error {
+	jobs, err := s.jobs.FindUnfinishedJobs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err := s.Start(ctx, job.ID); err != nil && err != ErrReparseJobRunning {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ReparseService) claim(jobID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[jobID] {
+		return false
+	}
+	s.running[jobID] = true
+	return true
+}
+
+func (s *ReparseService) release(jobID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, jobID)
+}
+
+func (s *ReparseService) run(ctx context.Context, job *entities.ReparseJob, progress func(*entities.ReparseJob)) error {
+	// Rows must be stamped with the version of the parser that produced them
+	if version := s.parser.Version(); job.ParserVersion != version {
+		return s.fail(ctx, job, fmt.Errorf("job was created for parser version %s, the parser is now %s", job.ParserVersion, version))
+	}
+
+	job.Status = entities.ReparseJobRunning
+	job.Error = nil
+	if err := s.jobs.UpdateStatus(ctx, job); err != nil {
+		return err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		lines, err := s.jobs.NextChunk(ctx, job)
+		if err != nil {
+			return s.fail(ctx, job, err)
+		}
+		if len(lines) == 0 {
+			break
+		}
+
+		reparsed := make([]*entities.ReparsedLine, 0, len(lines))
+		for _, line := range lines {
+			result := reparseLine(s.parser, line)
+			countReparsedLine(job, line, result)
+			reparsed = append(reparsed, result)
+		}
+		sort.SliceStable(job.EventTypeChanges, func(i, j int) bool {
+			return job.EventTypeChanges[i].Count > job.EventTypeChanges[j].Count
+		})
+
+		if err := s.jobs.ReplaceChunk(ctx, job, reparsed, lines[len(lines)-1]); err != nil {
+			return s.fail(ctx, job, err)
+		}
+		if progress != nil {
+			progress(job)
+		}
+	}
+
+	now := time.Now()
+	job.Status = entities.ReparseJobCompleted
+	job.CompletedAt = &now
+	return s.jobs.UpdateStatus(ctx, job)
+}
+
+// fail marks a job failed. A job stopped because ctx is done is left running
+// so it can be resumed.
+func (s *ReparseService) fail(ctx context.Context, job *entities.ReparseJob, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	message := err.Error()
+	job.Status = entities.ReparseJobFailed
+	job.Error = &message
+	if updateErr := s.jobs.UpdateStatus(context.Background(), job); updateErr != nil {
+		log.Printf("Failed to mark reparse job %s failed: %v", job.ID, updateErr)
+	}
+	return err
+}
+
+// reparseLine parses a raw log again. Lines of round_stats blocks are
+// skipped, as they are only parsed together with the rest of their block.
+func reparseLine(parser *ParserService, line *entities.ReparseLine) *entities.ReparsedLine {
+	result := &entities.ReparsedLine{
+		RawLogID: line.RawLogID,
+		ServerID: line.ServerID,
+	}
+	if isJSONStatsLine(parser.ExtractActualContent(line.Content)) {
+		result.Skipped = true
+		return result
+	}
+
+	parsed, err := parser.parseLine(line.Content)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.EventType = parsed.EventType
+	result.EventData = parsed.EventData
+	result.Source = parsed.Source
+	result.Confidence = parsed.Confidence
+	result.Rule = parsed.Rule
+	result.EventTime = parser.EventTime(line.ServerID, line.Content)
+	result.Players = ExtractPlayers(parsed.ActualContent)
+	return result
+}
+
+// countReparsedLine adds a reparsed line to the job's counts and event type
+// changes
+func countReparsedLine(job *entities.ReparseJob, line *entities.ReparseLine, result *entities.ReparsedLine) {
+	job.Processed++
+	if result.Skipped {
+		job.Skipped++
+		return
+	}
+
+	to := result.EventType
+	if result.Error != "" {
+		to = entities.EventTypeFailed
+		job.Failed++
+	} else {
+		job.Parsed++
+	}
+
+	from := ""
+	if len(line.EventTypes) > 0 {
+		from = line.EventTypes[0]
+	}
+	if from == to {
+		return
+	}
+
+	job.Changed++
+	for _, change := range job.EventTypeChanges {
+		if change.From == from && change.To == to {
+			change.Count++
+			return
+		}
+	}
+	job.EventTypeChanges = append(job.EventTypeChanges, &entities.EventTypeChange{From: from, To: to, Count: 1})
+}
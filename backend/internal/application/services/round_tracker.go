@@ -0,0 +1,403 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// RoundRepository stores the rounds found by the round tracker
+type RoundRepository interface {
+	Save(ctx context.Context, round *entities.Round) error
+	FindLatestBySession(ctx context.Context, sessionID string) (*entities.Round, error)
+}
+
+// Match length used until the server logs mp_maxrounds and
+// mp_overtime_maxrounds
+const (
+	defaultMaxRounds         = 24
+	defaultOvertimeMaxRounds = 6
+)
+
+var (
+	// cvarPattern matches both `server_cvar: "mp_maxrounds" "24"` and
+	// `"mp_maxrounds" = "24"`
+	cvarPattern = regexp.MustCompile(`"(mp_maxrounds|mp_overtime_maxrounds)"\s+(?:=\s+)?"(-?\d+)"`)
+
+	// matchStatusPattern matches `MatchStatus: Score: 3:1 on map "de_dust2" RoundsPlayed: 4`;
+	// RoundsPlayed is -1 during warmup
+	matchStatusPattern = regexp.MustCompile(`Score: (\d+):(\d+) on map "[^"]*" RoundsPlayed: (-?\d+)`)
+
+	// noticeScorePattern matches the score of `World triggered "SFUI_Notice_Round_Draw" (CT "3") (T "1")`
+	noticeScorePattern = regexp.MustCompile(`\(CT "(\d+)"\) \(T "(\d+)"\)`)
+)
+
+// RoundPosition is the round and game phase an event happened in. Both are
+// nil until the tracker has seen a match start.
+type RoundPosition struct {
+	RoundNumber *int
+	GamePhase   *string
+}
+
+// roundState is the match state of one server
+type roundState struct {
+	mu sync.Mutex
+
+	sessionID         string
+	maxRounds         int
+	overtimeMaxRounds int
+
+	phase   entities.GamePhase
+	played  int // rounds finished in the match
+	round   int // current round, 0 before the first
+	scoreCT int
+	scoreT  int
+
+	// The current round; startedAt is nil once the round has been recorded
+	startedAt *time.Time
+	endedAt   *time.Time
+	winner    *string
+	winReason *string
+}
+
+// RoundTrackerService follows the rounds of each server's match so every
+// event can be stamped with its round number and game phase, and records
+// each finished round with its winner, win reason, duration and score.
+// Lines of a server must be passed in the order they were logged, after
+// session detection.
+type RoundTrackerService struct {
+	rounds RoundRepository
+
+	mu      sync.Mutex
+	servers map[string]*roundState
+}
+
+// NewRoundTrackerService creates a new round tracker
+func NewRoundTrackerService(rounds RoundRepository) *RoundTrackerService {
+	return &RoundTrackerService{
+		rounds:  rounds,
+		servers: make(map[string]*roundState),
+	}
+}
+
+// Track updates the server's match state with an event and returns the
+// round and phase the event belongs to. content is the log line, which
+// carries values not present in the event data of some events.
+func (t *RoundTrackerService) Track(ctx context.Context, parsedLog *entities.ParsedLog, content string) (RoundPosition, error) {
+	state := t.serverState(parsedLog.ServerID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	// Each session is a separate match; after a restart, carry on from the
+	// last round recorded for the session
+	if parsedLog.SessionID != state.sessionID {
+		state.reset(parsedLog.SessionID)
+		if parsedLog.SessionID != "" {
+			latest, err := t.rounds.FindLatestBySession(ctx, parsedLog.SessionID)
+			if err != nil && err.Error() != "round not found" {
+				return RoundPosition{}, err
+			}
+			if latest != nil {
+				state.resume(latest)
+			}
+		}
+	}
+
+	at := sessionEventTime(parsedLog)
+
+	switch parsedLog.EventType {
+	case "cvar_maxrounds", "cvar_overtime", "cvar_mp_setting", "server_cvar":
+		state.applyCvar(content)
+	case "game_commencing", "trigger_warmup-start":
+		state.startWarmup()
+	case "match_start":
+		state.startMatch()
+	case "trigger_match-reloaded":
+		// A backup was restored; the rounds after it are replayed
+		state.restoreBackup()
+	case "match_status_score":
+		state.applyMatchStatus(content)
+	case "freeze_period_start", "round_start":
+		state.beginRound(at, parsedLog.EventType == "round_start")
+	case "team_notice":
+		side := eventString(parsedLog.EventData, "side")
+		notice := eventString(parsedLog.EventData, "notice")
+		scoreCT, okCT := eventInt(parsedLog.EventData, "score_ct")
+		scoreT, okT := eventInt(parsedLog.EventData, "score_t")
+		if okCT && okT {
+			state.finishRound(at, side, winReason(notice), scoreCT, scoreT)
+		}
+	case "trigger_sfui-notice-round-draw":
+		if match := noticeScorePattern.FindStringSubmatch(content); match != nil {
+			scoreCT, _ := strconv.Atoi(match[1])
+			scoreT, _ := strconv.Atoi(match[2])
+			state.finishRound(at, "", "round_draw", scoreCT, scoreT)
+		}
+	case "match_end":
+		state.phase = entities.GamePhasePostMatch
+	default:
+		if strings.HasPrefix(parsedLog.EventType, "game_over_") {
+			state.phase = entities.GamePhasePostMatch
+		}
+	}
+
+	// Round end belongs to the round it ends; record the round after
+	position := state.position()
+	if parsedLog.EventType == "round_end" {
+		if err := t.recordRound(ctx, state, parsedLog.ServerID, at); err != nil {
+			return position, err
+		}
+	}
+
+	return position, nil
+}
+
+// recordRound saves the current round if it was played and moves to
+// halftime when it was the last round of a half. Rounds are keyed by
+// session, so a round played outside one is not saved.
+func (t *RoundTrackerService) recordRound(ctx context.Context, state *roundState, serverID string, at time.Time) error {
+	if state.round == 0 || state.startedAt == nil {
+		return nil
+	}
+
+	if state.sessionID != "" {
+		endedAt := at
+		if state.endedAt != nil {
+			endedAt = *state.endedAt
+		}
+		duration := endedAt.Sub(*state.startedAt).Milliseconds()
+		sessionID := state.sessionID
+
+		round := &entities.Round{
+			ServerID:    serverID,
+			SessionID:   &sessionID,
+			RoundNumber: state.round,
+			GamePhase:   state.phase,
+			Winner:      state.winner,
+			WinReason:   state.winReason,
+			StartedAt:   state.startedAt,
+			EndedAt:     &endedAt,
+			DurationMs:  &duration,
+			ScoreCT:     state.scoreCT,
+			ScoreT:      state.scoreT,
+		}
+		if err := t.rounds.Save(ctx, round); err != nil {
+			return err
+		}
+	}
+
+	state.startedAt = nil
+	if state.isHalfEnd() {
+		state.phase = entities.GamePhaseHalftime
+	}
+	return nil
+}
+
+// serverState returns the match state of a server
+func (t *RoundTrackerService) serverState(serverID string) *roundState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.servers[serverID]
+	if !ok {
+		state = &roundState{
+			maxRounds:         defaultMaxRounds,
+			overtimeMaxRounds: defaultOvertimeMaxRounds,
+		}
+		t.servers[serverID] = state
+	}
+	return state
+}
+
+// reset forgets the match of the previous session; the match length cvars
+// are server settings and are kept
+func (s *roundState) reset(sessionID string) {
+	s.sessionID = sessionID
+	s.phase = ""
+	s.played = 0
+	s.round = 0
+	s.scoreCT = 0
+	s.scoreT = 0
+	s.clearRound()
+}
+
+// resume continues a match after its last recorded round
+func (s *roundState) resume(latest *entities.Round) {
+	s.played = latest.RoundNumber
+	s.round = latest.RoundNumber
+	s.scoreCT = latest.ScoreCT
+	s.scoreT = latest.ScoreT
+	s.phase = latest.GamePhase
+	if s.isHalfEnd() {
+		s.phase = entities.GamePhaseHalftime
+	}
+}
+
+func (s *roundState) applyCvar(content string) {
+	match := cvarPattern.FindStringSubmatch(content)
+	if match == nil {
+		return
+	}
+	// The server logs 0 while a map is loading
+	value, err := strconv.Atoi(match[2])
+	if err != nil || value <= 0 {
+		return
+	}
+	if match[1] == "mp_maxrounds" {
+		s.maxRounds = value
+	} else {
+		s.overtimeMaxRounds = value
+	}
+}
+
+func (s *roundState) startWarmup() {
+	s.phase = entities.GamePhaseWarmup
+	s.played = 0
+	s.round = 0
+	s.scoreCT = 0
+	s.scoreT = 0
+	s.clearRound()
+}
+
+// startMatch handles Match_Start, which is also logged when warmup begins;
+// a following Warmup_Start or RoundsPlayed of -1 moves back to warmup
+func (s *roundState) startMatch() {
+	s.startWarmup()
+	s.phase = entities.GamePhaseLive
+}
+
+func (s *roundState) applyMatchStatus(content string) {
+	match := matchStatusPattern.FindStringSubmatch(content)
+	if match == nil {
+		return
+	}
+	played, _ := strconv.Atoi(match[3])
+	if played < 0 {
+		s.startWarmup()
+		return
+	}
+	s.scoreCT, _ = strconv.Atoi(match[1])
+	s.scoreT, _ = strconv.Atoi(match[2])
+	s.played = played
+}
+
+// restoreBackup drops the round in progress. The round number is unknown
+// until the MatchStatus that follows the restore; the draw notice logged in
+// between is not a played round.
+func (s *roundState) restoreBackup() {
+	s.round = 0
+	s.clearRound()
+}
+
+// beginRound moves to the next round at the start of the freeze period and
+// marks when it started at Round_Start
+func (s *roundState) beginRound(at time.Time, roundStart bool) {
+	switch s.phase {
+	case entities.GamePhaseWarmup, entities.GamePhasePostMatch:
+		return
+	}
+
+	if s.round <= s.played {
+		s.round = s.played + 1
+		s.clearRound()
+	}
+	if roundStart {
+		startedAt := at
+		s.startedAt = &startedAt
+	}
+
+	s.phase = entities.GamePhaseLive
+	if s.round > s.maxRounds {
+		s.phase = entities.GamePhaseOvertime
+	}
+}
+
+// finishRound records the winner and score of the current round
+func (s *roundState) finishRound(at time.Time, winner, reason string, scoreCT, scoreT int) {
+	s.scoreCT = scoreCT
+	s.scoreT = scoreT
+	s.played = scoreCT + scoreT // corrected by MatchStatus after draws
+	if s.round == 0 {
+		return
+	}
+
+	endedAt := at
+	s.endedAt = &endedAt
+	s.winner = nil
+	if winner != "" {
+		s.winner = &winner
+	}
+	s.winReason = &reason
+}
+
+func (s *roundState) clearRound() {
+	s.startedAt = nil
+	s.endedAt = nil
+	s.winner = nil
+	s.winReason = nil
+}
+
+// isHalfEnd reports whether the rounds played end a half of regulation or
+// of an overtime
+func (s *roundState) isHalfEnd() bool {
+	if s.played == s.maxRounds/2 {
+		return true
+	}
+	half := s.overtimeMaxRounds / 2
+	return s.played >= s.maxRounds && half > 0 && (s.played-s.maxRounds)%half == 0
+}
+
+func (s *roundState) position() RoundPosition {
+	var position RoundPosition
+	if s.round > 0 {
+		round := s.round
+		position.RoundNumber = &round
+	}
+	if s.phase != "" {
+		phase := string(s.phase)
+		position.GamePhase = &phase
+	}
+	return position
+}
+
+// winReason turns a round end notice such as SFUI_Notice_Target_Bombed into
+// target_bombed
+func winReason(notice string) string {
+	return strings.ToLower(strings.TrimPrefix(notice, "SFUI_Notice_"))
+}
+
+// eventInt reads a numeric field from event data
+func eventInt(eventData map[string]interface{}, key string) (int, bool) {
+	switch value := eventData[key].(type) {
+	case json.Number:
+		n, err := value.Int64()
+		return int(n), err == nil
+	case float64:
+		return int(value), true
+	case string:
+		n, err := strconv.Atoi(value)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// eventFloat reads a numeric field with a fraction from event data
+func eventFloat(eventData map[string]interface{}, key string) (float64, bool) {
+	switch value := eventData[key].(type) {
+	case json.Number:
+		f, err := value.Float64()
+		return f, err == nil
+	case float64:
+		return value, true
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+func TestRoundTrackerRecordsDecodedTeamNotice(t *testing.T) {
+	rounds := persistence.NewMemoryRoundRepository()
+	tracker := NewRoundTrackerService(rounds)
+	start := time.Date(2025, 8, 19, 18, 0, 0, 0, time.UTC)
+
+	// Event data as the parser stores it, with numbers kept as written
+	events := []struct {
+		eventType string
+		data      string
+	}{
+		{"match_start", `{"map":"de_dust2"}`},
+		{"round_start", ""},
+		{"team_notice", `{"side":"CT","notice":"SFUI_Notice_CTs_Win","score_ct":1,"score_t":0}`},
+		{"round_end", ""},
+	}
+	for i, event := range events {
+		data, err := decodeEventData(event.data)
+		if err != nil {
+			t.Fatalf("decode %s: %v", event.eventType, err)
+		}
+		at := start.Add(time.Duration(i) * time.Minute)
+		parsedLog := &entities.ParsedLog{
+			ServerID:  "server",
+			SessionID: "session",
+			EventType: event.eventType,
+			EventData: data,
+			EventTime: &at,
+		}
+		if _, err := tracker.Track(context.Background(), parsedLog, ""); err != nil {
+			t.Fatalf("track %s: %v", event.eventType, err)
+		}
+	}
+
+	saved, err := rounds.ListBySession(context.Background(), "session")
+	if err != nil {
+		t.Fatalf("list rounds: %v", err)
+	}
+	if len(saved) != 1 {
+		t.Fatalf("got %d rounds, want 1", len(saved))
+	}
+	round := saved[0]
+	if round.Winner == nil || *round.Winner != "CT" {
+		t.Errorf("winner: got %v, want CT", round.Winner)
+	}
+	if round.WinReason == nil || *round.WinReason != "cts_win" {
+		t.Errorf("win reason: got %v, want cts_win", round.WinReason)
+	}
+	if round.ScoreCT != 1 || round.ScoreT != 0 {
+		t.Errorf("score: got %d:%d, want 1:0", round.ScoreCT, round.ScoreT)
+	}
+}
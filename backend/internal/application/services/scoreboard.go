@@ -0,0 +1,587 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// SessionEventReader reads the events of a game session in the order they
+// were logged
+type SessionEventReader interface {
+	FindEvents(ctx context.Context, sessionID string, eventTypes []string) ([]*entities.ParsedLog, error)
+}
+
+// scoreboardEventTypes are the events a scoreboard is built from. Economy
+// events only show which players took part in a round.
+var scoreboardEventTypes = []string{
+	"kill", "kill_assist", "attack", "blinded", "suicide", "killed_by_bomb",
+	"bomb_planted", "bomb_begin_defuse", "bomb_defused", "team_notice", "freeze_period_start", "round_start",
+	"money_change", "purchase", "left_buyzone", "round_stats",
+}
+
+// utilityWeapons are the weapons whose damage counts as utility damage
+var utilityWeapons = map[string]bool{
+	"hegrenade":  true,
+	"inferno":    true,
+	"molotov":    true,
+	"incgrenade": true,
+}
+
+const (
+	// tradeWindow is how soon a teammate must kill the killer for a death to
+	// count as traded
+	tradeWindow = 5 * time.Second
+
+	// minFlashDuration is how long an enemy must be blinded to count as
+	// flashed, as in the server's round_stats
+	minFlashDuration = 1.0
+
+	playerHealth = 100
+)
+
+// ScoreboardService builds the scoreboard of a game session from its kill,
+// assist, damage and flash events
+type ScoreboardService struct {
+	events SessionEventReader
+}
+
+// NewScoreboardService creates a new scoreboard service
+func NewScoreboardService(events SessionEventReader) *ScoreboardService {
+	return &ScoreboardService{events: events}
+}
+
+// Build builds the scoreboard of a session and checks it against the last
+// round_stats block logged in the session
+func (s *ScoreboardService) Build(ctx context.Context, sessionID string) (*entities.Scoreboard, error) {
+	events, err := s.events.FindEvents(ctx, sessionID, scoreboardEventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	rounds := splitRounds(events)
+	board := buildScoreboard(sessionID, rounds)
+
+	if stats := lastRoundStats(events); stats != nil && stats.roundsCovered > 0 {
+		var covered []*roundEvents
+		for _, round := range rounds {
+			if round.number <= stats.roundsCovered {
+				covered = append(covered, round)
+			}
+		}
+		board.RoundStats = stats.check(buildScoreboard(sessionID, covered))
+	}
+
+	return board, nil
+}
+
+// roundEvents are the events of one round, in the order they were logged
+type roundEvents struct {
+	number int
+	events []*entities.ParsedLog
+}
+
+// splitRounds groups the match events by round. When a backup was restored
+// a round is played more than once; only its last attempt, from the last
+// freeze period it started with, is kept.
+func splitRounds(events []*entities.ParsedLog) []*roundEvents {
+	starts := make(map[int]time.Time)
+	for _, event := range events {
+		if !isMatchEvent(event) {
+			continue
+		}
+		at := sessionEventTime(event)
+		switch event.EventType {
+		case "freeze_period_start":
+			starts[*event.RoundNumber] = at
+		case "round_start":
+			if _, ok := starts[*event.RoundNumber]; !ok {
+				starts[*event.RoundNumber] = at
+			}
+		}
+	}
+
+	byNumber := make(map[int]*roundEvents)
+	var rounds []*roundEvents
+	for _, event := range events {
+		if !isMatchEvent(event) || event.EventType == "round_stats" {
+			continue
+		}
+		number := *event.RoundNumber
+		if start, ok := starts[number]; ok && sessionEventTime(event).Before(start) {
+			continue
+		}
+		round, ok := byNumber[number]
+		if !ok {
+			round = &roundEvents{number: number}
+			byNumber[number] = round
+			rounds = append(rounds, round)
+		}
+		round.events = append(round.events, event)
+	}
+
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i].number < rounds[j].number })
+	return rounds
+}
+
+// isMatchEvent reports whether an event happened in a round of the match
+func isMatchEvent(event *entities.ParsedLog) bool {
+	if event.RoundNumber == nil {
+		return false
+	}
+	return event.GamePhase == nil || entities.GamePhase(*event.GamePhase) != entities.GamePhaseWarmup
+}
+
+// scorePlayer is a player as logged in an event
+type scorePlayer struct {
+	steamID string
+	name    string
+	side    string
+}
+
+// roundDeath is a death in a round; killer is empty for suicides and the bomb
+type roundDeath struct {
+	victim string
+	killer string
+	at     time.Time
+}
+
+// roundFlash is the enemy flash a player is blinded by
+type roundFlash struct {
+	by    string
+	until time.Time
+}
+
+// roundTally collects what each player did in a round
+type roundTally struct {
+	sides    map[string]string
+	health   map[string]int
+	kills    map[string]int
+	lastKill map[string]time.Time
+	damage   map[string]int
+	assisted map[string]bool
+	died     map[string]bool
+	traded   map[string]bool
+	flashes  map[string]roundFlash
+	deaths   []roundDeath
+
+	planter string
+	defuser string
+	winner  string
+	reason  string
+}
+
+func newRoundTally() *roundTally {
+	return &roundTally{
+		sides:    make(map[string]string),
+		health:   make(map[string]int),
+		kills:    make(map[string]int),
+		lastKill: make(map[string]time.Time),
+		damage:   make(map[string]int),
+		assisted: make(map[string]bool),
+		died:     make(map[string]bool),
+		traded:   make(map[string]bool),
+		flashes:  make(map[string]roundFlash),
+	}
+}
+
+// scoreboardBuilder adds rounds up into player scores
+type scoreboardBuilder struct {
+	players map[string]*entities.PlayerScore
+	names   map[string]string // name -> SteamID, for lines with a garbled SteamID
+}
+
+// buildScoreboard adds up the scores of the given rounds
+func buildScoreboard(sessionID string, rounds []*roundEvents) *entities.Scoreboard {
+	b := &scoreboardBuilder{
+		players: make(map[string]*entities.PlayerScore),
+		names:   make(map[string]string),
+	}
+	for _, round := range rounds {
+		b.addRound(round)
+	}
+
+	board := &entities.Scoreboard{
+		SessionID: sessionID,
+		Rounds:    len(rounds),
+		Players:   make([]*entities.PlayerScore, 0, len(b.players)),
+	}
+	for _, score := range b.players {
+		finishScore(score, len(rounds))
+		board.Players = append(board.Players, score)
+	}
+	sort.Slice(board.Players, func(i, j int) bool {
+		a, c := board.Players[i], board.Players[j]
+		if a.Kills != c.Kills {
+			return a.Kills > c.Kills
+		}
+		if a.Deaths != c.Deaths {
+			return a.Deaths < c.Deaths
+		}
+		return a.Name < c.Name
+	})
+	return board
+}
+
+// player returns the score of a player, noting their latest name
+func (b *scoreboardBuilder) player(p scorePlayer) *entities.PlayerScore {
+	score, ok := b.players[p.steamID]
+	if !ok {
+		score = &entities.PlayerScore{SteamID: p.steamID}
+		b.players[p.steamID] = score
+	}
+	score.Name = p.name
+	return score
+}
+
+func (b *scoreboardBuilder) addRound(round *roundEvents) {
+	tally := newRoundTally()
+
+	for _, event := range round.events {
+		at := sessionEventTime(event)
+
+		// Every player seen with a side took part in the round
+		for _, key := range []string{"player", "attacker", "victim"} {
+			if p, ok := b.eventPlayer(event.EventData, key); ok {
+				tally.sides[p.steamID] = p.side
+				b.player(p)
+			}
+		}
+
+		attacker, hasAttacker := b.eventPlayer(event.EventData, "attacker")
+		victim, hasVictim := b.eventPlayer(event.EventData, "victim")
+		enemies := hasAttacker && hasVictim && attacker.side != victim.side
+
+		switch event.EventType {
+		case "kill":
+			if !hasVictim {
+				continue
+			}
+			killer := ""
+			if enemies {
+				killer = attacker.steamID
+				score := b.player(attacker)
+				score.Kills++
+				tally.kills[attacker.steamID]++
+				tally.lastKill[attacker.steamID] = at
+				if headshot, _ := event.EventData["headshot"].(bool); headshot {
+					score.HeadshotKills++
+				}
+
+				// Killing the victim trades the teammates they killed
+				for _, death := range tally.deaths {
+					if death.killer == victim.steamID && tally.sides[death.victim] == attacker.side && at.Sub(death.at) <= tradeWindow {
+						tally.traded[death.victim] = true
+					}
+				}
+
+				// An enemy flash on the victim is a flash assist
+				if flash, ok := tally.flashes[victim.steamID]; ok && flash.by != attacker.steamID && !at.After(flash.until) {
+					if flasher, ok := b.players[flash.by]; ok {
+						flasher.FlashAssists++
+					}
+				}
+			}
+			tally.die(b.player(victim), killer, at)
+
+		case "suicide":
+			if p, ok := b.eventPlayer(event.EventData, "player"); ok {
+				tally.die(b.player(p), "", at)
+			}
+		case "killed_by_bomb":
+			// The server does not count bomb deaths, nor the suicide logged
+			// right after them
+			if p, ok := b.eventPlayer(event.EventData, "player"); ok {
+				tally.died[p.steamID] = true
+			}
+
+		case "kill_assist":
+			if enemies {
+				b.player(attacker).Assists++
+				tally.assisted[attacker.steamID] = true
+			}
+
+		case "attack":
+			if !hasVictim {
+				continue
+			}
+			damage, _ := eventInt(event.EventData, "damage")
+			before, ok := tally.health[victim.steamID]
+			if !ok {
+				before = playerHealth
+			}
+			// Damage beyond the health the victim had left is not counted
+			dealt := damage
+			if after, ok := eventInt(event.EventData, "health"); ok {
+				tally.health[victim.steamID] = after
+				if before-after < dealt {
+					dealt = before - after
+				}
+			} else if before < dealt {
+				dealt = before
+			}
+			if dealt < 0 {
+				dealt = 0
+			}
+			if enemies {
+				score := b.player(attacker)
+				score.Damage += dealt
+				tally.damage[attacker.steamID] += dealt
+				if utilityWeapons[eventString(event.EventData, "weapon")] {
+					score.UtilityDamage += dealt
+				}
+			}
+
+		case "blinded":
+			if enemies {
+				seconds, _ := eventFloat(event.EventData, "for")
+				if seconds >= minFlashDuration {
+					b.player(attacker).EnemiesFlashed++
+				}
+				tally.flashes[victim.steamID] = roundFlash{
+					by:    attacker.steamID,
+					until: at.Add(time.Duration(seconds * float64(time.Second))),
+				}
+			}
+
+		case "bomb_planted":
+			if p, ok := b.eventPlayer(event.EventData, "player"); ok {
+				tally.planter = p.steamID
+			}
+		case "bomb_begin_defuse", "bomb_defused":
+			// The server only logs who started defusing; the last one did
+			if p, ok := b.eventPlayer(event.EventData, "player"); ok {
+				tally.defuser = p.steamID
+			}
+
+		case "team_notice":
+			tally.winner = eventString(event.EventData, "side")
+			tally.reason = winReason(eventString(event.EventData, "notice"))
+		}
+	}
+
+	for steamID, side := range tally.sides {
+		score := b.players[steamID]
+		score.Team = side
+		score.RoundsPlayed++
+		if tally.kills[steamID] > 0 || tally.assisted[steamID] || !tally.died[steamID] || tally.traded[steamID] {
+			score.KASTRounds++
+		}
+	}
+
+	if mvp := tally.mvp(); mvp != "" {
+		b.players[mvp].MVPs++
+	}
+}
+
+// die records a player's death; a player dies once per round even when both
+// a bomb kill and a suicide are logged
+func (t *roundTally) die(score *entities.PlayerScore, killer string, at time.Time) {
+	if t.died[score.SteamID] {
+		return
+	}
+	t.died[score.SteamID] = true
+	score.Deaths++
+	t.deaths = append(t.deaths, roundDeath{victim: score.SteamID, killer: killer, at: at})
+}
+
+// mvp picks the MVP of the round: the planter of a bomb that exploded, the
+// defuser, or else the winning player with the most kills, then damage, then
+// the latest kill
+func (t *roundTally) mvp() string {
+	switch {
+	case t.winner == "":
+		return ""
+	case t.reason == "target_bombed" && t.planter != "":
+		return t.planter
+	case t.reason == "bomb_defused" && t.defuser != "":
+		return t.defuser
+	}
+
+	best := ""
+	for steamID, side := range t.sides {
+		if side != t.winner || t.kills[steamID] == 0 {
+			continue
+		}
+		if best == "" || t.betterMVP(steamID, best) {
+			best = steamID
+		}
+	}
+	return best
+}
+
+func (t *roundTally) betterMVP(steamID, than string) bool {
+	if t.kills[steamID] != t.kills[than] {
+		return t.kills[steamID] > t.kills[than]
+	}
+	if t.damage[steamID] != t.damage[than] {
+		return t.damage[steamID] > t.damage[than]
+	}
+	return t.lastKill[steamID].After(t.lastKill[than])
+}
+
+// finishScore works out the per-round figures of a score. Like the in-game
+// scoreboard, ADR is over all rounds of the match, including rounds the
+// player missed.
+func finishScore(score *entities.PlayerScore, rounds int) {
+	score.KD = float64(score.Kills)
+	if score.Deaths > 0 {
+		score.KD = round2(float64(score.Kills) / float64(score.Deaths))
+	}
+	if score.Kills > 0 {
+		score.HeadshotPct = round2(100 * float64(score.HeadshotKills) / float64(score.Kills))
+	}
+	if rounds > 0 {
+		score.ADR = round2(float64(score.Damage) / float64(rounds))
+	}
+	if score.RoundsPlayed > 0 {
+		score.KAST = round2(100 * float64(score.KASTRounds) / float64(score.RoundsPlayed))
+	}
+}
+
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// eventPlayer reads a player of an event. Players without a side are
+// skipped and bots are told apart by name. A SteamID that is pending or
+// garbled is taken from an earlier event of the same player name.
+func (b *scoreboardBuilder) eventPlayer(eventData map[string]interface{}, key string) (scorePlayer, bool) {
+	data, ok := eventData[key].(map[string]interface{})
+	if !ok {
+		return scorePlayer{}, false
+	}
+	p := scorePlayer{
+		steamID: eventString(data, "steam_id"),
+		name:    eventString(data, "name"),
+		side:    eventString(data, "side"),
+	}
+	if p.side != "CT" && p.side != "TERRORIST" {
+		return scorePlayer{}, false
+	}
+
+	switch {
+	case p.steamID == "BOT":
+		p.steamID = "BOT_" + p.name
+	case strings.HasPrefix(p.steamID, "[U:"), strings.HasPrefix(p.steamID, "STEAM_") && !strings.HasPrefix(p.steamID, "STEAM_ID_"):
+		b.names[p.name] = p.steamID
+	default:
+		steamID, ok := b.names[p.name]
+		if !ok {
+			return scorePlayer{}, false
+		}
+		p.steamID = steamID
+	}
+	return p, true
+}
+
+// roundStatsBlock is a round_stats block: the server's own per-player stats
+// after the rounds it covers, keyed by Steam account ID
+type roundStatsBlock struct {
+	roundsCovered int
+	players       map[string]map[string]float64
+}
+
+// roundStatsComparisons are the round_stats fields checked against the
+// scoreboard, with how far apart the values may be. The server counts flash
+// assists as assists.
+var roundStatsComparisons = []struct {
+	field     string
+	tolerance float64
+	value     func(*entities.PlayerScore) float64
+}{
+	{"kills", 0, func(s *entities.PlayerScore) float64 { return float64(s.Kills) }},
+	{"deaths", 0, func(s *entities.PlayerScore) float64 { return float64(s.Deaths) }},
+	{"assists", 0, func(s *entities.PlayerScore) float64 { return float64(s.Assists + s.FlashAssists) }},
+	{"dmg", 0, func(s *entities.PlayerScore) float64 { return float64(s.Damage) }},
+	{"adr", 1, func(s *entities.PlayerScore) float64 { return s.ADR }},
+	{"hsp", 0.01, func(s *entities.PlayerScore) float64 { return s.HeadshotPct }},
+	{"ud", 0, func(s *entities.PlayerScore) float64 { return float64(s.UtilityDamage) }},
+	{"ef", 0, func(s *entities.PlayerScore) float64 { return float64(s.EnemiesFlashed) }},
+	{"mvp", 0, func(s *entities.PlayerScore) float64 { return float64(s.MVPs) }},
+}
+
+// lastRoundStats reads the last round_stats block of a session. The block is
+// logged at the start of a round with that round's number, so it covers the
+// rounds before it.
+func lastRoundStats(events []*entities.ParsedLog) *roundStatsBlock {
+	var last *entities.ParsedLog
+	for _, event := range events {
+		if event.EventType == "round_stats" {
+			last = event
+		}
+	}
+	if last == nil {
+		return nil
+	}
+
+	roundNumber, ok := eventInt(last.EventData, "round_number")
+	if !ok {
+		return nil
+	}
+	block := &roundStatsBlock{
+		roundsCovered: roundNumber - 1,
+		players:       make(map[string]map[string]float64),
+	}
+
+	fields := strings.Split(eventString(last.EventData, "fields"), ",")
+	players, _ := last.EventData["players"].(map[string]interface{})
+	for _, line := range players {
+		text, _ := line.(string)
+		values := strings.Split(text, ",")
+		if len(values) != len(fields) {
+			continue
+		}
+		stats := make(map[string]float64, len(fields))
+		for i, field := range fields {
+			stats[strings.TrimSpace(field)], _ = strconv.ParseFloat(strings.TrimSpace(values[i]), 64)
+		}
+		accountID := strings.TrimSpace(values[0])
+		if accountID != "0" {
+			block.players[accountID] = stats
+		}
+	}
+	return block
+}
+
+// check compares a scoreboard over the covered rounds with the block
+func (r *roundStatsBlock) check(board *entities.Scoreboard) *entities.RoundStatsCheck {
+	result := &entities.RoundStatsCheck{
+		RoundsCovered: r.roundsCovered,
+		Mismatches:    []entities.StatMismatch{},
+	}
+	for _, score := range board.Players {
+		stats, ok := r.players[steamAccountID(score.SteamID)]
+		if !ok {
+			continue
+		}
+		for _, comparison := range roundStatsComparisons {
+			expected, ok := stats[comparison.field]
+			if !ok {
+				continue
+			}
+			computed := comparison.value(score)
+			if math.Abs(computed-expected) > comparison.tolerance+1e-9 {
+				result.Mismatches = append(result.Mismatches, entities.StatMismatch{
+					SteamID:    score.SteamID,
+					Stat:       comparison.field,
+					Computed:   computed,
+					RoundStats: expected,
+				})
+			}
+		}
+	}
+	result.Matches = len(result.Mismatches) == 0
+	return result
+}
+
+// steamAccountID returns the account ID of a SteamID3 such as
+// [U:1:56591298], which round_stats uses to identify players
+func steamAccountID(steamID string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(steamID, "[U:1:"), "]")
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	domainservices "github.com/noueii/nocs-log-saver/internal/domain/services"
+)
+
+// GameSessionRepository stores the game sessions found by the session detector
+type GameSessionRepository interface {
+	Create(ctx context.Context, session *entities.GameSession) error
+	Update(ctx context.Context, session *entities.GameSession) error
+	FindActiveByServer(ctx context.Context, serverID string) (*entities.GameSession, error)
+}
+
+var _ domainservices.SessionDetector = (*SessionDetectorService)(nil)
+
+// serverSessions is the session state of one server
+type serverSessions struct {
+	mu     sync.Mutex
+	loaded bool
+	active *entities.GameSession
+	ended  *entities.GameSession // the session closed last, for replayed end lines
+}
+
+// SessionDetectorService follows the map and match events of each server to
+// group its log lines into game sessions. A session opens on loading_map,
+// started_map or match_start and closes on game_over_* or log_file_closed.
+// Lines of a server must be passed in the order they were logged. The start
+// and end steps of a session are keyed by raw log ID, so a line parsed again
+// after a failed attempt does not open or close a session twice.
+type SessionDetectorService struct {
+	sessions GameSessionRepository
+
+	mu      sync.Mutex
+	servers map[string]*serverSessions
+}
+
+// NewSessionDetectorService creates a new session detector
+func NewSessionDetectorService(sessions GameSessionRepository) *SessionDetectorService {
+	return &SessionDetectorService{
+		sessions: sessions,
+		servers:  make(map[string]*serverSessions),
+	}
+}
+
+// DetectSession returns the ID of the session the event belongs to, opening
+// or closing sessions as needed. It returns an empty ID when the server has
+// no active session. Session end events still belong to the session they end.
+func (d *SessionDetectorService) DetectSession(ctx context.Context, parsedLog *entities.ParsedLog) (string, error) {
+	state := d.serverState(parsedLog.ServerID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	// After a restart, carry on with the session that was active before
+	if !state.loaded {
+		active, err := d.sessions.FindActiveByServer(ctx, parsedLog.ServerID)
+		if err != nil && err.Error() != "game session not found" {
+			return "", err
+		}
+		state.active = active
+		state.loaded = true
+	}
+
+	at := sessionEventTime(parsedLog)
+
+	// A replayed start or end line belongs to the session it already opened
+	// or closed
+	for _, session := range []*entities.GameSession{state.active, state.ended} {
+		if session != nil && isSessionStep(session, parsedLog.RawLogID) {
+			return session.ID, nil
+		}
+	}
+
+	if d.IsSessionStart(parsedLog) {
+		if err := d.startSession(ctx, state, parsedLog, at); err != nil {
+			return "", err
+		}
+	}
+
+	if state.active == nil {
+		return "", nil
+	}
+	sessionID := state.active.ID
+
+	if d.IsSessionEnd(parsedLog) {
+		status := entities.SessionStatusCompleted
+		if parsedLog.EventType == "log_file_closed" {
+			// The log closed before the game was over
+			status = entities.SessionStatusTerminated
+		} else {
+			recordGameOver(state.active, parsedLog)
+		}
+		if err := d.endSession(ctx, state, status, parsedLog, at); err != nil {
+			return "", err
+		}
+	}
+
+	return sessionID, nil
+}
+
+// IsSessionStart checks if a log indicates a session start
+func (d *SessionDetectorService) IsSessionStart(parsedLog *entities.ParsedLog) bool {
+	switch parsedLog.EventType {
+	case "loading_map", "started_map", "match_start":
+		return true
+	}
+	return false
+}
+
+// IsSessionEnd checks if a log indicates a session end. match_end is the
+// summary form of the "Game Over" line and ends a session as well.
+func (d *SessionDetectorService) IsSessionEnd(parsedLog *entities.ParsedLog) bool {
+	return strings.HasPrefix(parsedLog.EventType, "game_over_") ||
+		parsedLog.EventType == "match_end" ||
+		parsedLog.EventType == "log_file_closed"
+}
+
+// startSession handles a session start event. loading_map, started_map and
+// match_start follow each other when a map is loaded and played, so a later
+// step on the same map continues the active session; anything else replaces
+// it with a new one.
+func (d *SessionDetectorService) startSession(ctx context.Context, state *serverSessions, parsedLog *entities.ParsedLog, at time.Time) error {
+	mapName := eventString(parsedLog.EventData, "map")
+	stepKey := parsedLog.EventType + "_at"
+	rawLogKey := parsedLog.EventType + stepRawLogSuffix
+
+	if active := state.active; active != nil {
+		if continuesSession(active, parsedLog.EventType, mapName) {
+			if active.Metadata == nil {
+				active.Metadata = make(map[string]interface{})
+			}
+			if active.MapName == "" {
+				active.MapName = mapName
+			}
+			active.Metadata[stepKey] = at
+			setStepRawLog(active.Metadata, rawLogKey, parsedLog.RawLogID)
+			return d.sessions.Update(ctx, active)
+		}
+
+		if err := d.endSession(ctx, state, entities.SessionStatusTerminated, parsedLog, at); err != nil {
+			return err
+		}
+	}
+
+	session := &entities.GameSession{
+		ID:        uuid.New().String(),
+		ServerID:  parsedLog.ServerID,
+		MapName:   mapName,
+		StartedAt: at,
+		Status:    entities.SessionStatusActive,
+		Metadata: map[string]interface{}{
+			"opened_by": parsedLog.EventType,
+			stepKey:     at,
+		},
+	}
+	setStepRawLog(session.Metadata, rawLogKey, parsedLog.RawLogID)
+	if err := d.sessions.Create(ctx, session); err != nil {
+		return err
+	}
+	state.active = session
+	return nil
+}
+
+// endSession closes the active session with the given status
+func (d *SessionDetectorService) endSession(ctx context.Context, state *serverSessions, status entities.SessionStatus, parsedLog *entities.ParsedLog, at time.Time) error {
+	session := *state.active
+	session.EndedAt = &at
+	session.Status = status
+	session.Metadata = make(map[string]interface{}, len(state.active.Metadata)+1)
+	for k, v := range state.active.Metadata {
+		session.Metadata[k] = v
+	}
+	session.Metadata["closed_by"] = parsedLog.EventType
+	setStepRawLog(session.Metadata, "closed_by"+stepRawLogSuffix, parsedLog.RawLogID)
+
+	if err := d.sessions.Update(ctx, &session); err != nil {
+		return err
+	}
+	state.active = nil
+	state.ended = &session
+	return nil
+}
+
+// serverState returns the session state of a server
+func (d *SessionDetectorService) serverState(serverID string) *serverSessions {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.servers[serverID]
+	if !ok {
+		state = &serverSessions{}
+		d.servers[serverID] = state
+	}
+	return state
+}
+
+// stepRawLogSuffix marks the metadata keys that hold the raw log ID of a
+// session's start and end steps
+const stepRawLogSuffix = "_raw_log_id"
+
+// setStepRawLog records the raw log ID of a session step. Lines without one,
+// as in tests, are not recorded.
+func setStepRawLog(metadata map[string]interface{}, key, rawLogID string) {
+	if rawLogID != "" {
+		metadata[key] = rawLogID
+	}
+}
+
+// isSessionStep reports whether a raw log line already opened, continued or
+// closed the session
+func isSessionStep(session *entities.GameSession, rawLogID string) bool {
+	if rawLogID == "" {
+		return false
+	}
+	for key, value := range session.Metadata {
+		if strings.HasSuffix(key, stepRawLogSuffix) && value == rawLogID {
+			return true
+		}
+	}
+	return false
+}
+
+// continuesSession reports whether a start event is the next step of the
+// active session on the same map rather than the start of a new one
+func continuesSession(active *entities.GameSession, eventType, mapName string) bool {
+	if mapName != "" && active.MapName != "" && mapName != active.MapName {
+		return false
+	}
+	_, started := active.Metadata["started_map_at"]
+	_, matchStarted := active.Metadata["match_start_at"]
+	switch eventType {
+	case "started_map":
+		return !started && !matchStarted
+	case "match_start":
+		return !matchStarted
+	}
+	return false
+}
+
+// recordGameOver copies the mode and final score of a game over event into
+// the session metadata
+func recordGameOver(session *entities.GameSession, parsedLog *entities.ParsedLog) {
+	if session.Metadata == nil {
+		session.Metadata = make(map[string]interface{})
+	}
+	if mode, ok := strings.CutPrefix(parsedLog.EventType, "game_over_"); ok {
+		session.Metadata["game_mode"] = mode
+	}
+	for _, key := range []string{"mode", "score_ct", "score_t", "duration"} {
+		if value, ok := parsedLog.EventData[key]; ok {
+			if key == "mode" {
+				key = "game_mode"
+			}
+			session.Metadata[key] = value
+		}
+	}
+	if session.MapName == "" {
+		session.MapName = eventString(parsedLog.EventData, "map")
+	}
+}
+
+// sessionEventTime returns when an event was logged, falling back to when it
+// was parsed for lines without a game timestamp
+func sessionEventTime(parsedLog *entities.ParsedLog) time.Time {
+	if parsedLog.EventTime != nil {
+		return parsedLog.EventTime.UTC()
+	}
+	if !parsedLog.CreatedAt.IsZero() {
+		return parsedLog.CreatedAt.UTC()
+	}
+	return time.Now().UTC()
+}
+
+// eventString reads a string field from event data
+func eventString(eventData map[string]interface{}, key string) string {
+	value, _ := eventData[key].(string)
+	return value
+}
@@ -1,29 +1,40 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
 )
 
-// StatefulParserService handles multi-line log assembly for CS2
+// StatefulParserService handles multi-line log assembly for CS2. Each
+// server's buffer has its own lock, so servers are parsed concurrently.
 type StatefulParserService struct {
 	db          *sqlx.DB
 	parser      *ParserService
 	buffers     map[string]*LogBuffer // serverID -> buffer
-	bufferMutex sync.RWMutex
+	bufferMutex sync.RWMutex          // guards the map, not the buffers
 }
 
 // LogBuffer holds multi-line log data being assembled
 type LogBuffer struct {
+	mu      sync.Mutex
+	removed bool // dropped by CleanupOldBuffers
+	
 	ServerID       string
 	InJSONBlock    bool
 	JSONLines      []string
 	JSONStartTime  time.Time
+	JSONEventTime  *time.Time
 	FirstRawLogID  string
 	LastRawLogID   string
 }
@@ -42,11 +53,17 @@ type RoundStats struct {
 	RawData      map[string]interface{} `json:"raw_data"` // For any additional fields
 }
 
-// NewStatefulParserService creates a new stateful parser service
-func NewStatefulParserService(db *sqlx.DB) *StatefulParserService {
+// NewStatefulParserService creates a new stateful parser service. Parsed
+// events and failed parses are stored in parsedLogs and failedParses,
+// stamped with the session, round and phase found by trackers.
+func NewStatefulParserService(db *sqlx.DB, parsedLogs repositories.ParsedLogRepository, failedParses repositories.FailedParseRepository, trackers EventTrackers) *StatefulParserService {
+	parser := NewParserService(db)
+	parser.parsedLogs = parsedLogs
+	parser.failedParses = failedParses
+	parser.trackers = trackers
 	return &StatefulParserService{
 		db:      db,
-		parser:  NewParserService(db),
+		parser:  parser,
 		buffers: make(map[string]*LogBuffer),
 	}
 }
@@ -57,8 +74,8 @@ func (s *StatefulParserService) ParseAndStore(rawLogID, serverID, content string
 	actualContent := s.parser.ExtractActualContent(content)
 	
 	// Check if this is part of a JSON statistics block
-	if s.isJSONStatsLine(actualContent) {
-		return s.handleJSONStatsLine(rawLogID, serverID, actualContent)
+	if isJSONStatsLine(actualContent) {
+		return s.handleJSONStatsLine(rawLogID, serverID, actualContent, s.parser.EventTime(serverID, content))
 	}
 	
 	// Otherwise, use the regular parser
@@ -66,14 +83,14 @@ func (s *StatefulParserService) ParseAndStore(rawLogID, serverID, content string
 }
 
 // isJSONStatsLine checks if a line is part of JSON statistics
-func (s *StatefulParserService) isJSONStatsLine(content string) bool {
+func isJSONStatsLine(content string) bool {
 	return strings.Contains(content, "JSON_BEGIN{") ||
 		strings.Contains(content, "}}JSON_END") ||
-		s.isInJSONBlock(content)
+		isInJSONBlock(content)
 }
 
 // isInJSONBlock checks if we're currently in a JSON block for any server
-func (s *StatefulParserService) isInJSONBlock(content string) bool {
+func isInJSONBlock(content string) bool {
 	// Check if the line looks like JSON data
 	return (strings.Contains(content, "\"name\"") && strings.Contains(content, "round_stats")) ||
 		(strings.Contains(content, "\"round_number\"") && strings.Contains(content, ":")) ||
@@ -87,19 +104,9 @@ func (s *StatefulParserService) isInJSONBlock(content string) bool {
 }
 
 // handleJSONStatsLine processes a line that's part of JSON statistics
-func (s *StatefulParserService) handleJSONStatsLine(rawLogID, serverID, content string) error {
-	s.bufferMutex.Lock()
-	defer s.bufferMutex.Unlock()
-	
-	// Get or create buffer for this server
-	buffer, exists := s.buffers[serverID]
-	if !exists {
-		buffer = &LogBuffer{
-			ServerID:  serverID,
-			JSONLines: []string{},
-		}
-		s.buffers[serverID] = buffer
-	}
+func (s *StatefulParserService) handleJSONStatsLine(rawLogID, serverID, content string, eventTime *time.Time) error {
+	buffer := s.lockBuffer(serverID)
+	defer buffer.mu.Unlock()
 	
 	// Handle JSON_BEGIN
 	if strings.Contains(content, "JSON_BEGIN{") {
@@ -107,6 +114,7 @@ func (s *StatefulParserService) handleJSONStatsLine(rawLogID, serverID, content
 		buffer.InJSONBlock = true
 		buffer.JSONLines = []string{"{"}
 		buffer.JSONStartTime = time.Now()
+		buffer.JSONEventTime = eventTime
 		buffer.FirstRawLogID = rawLogID
 		buffer.LastRawLogID = rawLogID
 		return nil
@@ -115,16 +123,19 @@ func (s *StatefulParserService) handleJSONStatsLine(rawLogID, serverID, content
 	// Handle JSON_END
 	if strings.Contains(content, "}}JSON_END") {
 		if buffer.InJSONBlock {
-			// Close the JSON object
-			buffer.JSONLines = append(buffer.JSONLines, "}")
+			// }}JSON_END closes the players object and the block
+			lines := append(append([]string(nil), buffer.JSONLines...), "}", "}")
 			buffer.LastRawLogID = rawLogID
 			
 			// Assemble and store the complete JSON
-			err := s.assembleAndStoreJSON(buffer)
+			err := s.assembleAndStoreJSON(buffer, lines)
 			
-			// Reset buffer
-			buffer.InJSONBlock = false
-			buffer.JSONLines = []string{}
+			// Reset buffer. A block that could not be stored is kept, so
+			// the JSON_END line stores it when its job is retried.
+			if err == nil || errors.Is(err, ErrLineUnparseable) {
+				buffer.InJSONBlock = false
+				buffer.JSONLines = []string{}
+			}
 			
 			return err
 		}
@@ -151,7 +162,8 @@ func (s *StatefulParserService) handleJSONStatsLine(rawLogID, serverID, content
 // extractJSONContent extracts the JSON content from a log line
 func (s *StatefulParserService) extractJSONContent(content string) string {
 	// The content should be like: "field_name" : "value"
-	// or for players: "player_X" : "data"
+	// or for players: "player_X" : "data", after the log timestamp
+	content = logTimestampPrefix.ReplaceAllString(content, "")
 	
 	// Handle player data lines
 	if strings.Contains(content, "\"player_") {
@@ -183,68 +195,123 @@ func (s *StatefulParserService) extractJSONContent(content string) string {
 	return ""
 }
 
-// assembleAndStoreJSON assembles the buffered lines into a JSON object and stores it
-func (s *StatefulParserService) assembleAndStoreJSON(buffer *LogBuffer) error {
+// assembleAndStoreJSON assembles the lines of the buffered block into a JSON
+// object and stores it. A block that is not valid JSON is stored line by line
+// and reported as ErrLineUnparseable.
+func (s *StatefulParserService) assembleAndStoreJSON(buffer *LogBuffer, lines []string) error {
 	// Join all lines to create the JSON string
-	jsonStr := strings.Join(buffer.JSONLines, "\n")
+	jsonStr := joinJSONLines(lines)
 	
 	// Try to parse it as JSON to validate
-	var rawData map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonStr), &rawData); err != nil {
+	rawData, err := decodeEventData(jsonStr)
+	if err != nil {
 		// If parsing fails, store individual lines using regular parser
 		// This handles malformed JSON gracefully
-		for _, line := range buffer.JSONLines {
+		for _, line := range lines {
 			if line != "{" && line != "}" {
 				// Create a synthetic log line for each JSON field
 				s.parser.ParseAndStore(buffer.LastRawLogID, buffer.ServerID, line)
 			}
 		}
-		return fmt.Errorf("failed to parse JSON stats: %w", err)
+		// Retrying would give the same result
+		return fmt.Errorf("%w: failed to parse JSON stats: %v", ErrLineUnparseable, err)
 	}
 	
 	// Successfully parsed - store as a single round_stats event
-	eventData, err := json.Marshal(rawData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal round stats: %w", err)
-	}
-	
-	// Store the assembled round statistics
-	query := `
-		INSERT INTO parsed_logs (raw_log_id, server_id, event_type, event_data, session_id, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-	`
-	
 	// Use the last raw_log_id as the reference
 	// Event type is "round_stats" for the complete assembled statistics
-	_, err = s.db.Exec(query, 
-		buffer.LastRawLogID, 
-		buffer.ServerID, 
-		"round_stats",
-		string(eventData),
-		nil, // session_id can be set if available
-		buffer.JSONStartTime,
-	)
+	stats := s.parser.newStoredEvent(buffer.LastRawLogID, buffer.ServerID, "round_stats", rawData, buffer.JSONEventTime)
+	stats.ClassificationSource = entities.ClassificationParser
+	stats.CreatedAt = buffer.JSONStartTime
 	
+	// Round stats belong to the session and round they are logged in
+	if err := s.parser.trackEvent(stats, ""); err != nil {
+		return err
+	}
+	
+	// A block parsed before its jobs were handed out again is already stored
+	err = s.parser.parsedLogs.Create(context.Background(), stats)
+	if errors.Is(err, repositories.ErrParsedLogExists) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to store round stats: %w", err)
 	}
+	s.parser.events.Publish(stats)
 	
 	// Also store a reference for the first raw_log_id if different
 	if buffer.FirstRawLogID != buffer.LastRawLogID {
 		// Store a reference entry pointing to the complete stats
-		_, err = s.db.Exec(query,
-			buffer.FirstRawLogID,
-			buffer.ServerID,
-			"round_stats_ref",
-			fmt.Sprintf(`{"refers_to_raw_log_id":"%s"}`, buffer.LastRawLogID),
-			nil,
-			buffer.JSONStartTime,
-		)
+		ref := s.parser.newStoredEvent(buffer.FirstRawLogID, buffer.ServerID, "round_stats_ref",
+			map[string]interface{}{"refers_to_raw_log_id": buffer.LastRawLogID}, buffer.JSONEventTime)
+		ref.SessionID = stats.SessionID
+		ref.RoundNumber = stats.RoundNumber
+		ref.GamePhase = stats.GamePhase
+		ref.CreatedAt = buffer.JSONStartTime
+		// The stats are already stored, so a missing reference is logged
+		// rather than returned: retrying the block would store them twice
+		if err := s.parser.parsedLogs.Create(context.Background(), ref); err != nil {
+			log.Printf("Failed to store round stats reference for raw log %s: %v", buffer.FirstRawLogID, err)
+		} else {
+			s.parser.events.Publish(ref)
+		}
 	}
 	
 	return nil
 }
 
+// jsonMemberPattern matches a `"key" : "value",` line of a JSON block
+var jsonMemberPattern = regexp.MustCompile(`^"([^"]+)"\s*:\s*"(.*)"(,?)$`)
+
+// joinJSONLines joins the lines of a JSON block. The server neither escapes
+// quotes in values (server names) nor ends the player lines with a comma, so
+// values are quoted again and missing commas added between members.
+func joinJSONLines(lines []string) string {
+	var b strings.Builder
+	prev := ""
+	for i, line := range lines {
+		if match := jsonMemberPattern.FindStringSubmatch(line); match != nil {
+			key, _ := json.Marshal(match[1])
+			value, _ := json.Marshal(match[2])
+			line = string(key) + ": " + string(value) + match[3]
+		}
+		if i > 0 {
+			if !strings.HasSuffix(prev, ",") && !strings.HasSuffix(prev, "{") && !strings.HasPrefix(line, "}") {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(line)
+		prev = line
+	}
+	return b.String()
+}
+
+// lockBuffer gets or creates the buffer of a server and locks it. The map
+// is only locked for the lookup, so a server storing its block does not
+// hold up the others.
+func (s *StatefulParserService) lockBuffer(serverID string) *LogBuffer {
+	for {
+		s.bufferMutex.Lock()
+		buffer, exists := s.buffers[serverID]
+		if !exists {
+			buffer = &LogBuffer{
+				ServerID:  serverID,
+				JSONLines: []string{},
+			}
+			s.buffers[serverID] = buffer
+		}
+		s.bufferMutex.Unlock()
+		
+		buffer.mu.Lock()
+		if !buffer.removed {
+			return buffer
+		}
+		// Dropped as stale after the lookup; use the server's new buffer
+		buffer.mu.Unlock()
+	}
+}
+
 // CleanupOldBuffers removes stale buffers (for servers that disconnected mid-JSON)
 func (s *StatefulParserService) CleanupOldBuffers(maxAge time.Duration) {
 	s.bufferMutex.Lock()
@@ -252,9 +319,15 @@ func (s *StatefulParserService) CleanupOldBuffers(maxAge time.Duration) {
 	
 	now := time.Now()
 	for serverID, buffer := range s.buffers {
+		// A buffer in use is not stale
+		if !buffer.mu.TryLock() {
+			continue
+		}
 		if buffer.InJSONBlock && now.Sub(buffer.JSONStartTime) > maxAge {
 			// Buffer is too old, probably incomplete
+			buffer.removed = true
 			delete(s.buffers, serverID)
 		}
+		buffer.mu.Unlock()
 	}
 }
\ No newline at end of file
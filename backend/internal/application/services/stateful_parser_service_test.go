@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+// matchTestStatsLines is a round_stats block of debug/match-test.txt as the
+// buffer holds it: the member lines between JSON_BEGIN{ and }}JSON_END,
+// opened by "{" and closed by "}" "}"
+var matchTestStatsLines = []string{
+	"{",
+	`"name": "round_stats",`,
+	`"round_number" : "36",`,
+	`"score_t" : "18",`,
+	`"score_ct" : "17",`,
+	`"map" : "de_dust2",`,
+	`"server" : "DraculaN | team_SHESKY vs team_xHaPPy_",`,
+	`"fields" : "             accountid,   team,  money,  kills, deaths,assists,    dmg,    hsp,    kdr,    adr,    mvp,     ef,     ud,     3k,     4k,     5k,clutchk, firstk,pistolk,sniperk, blindk,  bombk,firedmg,uniquek,  dinks,chickenk"`,
+	`"players" : {`,
+	`"player_0" : "                   0,      0,  10000,      0,      0,      0,      0,   0.00,   0.00,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0,      0"`,
+	`"player_6" : "           387734521,      2,   8700,     32,     19,      4,   3783,  59.38,   1.68,    107,     12,      9,    338,      2,      0,      0,     13,     10,      2,      3,      1,     12,    138,    204,     11,      2"`,
+	"}",
+	"}",
+}
+
+func TestJoinJSONLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "round stats block",
+			lines: matchTestStatsLines,
+			want: map[string]interface{}{
+				"name":         "round_stats",
+				"round_number": "36",
+				"score_t":      "18",
+				"score_ct":     "17",
+				"map":          "de_dust2",
+				"server":       "DraculaN | team_SHESKY vs team_xHaPPy_",
+				"fields":       matchTestStatsLines[7][12 : len(matchTestStatsLines[7])-1],
+				"players": map[string]interface{}{
+					"player_0": matchTestStatsLines[9][14 : len(matchTestStatsLines[9])-1],
+					"player_6": matchTestStatsLines[10][14 : len(matchTestStatsLines[10])-1],
+				},
+			},
+		},
+		{
+			name: "unescaped quotes in server name",
+			lines: []string{
+				"{",
+				`"name": "round_stats",`,
+				`"server" : "The "Best" Server",`,
+				`"players" : {`,
+				"}",
+				"}",
+			},
+			want: map[string]interface{}{
+				"name":    "round_stats",
+				"server":  `The "Best" Server`,
+				"players": map[string]interface{}{},
+			},
+		},
+		{
+			name: "player lines without commas",
+			lines: []string{
+				"{",
+				`"players" : {`,
+				`"player_0" : "1, 2"`,
+				`"player_1" : "3, 4"`,
+				"}",
+				"}",
+			},
+			want: map[string]interface{}{
+				"players": map[string]interface{}{
+					"player_0": "1, 2",
+					"player_1": "3, 4",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append([]string(nil), tt.lines...)
+
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(joinJSONLines(lines)), &got); err != nil {
+				t.Fatalf("joined block is not valid JSON: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("joinJSONLines() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(lines, tt.lines) {
+				t.Errorf("joinJSONLines() modified its input: %q", lines)
+			}
+		})
+	}
+}
+
+func TestExtractJSONContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "member after the log timestamp",
+			content: `L 08/19/2025 - 19:02:08: "round_number" : "36",`,
+			want:    `"round_number" : "36",`,
+		},
+		{
+			name:    "player after the log timestamp",
+			content: "L 08/19/2025 - 19:02:08: " + matchTestStatsLines[10],
+			want:    matchTestStatsLines[10],
+		},
+		{
+			name:    "member without a timestamp",
+			content: `"map" : "de_dust2",`,
+			want:    `"map" : "de_dust2",`,
+		},
+		{
+			name:    "closing brace",
+			content: "L 08/19/2025 - 19:02:08: }",
+			want:    "}",
+		},
+		{
+			name:    "not a member",
+			content: `L 08/19/2025 - 19:02:08: World triggered "Round_Start"`,
+			want:    "",
+		},
+	}
+
+	s := &StatefulParserService{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.extractJSONContent(tt.content); got != tt.want {
+				t.Errorf("extractJSONContent(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+// failingParsedLogRepository fails to store the next event while failNext
+// is set
+type failingParsedLogRepository struct {
+	*persistence.MemoryParsedLogRepository
+	failNext bool
+}
+
+func (r *failingParsedLogRepository) Create(ctx context.Context, parsedLog *entities.ParsedLog) error {
+	if r.failNext {
+		r.failNext = false
+		return errors.New("connection reset")
+	}
+	return r.MemoryParsedLogRepository.Create(ctx, parsedLog)
+}
+
+func TestRoundStatsBlockIsKeptWhenStoringFails(t *testing.T) {
+	repo := &failingParsedLogRepository{MemoryParsedLogRepository: persistence.NewMemoryParsedLogRepository()}
+	parser := NewStatefulParserService(nil, repo, persistence.NewMemoryFailedParseRepository(), EventTrackers{})
+
+	lines := []string{
+		`L 08/19/2025 - 19:02:08: JSON_BEGIN{`,
+		`L 08/19/2025 - 19:02:08: "name": "round_stats",`,
+		`L 08/19/2025 - 19:02:08: "round_number" : "36",`,
+		`L 08/19/2025 - 19:02:08: "players" : {`,
+		`L 08/19/2025 - 19:02:08: "player_0" : "1, 2"`,
+	}
+	for i, line := range lines {
+		if err := parser.ParseAndStore(fmt.Sprintf("raw-%d", i), "server", line); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+	}
+
+	// The JSON_END line is retried in place after storing fails
+	end := `L 08/19/2025 - 19:02:08: }}JSON_END`
+	repo.failNext = true
+	if err := parser.ParseAndStore("end", "server", end); err == nil {
+		t.Fatal("storing the block did not fail")
+	}
+	if err := parser.ParseAndStore("end", "server", end); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	var stats []*entities.ParsedLog
+	for _, parsedLog := range repo.List() {
+		if parsedLog.EventType == "round_stats" {
+			stats = append(stats, parsedLog)
+		}
+	}
+	if len(stats) != 1 {
+		t.Fatalf("got %d round_stats, want 1", len(stats))
+	}
+	if stats[0].EventData["round_number"] != "36" {
+		t.Errorf("round_stats: got round %v, want 36", stats[0].EventData["round_number"])
+	}
+	players, _ := stats[0].EventData["players"].(map[string]interface{})
+	if len(players) != 1 {
+		t.Errorf("round_stats: got players %v, want player_0 only", stats[0].EventData["players"])
+	}
+}
@@ -0,0 +1,45 @@
+package entities
+
+import "time"
+
+// IngestBatch tracks the lines received in a single ingestion request
+type IngestBatch struct {
+	ID             string     `json:"id" db:"id"`
+	ServerID       string     `json:"server_id" db:"server_id"`
+	IdempotencyKey *string    `json:"idempotency_key,omitempty" db:"idempotency_key"`
+	SavedCount     int        `json:"saved_count" db:"saved_count"`
+	DuplicateCount int        `json:"duplicate_count" db:"duplicate_count"`
+	ParsedCount    int        `json:"parsed_count" db:"parsed_count"`
+	FailedCount    int        `json:"failed_count" db:"failed_count"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+
+	// Replayed is set when the request reused an idempotency key and the
+	// batch is the one originally saved under it
+	Replayed bool `json:"-" db:"-"`
+
+	// NoReceipt saves the lines without an ingest_batches row, for senders
+	// that never ask for the batch status
+	NoReceipt bool `json:"-" db:"-"`
+}
+
+// IngestLine is a log line to be saved, with the hash used to detect lines
+// that were already saved. Hash is nil for lines that are never de-duplicated.
+type IngestLine struct {
+	Content string
+	Hash    []byte
+}
+
+// PendingCount returns the number of lines not yet parsed or failed
+func (b *IngestBatch) PendingCount() int {
+	pending := b.SavedCount - b.ParsedCount - b.FailedCount
+	if pending < 0 {
+		return 0
+	}
+	return pending
+}
+
+// IsComplete reports whether every saved line has been processed
+func (b *IngestBatch) IsComplete() bool {
+	return b.PendingCount() == 0
+}
@@ -14,23 +14,56 @@ type Log struct {
 
 // ParsedLog represents a successfully parsed log entry
 type ParsedLog struct {
-	ID        string                 `json:"id"`
-	RawLogID  string                 `json:"raw_log_id"`
-	ServerID  string                 `json:"server_id"`
-	EventType string                 `json:"event_type"`
-	EventData map[string]interface{} `json:"event_data"`
-	GameTime  string                 `json:"game_time,omitempty"`
-	SessionID string                 `json:"session_id,omitempty"`
-	CreatedAt time.Time              `json:"created_at"`
+	ID          string                 `json:"id"`
+	RawLogID    string                 `json:"raw_log_id"`
+	ServerID    string                 `json:"server_id"`
+	EventType   string                 `json:"event_type"`
+	EventData   map[string]interface{} `json:"event_data"`
+	GameTime    string                 `json:"game_time,omitempty"`
+	EventTime   *time.Time             `json:"event_time,omitempty"`
+	SessionID   string                 `json:"session_id,omitempty"`
+	RoundNumber *int                   `json:"round_number,omitempty"`
+	GamePhase   *string                `json:"game_phase,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+
+	// ParserVersion is the parser version that produced the event
+	ParserVersion string `json:"parser_version,omitempty"`
+
+	// How the event type was found; Confidence is only set for guesses
+	ClassificationSource string   `json:"classification_source,omitempty"`
+	Confidence           *float64 `json:"classification_confidence,omitempty"`
+	ParseRule            string   `json:"parse_rule,omitempty"` // name of the rule that matched
 }
 
+// Classification sources of parsed logs
+const (
+	ClassificationParser    = "parser"    // parsed by cs2-log
+	ClassificationHeuristic = "heuristic" // guessed from the line's content
+	ClassificationRule      = "rule"      // matched a custom parse rule
+)
+
 // FailedParse represents a log that couldn't be parsed
 type FailedParse struct {
-	ID           string    `json:"id"`
-	RawLogID     string    `json:"raw_log_id"`
-	ErrorMessage string    `json:"error_message"`
-	RetryCount   int       `json:"retry_count"`
-	LastRetry    time.Time `json:"last_retry,omitempty"`
-	Resolved     bool      `json:"resolved"`
-	CreatedAt    time.Time `json:"created_at"`
-}
\ No newline at end of file
+	ID            string     `json:"id" db:"id"`
+	RawLogID      string     `json:"raw_log_id" db:"raw_log_id"`
+	ErrorMessage  string     `json:"error_message" db:"error_message"`
+	RetryCount    int        `json:"retry_count" db:"retry_count"`
+	LastRetry     *time.Time `json:"last_retry,omitempty" db:"last_retry"`
+	NextRetryAt   *time.Time `json:"next_retry_at,omitempty" db:"next_retry_at"` // set when a retry was requested
+	Resolved      bool       `json:"resolved" db:"resolved"`
+	ParserVersion string     `json:"parser_version,omitempty" db:"parser_version"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+
+	// Filled in from the raw log when failed parses are retried
+	ServerID string `json:"server_id,omitempty" db:"server_id"`
+	Content  string `json:"content,omitempty" db:"content"`
+}
+
+// RetryPolicy is how failed parses are retried: retry n+1 follows the last
+// attempt by BaseDelay * 2^n, capped at MaxDelay, for up to MaxRetries
+// retries. A requested retry runs as soon as possible.
+type RetryPolicy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	MaxRetries int
+}
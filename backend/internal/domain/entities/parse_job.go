@@ -0,0 +1,24 @@
+package entities
+
+import "time"
+
+// ParseJob is a saved raw log line queued for parsing
+type ParseJob struct {
+	ID        int64          `json:"id" db:"id"`
+	RawLogID  string         `json:"raw_log_id" db:"raw_log_id"`
+	ServerID  string         `json:"server_id" db:"server_id"`
+	Content   string         `json:"content" db:"content"`
+	Status    ParseJobStatus `json:"status" db:"status"`
+	Attempts  int            `json:"attempts" db:"attempts"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+// ParseJobStatus represents the state of a queued parse job
+type ParseJobStatus string
+
+const (
+	ParseJobPending    ParseJobStatus = "pending"
+	ParseJobProcessing ParseJobStatus = "processing"
+	ParseJobDone       ParseJobStatus = "done"
+	ParseJobFailed     ParseJobStatus = "failed"
+)
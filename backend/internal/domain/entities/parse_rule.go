@@ -0,0 +1,67 @@
+package entities
+
+import "time"
+
+// ParseRule classifies custom log lines, e.g. from server plugins, with a
+// regular expression. The named groups of the pattern become the fields of
+// the event data; a group named attacker__name is written as
+// {"attacker": {"name": ...}}.
+type ParseRule struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Pattern     string            `json:"pattern"`
+	EventType   string            `json:"event_type"`
+	Stage       ParseRuleStage    `json:"stage"`
+	Priority    int               `json:"priority"` // lower runs first
+	FieldTypes  map[string]string `json:"field_types,omitempty"`
+	Enabled     bool              `json:"enabled"`
+	Source      string            `json:"source"` // ParseRuleSourceFile or ParseRuleSourceDatabase
+	CreatedBy   string            `json:"created_by,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// ParseRuleStage is when a rule runs relative to cs2-log
+type ParseRuleStage string
+
+const (
+	// ParseRuleBefore rules run before cs2-log and take precedence over it
+	ParseRuleBefore ParseRuleStage = "before"
+
+	// ParseRuleAfter rules run on lines cs2-log cannot parse or parses as
+	// Unknown, before the heuristics
+	ParseRuleAfter ParseRuleStage = "after"
+)
+
+// Where parse rules are loaded from
+const (
+	ParseRuleSourceFile     = "file"
+	ParseRuleSourceDatabase = "database"
+)
+
+// Field types of parse rule groups; groups are strings by default
+var ParseRuleFieldTypes = map[string]bool{
+	"string": true,
+	"int":    true,
+	"float":  true,
+	"bool":   true,
+}
+
+// ParseRuleDryRun is the result of running a rule against stored raw logs
+type ParseRuleDryRun struct {
+	Scanned int64                   `json:"scanned"`
+	Matched int64                   `json:"matched"`
+	Changed int64                   `json:"changed"` // matched lines stored as another event type
+	Samples []*ParseRuleDryRunMatch `json:"samples"`
+}
+
+// ParseRuleDryRunMatch is a raw log a rule matched
+type ParseRuleDryRunMatch struct {
+	RawLogID         string                 `json:"raw_log_id"`
+	ServerID         string                 `json:"server_id"`
+	Content          string                 `json:"content"`
+	CurrentEventType string                 `json:"current_event_type,omitempty"`
+	EventType        string                 `json:"event_type"`
+	EventData        map[string]interface{} `json:"event_data"`
+}
@@ -0,0 +1,95 @@
+package entities
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// steamID64Base is the SteamID64 of account 0 of an individual account in
+// the public universe; a SteamID64 is this plus the account ID
+const steamID64Base = 76561197960265728
+
+var (
+	steamID3Pattern   = regexp.MustCompile(`^\[?U:1:(\d+)\]?$`)
+	steamID2Pattern   = regexp.MustCompile(`^STEAM_[0-5]:([01]):(\d+)$`)
+	steamID64Pattern  = regexp.MustCompile(`^7656119\d{10}$`)
+	maxSteamAccountID = uint64(1<<32 - 1)
+)
+
+// Player is a player seen in the logs, identified by SteamID
+type Player struct {
+	SteamID64   string         `json:"steam_id64" db:"steam_id64"`
+	SteamID3    string         `json:"steam_id3" db:"steam_id3"`
+	AccountID   int64          `json:"account_id" db:"account_id"`
+	Name        string         `json:"name" db:"name"` // latest name
+	FirstSeenAt time.Time      `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt  time.Time      `json:"last_seen_at" db:"last_seen_at"`
+	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
+	Names       []PlayerName   `json:"names,omitempty" db:"-"`
+	Servers     []PlayerServer `json:"servers,omitempty" db:"-"`
+}
+
+// PlayerName is a name a player used
+type PlayerName struct {
+	Name        string    `json:"name" db:"name"`
+	FirstSeenAt time.Time `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// PlayerServer is a server a player was seen on
+type PlayerServer struct {
+	ServerID    string    `json:"server_id" db:"server_id"`
+	ServerName  *string   `json:"server_name,omitempty" db:"server_name"`
+	FirstSeenAt time.Time `json:"first_seen_at" db:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at" db:"last_seen_at"`
+}
+
+// NewPlayer creates a player from a Steam account ID
+func NewPlayer(accountID uint32, name string) *Player {
+	return &Player{
+		SteamID64: SteamID64(accountID),
+		SteamID3:  fmt.Sprintf("[U:1:%d]", accountID),
+		AccountID: int64(accountID),
+		Name:      name,
+	}
+}
+
+// SteamID64 returns the SteamID64 of a Steam account ID
+func SteamID64(accountID uint32) string {
+	return strconv.FormatUint(steamID64Base+uint64(accountID), 10)
+}
+
+// ParseSteamID reads the account ID of a SteamID given as SteamID3
+// ([U:1:215888626]), SteamID2 (STEAM_1:0:107944313) or SteamID64
+// (76561198176154354)
+func ParseSteamID(steamID string) (uint32, bool) {
+	var accountID uint64
+	var err error
+	switch {
+	case steamID3Pattern.MatchString(steamID):
+		accountID, err = strconv.ParseUint(steamID3Pattern.FindStringSubmatch(steamID)[1], 10, 64)
+	case steamID2Pattern.MatchString(steamID):
+		match := steamID2Pattern.FindStringSubmatch(steamID)
+		var z uint64
+		z, err = strconv.ParseUint(match[2], 10, 64)
+		accountID = z * 2
+		if match[1] == "1" {
+			accountID++
+		}
+	case steamID64Pattern.MatchString(steamID):
+		var id uint64
+		id, err = strconv.ParseUint(steamID, 10, 64)
+		if id < steamID64Base {
+			return 0, false
+		}
+		accountID = id - steamID64Base
+	default:
+		return 0, false
+	}
+	if err != nil || accountID == 0 || accountID > maxSteamAccountID {
+		return 0, false
+	}
+	return uint32(accountID), true
+}
@@ -0,0 +1,89 @@
+package entities
+
+import "time"
+
+// MatchResult is how a match ended for a player
+type MatchResult string
+
+const (
+	MatchResultWin  MatchResult = "win"
+	MatchResultLoss MatchResult = "loss"
+	MatchResultDraw MatchResult = "draw"
+)
+
+// PlayerProfile is a player's statistics over all the matches they played
+type PlayerProfile struct {
+	Player           *Player        `json:"player"`
+	Lifetime         PlayerStats    `json:"lifetime"`
+	FavouriteWeapons []*WeaponStats `json:"favourite_weapons"`
+	Maps             []*MapStats    `json:"maps"`
+	RecentForm       RecentForm     `json:"recent_form"`
+}
+
+// PlayerStats adds up a player's scores over a set of matches. ADR is over
+// all rounds of those matches, as on the scoreboard.
+type PlayerStats struct {
+	Matches       int     `json:"matches"`
+	Wins          int     `json:"wins"`
+	Losses        int     `json:"losses"`
+	Draws         int     `json:"draws"`
+	Rounds        int     `json:"rounds"`
+	RoundsPlayed  int     `json:"rounds_played"`
+	Kills         int     `json:"kills"`
+	Deaths        int     `json:"deaths"`
+	Assists       int     `json:"assists"`
+	HeadshotKills int     `json:"headshot_kills"`
+	Damage        int     `json:"damage"`
+	MVPs          int     `json:"mvps"`
+	KD            float64 `json:"kd"`
+	ADR           float64 `json:"adr"`
+	HeadshotPct   float64 `json:"hs_pct"`
+	WinRate       float64 `json:"win_rate"` // percentage of finished matches won
+}
+
+// MapStats is a player's statistics on one map
+type MapStats struct {
+	MapName string `json:"map_name"`
+	PlayerStats
+}
+
+// WeaponStats counts a player's kills with one weapon
+type WeaponStats struct {
+	Weapon        string  `json:"weapon"`
+	Kills         int     `json:"kills"`
+	HeadshotKills int     `json:"headshot_kills"`
+	HeadshotPct   float64 `json:"hs_pct"`
+}
+
+// RecentForm is how a player did in their latest matches
+type RecentForm struct {
+	Results []MatchResult `json:"results"` // most recent first
+	Stats   PlayerStats   `json:"stats"`
+}
+
+// PlayerMatch is a match a player took part in, with their score
+type PlayerMatch struct {
+	SessionID  string        `json:"session_id"`
+	ServerID   string        `json:"server_id"`
+	ServerName string        `json:"server_name,omitempty"`
+	MapName    string        `json:"map_name,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	EndedAt    *time.Time    `json:"ended_at,omitempty"`
+	Status     SessionStatus `json:"status"`
+	Rounds     int           `json:"rounds"`
+	ScoreCT    *int          `json:"score_ct,omitempty"`
+	ScoreT     *int          `json:"score_t,omitempty"`
+	Result     MatchResult   `json:"result,omitempty"` // empty while the match is in progress
+	Score      *PlayerScore  `json:"score"`
+}
+
+// SessionPlayerTotals is a player's score in a finished session. They are
+// stored for every player once the session is over, so profiles do not
+// rebuild the scoreboard of each session.
+type SessionPlayerTotals struct {
+	SessionID string         `json:"session_id"`
+	SteamID64 string         `json:"steam_id64"`
+	Rounds    int            `json:"rounds"` // rounds played in the session
+	Score     *PlayerScore   `json:"score"`
+	Weapons   []*WeaponStats `json:"weapons"` // kills of enemies by weapon
+}
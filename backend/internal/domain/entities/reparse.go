@@ -0,0 +1,81 @@
+package entities
+
+import "time"
+
+// ReparseJob reparses the raw logs of a server or receive time range with
+// the current parser, a chunk at a time. The cursor is the last raw log
+// reparsed, so an interrupted job resumes after it.
+type ReparseJob struct {
+	ID            string           `json:"id"`
+	ServerID      *string          `json:"server_id,omitempty"`
+	From          *time.Time       `json:"from,omitempty"` // raw logs received at or after
+	To            *time.Time       `json:"to,omitempty"`   // raw logs received before
+	StaleOnly     bool             `json:"stale_only"`     // only lines parsed by another parser version
+	ChunkSize     int              `json:"chunk_size"`
+	ParserVersion string           `json:"parser_version"`
+	Status        ReparseJobStatus `json:"status"`
+
+	CursorReceivedAt *time.Time `json:"cursor_received_at,omitempty"`
+	CursorRawLogID   *string    `json:"cursor_raw_log_id,omitempty"`
+
+	Processed        int64              `json:"processed"`
+	Parsed           int64              `json:"parsed"`
+	Failed           int64              `json:"failed"`
+	Skipped          int64              `json:"skipped"` // round_stats lines, which are not reparsed
+	Changed          int64              `json:"changed"` // lines whose event type changed
+	EventTypeChanges []*EventTypeChange `json:"event_type_changes"`
+
+	Error       *string    `json:"error,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// ReparseJobStatus represents the state of a reparse job
+type ReparseJobStatus string
+
+const (
+	ReparseJobPending   ReparseJobStatus = "pending"
+	ReparseJobRunning   ReparseJobStatus = "running"
+	ReparseJobCompleted ReparseJobStatus = "completed"
+	ReparseJobFailed    ReparseJobStatus = "failed"
+)
+
+// EventTypeFailed stands for a failed parse in event type changes
+const EventTypeFailed = "failed"
+
+// EventTypeChange counts the lines whose event type changed from one type to
+// another when they were reparsed. From is empty for lines that had no
+// parsed or failed row.
+type EventTypeChange struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// ReparseLine is a raw log to reparse, with the event types it was last
+// parsed as; a failed parse is EventTypeFailed
+type ReparseLine struct {
+	RawLogID   string
+	ServerID   string
+	Content    string
+	ReceivedAt time.Time
+	EventTypes []string
+}
+
+// ReparsedLine is the result of reparsing a raw log: either an event or the
+// parse error
+type ReparsedLine struct {
+	RawLogID   string
+	ServerID   string
+	EventType  string
+	EventData  string
+	Source     string // classification source
+	Confidence *float64
+	Rule       string // name of the parse rule that matched
+	EventTime  *time.Time
+	Error      string
+	Players    []*Player
+	Skipped    bool // left as it is
+}
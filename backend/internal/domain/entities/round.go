@@ -0,0 +1,31 @@
+package entities
+
+import "time"
+
+// GamePhase is the part of a match an event happened in
+type GamePhase string
+
+const (
+	GamePhaseWarmup    GamePhase = "warmup"
+	GamePhaseLive      GamePhase = "live"
+	GamePhaseHalftime  GamePhase = "halftime"
+	GamePhaseOvertime  GamePhase = "overtime"
+	GamePhasePostMatch GamePhase = "post_match"
+)
+
+// Round is a finished round of a match
+type Round struct {
+	ID          int64      `json:"id" db:"id"`
+	ServerID    string     `json:"server_id" db:"server_id"`
+	SessionID   *string    `json:"session_id,omitempty" db:"session_id"`
+	RoundNumber int        `json:"round_number" db:"round_number"`
+	GamePhase   GamePhase  `json:"game_phase" db:"game_phase"`
+	Winner      *string    `json:"winner,omitempty" db:"winner"`         // CT or TERRORIST; nil for a draw
+	WinReason   *string    `json:"win_reason,omitempty" db:"win_reason"` // e.g. target_bombed, bomb_defused
+	StartedAt   *time.Time `json:"started_at,omitempty" db:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty" db:"ended_at"`
+	DurationMs  *int64     `json:"duration_ms,omitempty" db:"duration_ms"`
+	ScoreCT     int        `json:"score_ct" db:"score_ct"` // score after the round
+	ScoreT      int        `json:"score_t" db:"score_t"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
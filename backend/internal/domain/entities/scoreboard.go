@@ -0,0 +1,48 @@
+package entities
+
+// Scoreboard is the per-player summary of a game session
+type Scoreboard struct {
+	SessionID  string           `json:"session_id"`
+	Rounds     int              `json:"rounds"` // rounds with events
+	Players    []*PlayerScore   `json:"players"`
+	RoundStats *RoundStatsCheck `json:"round_stats_check,omitempty"`
+}
+
+// PlayerScore is a player's line on the scoreboard. Players are identified
+// by the SteamID logged by the server.
+type PlayerScore struct {
+	SteamID        string  `json:"steam_id"`
+	Name           string  `json:"name"`
+	Team           string  `json:"team"` // side in the last round played
+	Kills          int     `json:"kills"`
+	Deaths         int     `json:"deaths"`
+	Assists        int     `json:"assists"`
+	FlashAssists   int     `json:"flash_assists"`
+	HeadshotKills  int     `json:"headshot_kills"`
+	Damage         int     `json:"damage"`
+	UtilityDamage  int     `json:"utility_damage"`
+	EnemiesFlashed int     `json:"enemies_flashed"`
+	MVPs           int     `json:"mvps"`
+	RoundsPlayed   int     `json:"rounds_played"`
+	KASTRounds     int     `json:"kast_rounds"`
+	KD             float64 `json:"kd"`
+	ADR            float64 `json:"adr"`
+	HeadshotPct    float64 `json:"hs_pct"`
+	KAST           float64 `json:"kast"` // percentage of rounds with a kill, assist, survival or trade
+}
+
+// RoundStatsCheck compares the scoreboard with the last round_stats block the
+// server logged, over the rounds the block covers
+type RoundStatsCheck struct {
+	RoundsCovered int            `json:"rounds_covered"`
+	Matches       bool           `json:"matches"`
+	Mismatches    []StatMismatch `json:"mismatches"`
+}
+
+// StatMismatch is a player stat that differs from the server's round_stats
+type StatMismatch struct {
+	SteamID    string  `json:"steam_id"`
+	Stat       string  `json:"stat"`
+	Computed   float64 `json:"computed"`
+	RoundStats float64 `json:"round_stats"`
+}
@@ -1,6 +1,7 @@
 package entities
 
 import (
+	"crypto/subtle"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,16 +9,40 @@ import (
 
 // Server represents a CS2 game server
 type Server struct {
-	ID          string         `json:"id" db:"id"`
-	Name        string         `json:"name" db:"name"`
-	IPAddress   string         `json:"ip_address" db:"ip_address"`
-	APIKey      string         `json:"api_key" db:"api_key"`
-	Description *string        `json:"description" db:"description"`
-	IsActive    bool           `json:"is_active" db:"is_active"`
-	LastSeen    *time.Time     `json:"last_seen" db:"last_seen"`
-	CreatedBy   *uuid.UUID     `json:"created_by" db:"created_by"`
-	CreatedAt   time.Time      `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+	ID                 string     `json:"id" db:"id"`
+	Name               string     `json:"name" db:"name"`
+	IPAddress          string     `json:"ip_address" db:"ip_address"`
+	APIKey             string     `json:"api_key" db:"api_key"`
+	RequireAPIKey      bool       `json:"require_api_key" db:"require_api_key"`
+	PreviousAPIKey     *string    `json:"-" db:"previous_api_key"`
+	PreviousKeyExpires *time.Time `json:"previous_api_key_expires_at,omitempty" db:"previous_api_key_expires_at"`
+	SigningSecret      *string    `json:"-" db:"signing_secret"`
+	Timezone           *string    `json:"timezone" db:"timezone"` // IANA zone of the log clock; nil is UTC
+	LogSecret          *string    `json:"log_secret,omitempty" db:"log_secret"`
+	RateLimitPerMinute *int       `json:"rate_limit_per_minute" db:"rate_limit_per_minute"` // nil uses the default
+	RateLimitBurst     *int       `json:"rate_limit_burst" db:"rate_limit_burst"`           // nil uses the default
+	Description        *string    `json:"description" db:"description"`
+	IsActive           bool       `json:"is_active" db:"is_active"`
+	LastSeen           *time.Time `json:"last_seen" db:"last_seen"`
+	CreatedBy          *uuid.UUID `json:"created_by" db:"created_by"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// MatchesAPIKey reports whether key is the server's API key, or its previous
+// key while that is still within its grace period. Keys are compared in
+// constant time.
+func (s *Server) MatchesAPIKey(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	if s.APIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(s.APIKey)) == 1 {
+		return true
+	}
+	if s.PreviousAPIKey != nil && s.PreviousKeyExpires != nil && now.Before(*s.PreviousKeyExpires) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(*s.PreviousAPIKey)) == 1
+	}
+	return false
 }
 
 // GameSession represents a game session (server or match)
@@ -29,6 +54,12 @@ type GameSession struct {
 	EndedAt   *time.Time             `json:"ended_at,omitempty"`
 	Status    SessionStatus          `json:"status"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+
+	// Filled in when sessions are queried
+	ServerName string `json:"server_name,omitempty"`
+	EventCount int64  `json:"event_count"`
+	ScoreCT    *int   `json:"score_ct,omitempty"` // from the latest team notice
+	ScoreT     *int   `json:"score_t,omitempty"`
 }
 
 // SessionStatus represents the current state of a session
@@ -73,9 +73,10 @@ func (u *User) HasPermission(resource, action string) bool {
 	// Define role-based permissions
 	permissions := map[UserRole]map[string][]string{
 		RoleAdmin: {
-			"servers": {"create", "read", "update", "delete"},
-			"logs":    {"read"},
-			"users":   {"read"},
+			"servers":     {"create", "read", "update", "delete"},
+			"logs":        {"read", "reparse", "retry"},
+			"users":       {"read"},
+			"parse_rules": {"create", "read", "update", "delete"},
 		},
 		RoleViewer: {
 			"servers": {"read"},
@@ -2,6 +2,9 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"time"
+
 	"github.com/noueii/nocs-log-saver/internal/domain/entities"
 )
 
@@ -10,6 +13,13 @@ type LogRepository interface {
 	// Create saves a new raw log
 	Create(ctx context.Context, log *entities.Log) error
 	
+	// CreateBatch saves the lines of a batch as raw logs, each with a parse
+	// job, in one transaction. Lines whose hash was already saved for the
+	// server since dedupSince are skipped, and a batch whose idempotency key
+	// was used before is replaced by the original with Replayed set. It
+	// returns the raw log IDs in line order, empty for skipped lines.
+	CreateBatch(ctx context.Context, batch *entities.IngestBatch, lines []entities.IngestLine, dedupSince time.Time) ([]string, error)
+	
 	// FindByID retrieves a log by its ID
 	FindByID(ctx context.Context, id string) (*entities.Log, error)
 	
@@ -20,9 +30,15 @@ type LogRepository interface {
 	Count(ctx context.Context, serverID string) (int64, error)
 }
 
+// ErrParsedLogExists is returned when a raw log already has an event of the
+// type being stored, as when a parse job is handed out again after its
+// lines were parsed
+var ErrParsedLogExists = errors.New("parsed log already exists")
+
 // ParsedLogRepository defines the interface for parsed log data access
 type ParsedLogRepository interface {
-	// Create saves a new parsed log
+	// Create saves a new parsed log, or returns ErrParsedLogExists when its
+	// raw log already has an event of its type
 	Create(ctx context.Context, parsedLog *entities.ParsedLog) error
 	
 	// FindByID retrieves a parsed log by its ID
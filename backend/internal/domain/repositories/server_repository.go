@@ -0,0 +1,16 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// ServerRepository defines the interface for game server data access
+type ServerRepository interface {
+	// FindByID retrieves a server by its ID
+	FindByID(ctx context.Context, id string) (*entities.Server, error)
+
+	// UpdateLastSeen records that a server sent logs from an address
+	UpdateLastSeen(ctx context.Context, serverID, ipAddress string) error
+}
@@ -27,4 +27,17 @@ type SessionDetector interface {
 	
 	// IsSessionEnd checks if a log indicates a session end
 	IsSessionEnd(parsedLog *entities.ParsedLog) bool
-}
\ No newline at end of file
+}
+
+// ParseQueue parses saved log lines in the background, in the order each
+// server sent them
+type ParseQueue interface {
+	// CanAccept reports whether a server's queue has room for n more lines
+	CanAccept(serverID string, n int) bool
+	
+	// Closed reports whether the queue has been shut down
+	Closed() bool
+	
+	// Notify signals that new lines were saved for parsing
+	Notify()
+}
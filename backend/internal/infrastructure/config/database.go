@@ -138,15 +138,220 @@ func RunMigrations(db *sqlx.DB) error {
 			created_by VARCHAR(100)
 		)`,
 		
+		// One row per ingestion request so senders can confirm processing
+		`CREATE TABLE IF NOT EXISTS ingest_batches (
+			id UUID PRIMARY KEY,
+			server_id VARCHAR(50) REFERENCES servers(id),
+			saved_count INTEGER NOT NULL DEFAULT 0,
+			parsed_count INTEGER NOT NULL DEFAULT 0,
+			failed_count INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT NOW(),
+			completed_at TIMESTAMP
+		)`,
+		
+		// Completed batches are purged after INGEST_BATCH_RETENTION; raw logs
+		// and parse jobs keep the ID of the request they came in, so batch_id
+		// does not reference ingest_batches
+		`ALTER TABLE raw_logs ADD COLUMN IF NOT EXISTS batch_id UUID`,
+		
+		// Retried requests are detected by idempotency key or by line hash
+		`ALTER TABLE ingest_batches ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255)`,
+		`ALTER TABLE ingest_batches ADD COLUMN IF NOT EXISTS duplicate_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE raw_logs ADD COLUMN IF NOT EXISTS content_hash BYTEA`,
+		
+		// Durable parse queue; a job is written with its raw log so
+		// acknowledged lines survive restarts until they are parsed
+		`CREATE TABLE IF NOT EXISTS parse_jobs (
+			id BIGSERIAL PRIMARY KEY,
+			raw_log_id UUID NOT NULL REFERENCES raw_logs(id) ON DELETE CASCADE,
+			server_id VARCHAR(50) REFERENCES servers(id),
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			locked_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT NOW(),
+			completed_at TIMESTAMP
+		)`,
+		
+		`ALTER TABLE parse_jobs ADD COLUMN IF NOT EXISTS batch_id UUID`,
+		
+		// sv_logsecret used to match UDP log packets to a server
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS log_secret VARCHAR(255)`,
+		
+		// Per-server ingestion rate limit overrides
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS rate_limit_per_minute INTEGER`,
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS rate_limit_burst INTEGER`,
+		
+		// API key enforcement and rotation; the previous key keeps working
+		// until previous_api_key_expires_at
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS require_api_key BOOLEAN NOT NULL DEFAULT false`,
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS previous_api_key VARCHAR(255)`,
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS previous_api_key_expires_at TIMESTAMP`,
+		
+		// HMAC key for signed ingestion requests
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(255)`,
+		
+		// In-game event time; the server timezone says how to read the log clock
+		`ALTER TABLE servers ADD COLUMN IF NOT EXISTS timezone VARCHAR(64)`,
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS event_time TIMESTAMPTZ(3)`,
+		
+		// Round and game phase each event happened in, and one row per
+		// finished round
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS round_number INTEGER`,
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS game_phase VARCHAR(20)`,
+		`CREATE TABLE IF NOT EXISTS rounds (
+			id BIGSERIAL PRIMARY KEY,
+			server_id VARCHAR(50) REFERENCES servers(id),
+			session_id VARCHAR(100) NOT NULL REFERENCES game_sessions(id),
+			round_number INTEGER NOT NULL,
+			game_phase VARCHAR(20),
+			winner VARCHAR(20),
+			win_reason VARCHAR(50),
+			started_at TIMESTAMPTZ(3),
+			ended_at TIMESTAMPTZ(3),
+			duration_ms INTEGER,
+			score_ct INTEGER NOT NULL DEFAULT 0,
+			score_t INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		
+		// Players by SteamID64, their names and servers, and the events
+		// each player took part in
+		`CREATE TABLE IF NOT EXISTS players (
+			steam_id64 VARCHAR(20) PRIMARY KEY,
+			steam_id3 VARCHAR(32) NOT NULL,
+			account_id BIGINT NOT NULL,
+			name VARCHAR(255),
+			first_seen_at TIMESTAMPTZ(3) NOT NULL,
+			last_seen_at TIMESTAMPTZ(3) NOT NULL,
+			created_at TIMESTAMP DEFAULT NOW()
+		)`,
+		`CREATE TABLE IF NOT EXISTS player_names (
+			steam_id64 VARCHAR(20) NOT NULL REFERENCES players(steam_id64) ON DELETE CASCADE,
+			name VARCHAR(255) NOT NULL,
+			first_seen_at TIMESTAMPTZ(3) NOT NULL,
+			last_seen_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY (steam_id64, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS player_servers (
+			steam_id64 VARCHAR(20) NOT NULL REFERENCES players(steam_id64) ON DELETE CASCADE,
+			server_id VARCHAR(50) NOT NULL REFERENCES servers(id) ON DELETE CASCADE,
+			first_seen_at TIMESTAMPTZ(3) NOT NULL,
+			last_seen_at TIMESTAMPTZ(3) NOT NULL,
+			PRIMARY KEY (steam_id64, server_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS parsed_log_players (
+			parsed_log_id UUID NOT NULL REFERENCES parsed_logs(id) ON DELETE CASCADE,
+			steam_id64 VARCHAR(20) NOT NULL REFERENCES players(steam_id64) ON DELETE CASCADE,
+			PRIMARY KEY (parsed_log_id, steam_id64)
+		)`,
+		// Each player's score in a finished session, kept so player
+		// profiles need not rebuild every scoreboard
+		`CREATE TABLE IF NOT EXISTS session_player_stats (
+			session_id VARCHAR(100) NOT NULL REFERENCES game_sessions(id) ON DELETE CASCADE,
+			steam_id64 VARCHAR(20) NOT NULL,
+			rounds INTEGER NOT NULL,
+			score JSONB NOT NULL,
+			weapons JSONB NOT NULL,
+			created_at TIMESTAMP DEFAULT NOW(),
+			PRIMARY KEY (session_id, steam_id64)
+		)`,
+		
+		// Version of the parser that produced each parsed or failed row, and
+		// the reparse jobs that bring old rows up to date
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS parser_version VARCHAR(100)`,
+		`ALTER TABLE failed_parses ADD COLUMN IF NOT EXISTS parser_version VARCHAR(100)`,
+		`CREATE TABLE IF NOT EXISTS reparse_jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			server_id VARCHAR(50) REFERENCES servers(id) ON DELETE CASCADE,
+			received_from TIMESTAMP,
+			received_to TIMESTAMP,
+			stale_only BOOLEAN NOT NULL DEFAULT false,
+			chunk_size INTEGER NOT NULL,
+			parser_version VARCHAR(100) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			cursor_received_at TIMESTAMP,
+			cursor_raw_log_id UUID,
+			processed_count BIGINT NOT NULL DEFAULT 0,
+			parsed_count BIGINT NOT NULL DEFAULT 0,
+			failed_count BIGINT NOT NULL DEFAULT 0,
+			skipped_count BIGINT NOT NULL DEFAULT 0,
+			changed_count BIGINT NOT NULL DEFAULT 0,
+			event_type_changes JSONB NOT NULL DEFAULT '[]',
+			error_message TEXT,
+			created_by VARCHAR(255),
+			created_at TIMESTAMP DEFAULT NOW(),
+			updated_at TIMESTAMP DEFAULT NOW(),
+			completed_at TIMESTAMP
+		)`,
+		
+		// Failed parses whose retry was requested are retried first; a claimed
+		// retry is skipped by other instances until it is recorded
+		`ALTER TABLE failed_parses ADD COLUMN IF NOT EXISTS next_retry_at TIMESTAMP`,
+		`ALTER TABLE failed_parses ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP`,
+		
+		// Whether an event was parsed or guessed, with the guess's confidence
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS classification_source VARCHAR(20) NOT NULL DEFAULT 'parser'`,
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS classification_confidence REAL`,
+		`ALTER TABLE parsed_logs ADD COLUMN IF NOT EXISTS parse_rule VARCHAR(100)`,
+		
+		// A raw log has one event of each type. Parse jobs handed out again
+		// after a crash or an expired lease used to store their events twice;
+		// the extra copies are dropped before the index is built.
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'idx_parsed_logs_raw_log_event') THEN
+				DELETE FROM parsed_logs a USING parsed_logs b
+				WHERE a.raw_log_id = b.raw_log_id AND a.event_type = b.event_type
+					AND (a.created_at, a.id) > (b.created_at, b.id);
+				CREATE UNIQUE INDEX idx_parsed_logs_raw_log_event ON parsed_logs(raw_log_id, event_type);
+			END IF;
+		END
+		$$`,
+		
+		// Custom line rules for events cs2-log does not understand
+		`CREATE TABLE IF NOT EXISTS parse_rules (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			name VARCHAR(100) UNIQUE NOT NULL,
+			description TEXT,
+			pattern TEXT NOT NULL,
+			event_type VARCHAR(100) NOT NULL,
+			stage VARCHAR(10) NOT NULL DEFAULT 'after',
+			priority INTEGER NOT NULL DEFAULT 0,
+			field_types JSONB NOT NULL DEFAULT '{}',
+			enabled BOOLEAN NOT NULL DEFAULT true,
+			created_by VARCHAR(255),
+			created_at TIMESTAMP DEFAULT NOW(),
+			updated_at TIMESTAMP DEFAULT NOW()
+		)`,
+		
 		// Create indexes
 		`CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)`,
 		`CREATE INDEX IF NOT EXISTS idx_users_username ON users(username)`,
 		`CREATE INDEX IF NOT EXISTS idx_servers_api_key ON servers(api_key) WHERE is_active = true`,
 		`CREATE INDEX IF NOT EXISTS idx_servers_active ON servers(is_active)`,
+		`CREATE INDEX IF NOT EXISTS idx_servers_log_secret ON servers(log_secret) WHERE is_active = true`,
+		`CREATE INDEX IF NOT EXISTS idx_servers_ip_address ON servers(ip_address) WHERE is_active = true`,
 		`CREATE INDEX IF NOT EXISTS idx_raw_logs_server_id ON raw_logs(server_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_raw_logs_received_at ON raw_logs(received_at, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_parsed_logs_raw_log_id ON parsed_logs(raw_log_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_failed_parses_raw_log_id ON failed_parses(raw_log_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_failed_parses_unresolved ON failed_parses(created_at) WHERE resolved IS NOT TRUE`,
 		`CREATE INDEX IF NOT EXISTS idx_parsed_logs_session_id ON parsed_logs(session_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_parsed_logs_event_type ON parsed_logs(event_type)`,
+		`CREATE INDEX IF NOT EXISTS idx_parsed_logs_server_event_time ON parsed_logs(server_id, event_time)`,
 		`CREATE INDEX IF NOT EXISTS idx_game_sessions_server_id ON game_sessions(server_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_parsed_logs_session_round ON parsed_logs(session_id, round_number)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_rounds_session_round ON rounds(session_id, round_number)`,
+		`CREATE INDEX IF NOT EXISTS idx_rounds_server_started_at ON rounds(server_id, started_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_parsed_log_players_steam_id64 ON parsed_log_players(steam_id64)`,
+		`CREATE INDEX IF NOT EXISTS idx_players_last_seen_at ON players(last_seen_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_parse_jobs_status ON parse_jobs(status, id) WHERE status <> 'done'`,
+		`CREATE INDEX IF NOT EXISTS idx_parse_jobs_completed_at ON parse_jobs(completed_at) WHERE status = 'done'`,
+		`CREATE INDEX IF NOT EXISTS idx_raw_logs_batch_id ON raw_logs(batch_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_raw_logs_content_hash ON raw_logs(server_id, content_hash) WHERE content_hash IS NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_ingest_batches_idempotency_key ON ingest_batches(server_id, idempotency_key) WHERE idempotency_key IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_ingest_batches_completed_at ON ingest_batches(completed_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_refresh_token ON sessions(refresh_token)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
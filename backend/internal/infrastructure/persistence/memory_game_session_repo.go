@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// MemoryGameSessionRepository stores game sessions in memory
+type MemoryGameSessionRepository struct {
+	mu       sync.Mutex
+	sessions []*entities.GameSession
+}
+
+// NewMemoryGameSessionRepository creates a new in-memory game session repository
+func NewMemoryGameSessionRepository() *MemoryGameSessionRepository {
+	return &MemoryGameSessionRepository{}
+}
+
+// Create creates a new game session
+func (r *MemoryGameSessionRepository) Create(ctx context.Context, session *entities.GameSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions = append(r.sessions, copyGameSession(session))
+	return nil
+}
+
+// Update saves the map, end time, status and metadata of a game session
+func (r *MemoryGameSessionRepository) Update(ctx context.Context, session *entities.GameSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, saved := range r.sessions {
+		if saved.ID == session.ID {
+			updated := copyGameSession(session)
+			updated.ServerID = saved.ServerID
+			updated.StartedAt = saved.StartedAt
+			r.sessions[i] = updated
+			return nil
+		}
+	}
+	return fmt.Errorf("game session not found")
+}
+
+// FindActiveByServer finds the most recently started active session of a server
+func (r *MemoryGameSessionRepository) FindActiveByServer(ctx context.Context, serverID string) (*entities.GameSession, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var active *entities.GameSession
+	for _, session := range r.sessions {
+		if session.ServerID != serverID || session.Status != entities.SessionStatusActive {
+			continue
+		}
+		if active == nil || session.StartedAt.After(active.StartedAt) {
+			active = session
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("game session not found")
+	}
+	return copyGameSession(active), nil
+}
+
+// List lists every game session in the order they were created
+func (r *MemoryGameSessionRepository) List() []*entities.GameSession {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sessions := make([]*entities.GameSession, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, copyGameSession(session))
+	}
+	return sessions
+}
+
+// copyGameSession copies a session so later changes to the metadata of
+// either copy do not affect the other
+func copyGameSession(session *entities.GameSession) *entities.GameSession {
+	copied := *session
+	if session.Metadata != nil {
+		copied.Metadata = make(map[string]interface{}, len(session.Metadata))
+		for k, v := range session.Metadata {
+			copied.Metadata[k] = v
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,377 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// MemoryLogRepository implements LogRepository and the durable parse queue
+// in memory, for tests and running the pipeline without a database. It
+// follows the semantics of PostgresLogRepository and
+// PostgresParseJobRepository.
+type MemoryLogRepository struct {
+	mu        sync.Mutex
+	logs      []*entities.Log
+	byID      map[string]*entities.Log
+	batches   []*entities.IngestBatch
+	hashes    []savedLineHash
+	jobs      []*memoryParseJob // in queue order
+	jobsByID  map[int64]*memoryParseJob
+	nextJobID int64
+}
+
+// savedLineHash is the hash of a saved line, used to detect resent lines
+type savedLineHash struct {
+	serverID string
+	hash     string
+	savedAt  time.Time
+}
+
+// memoryParseJob is a parse job with the batch it counts against
+type memoryParseJob struct {
+	job         entities.ParseJob
+	batch       *entities.IngestBatch
+	lockedAt    time.Time
+	completedAt time.Time
+}
+
+// NewMemoryLogRepository creates a new in-memory raw log repository
+func NewMemoryLogRepository() *MemoryLogRepository {
+	return &MemoryLogRepository{
+		byID:     make(map[string]*entities.Log),
+		jobsByID: make(map[int64]*memoryParseJob),
+	}
+}
+
+// Create saves a new raw log. It is not queued for parsing.
+func (r *MemoryLogRepository) Create(ctx context.Context, log *entities.Log) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if log.ID == "" {
+		log.ID = uuid.New().String()
+	}
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+	r.saveLog(log)
+	return nil
+}
+
+// CreateBatch saves the lines of a batch with their parse jobs
+func (r *MemoryLogRepository) CreateBatch(ctx context.Context, batch *entities.IngestBatch, lines []entities.IngestLine, dedupSince time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if batch.IdempotencyKey != nil {
+		for _, existing := range r.batches {
+			if existing.ServerID == batch.ServerID && existing.IdempotencyKey != nil &&
+				*existing.IdempotencyKey == *batch.IdempotencyKey {
+				*batch = *existing
+				batch.Replayed = true
+				return make([]string, len(lines)), nil
+			}
+		}
+	}
+
+	seen := make(map[string]int)
+	for _, saved := range r.hashes {
+		if saved.serverID == batch.ServerID && !saved.savedAt.Before(dedupSince) {
+			seen[saved.hash]++
+		}
+	}
+
+	now := time.Now()
+	stored := *batch
+	stored.SavedCount = 0
+	stored.DuplicateCount = 0
+	stored.CreatedAt = now
+
+	ids := make([]string, len(lines))
+	for i, line := range lines {
+		if line.Hash != nil && seen[string(line.Hash)] > 0 {
+			seen[string(line.Hash)]--
+			stored.DuplicateCount++
+			continue
+		}
+		ids[i] = uuid.New().String()
+		stored.SavedCount++
+	}
+	if stored.SavedCount == 0 {
+		stored.CompletedAt = &now
+	}
+	if !stored.NoReceipt {
+		r.batches = append(r.batches, &stored)
+	}
+
+	for i, line := range lines {
+		if ids[i] == "" {
+			continue
+		}
+		r.saveLog(&entities.Log{ID: ids[i], ServerID: batch.ServerID, Content: line.Content, CreatedAt: now})
+		if line.Hash != nil {
+			r.hashes = append(r.hashes, savedLineHash{serverID: batch.ServerID, hash: string(line.Hash), savedAt: now})
+		}
+		r.nextJobID++
+		queued := &memoryParseJob{
+			job: entities.ParseJob{
+				ID:        r.nextJobID,
+				RawLogID:  ids[i],
+				ServerID:  batch.ServerID,
+				Content:   line.Content,
+				Status:    entities.ParseJobPending,
+				CreatedAt: now,
+			},
+			batch: &stored,
+		}
+		r.jobs = append(r.jobs, queued)
+		r.jobsByID[queued.job.ID] = queued
+	}
+
+	*batch = stored
+	return ids, nil
+}
+
+// saveLog stores a copy of a raw log; the caller holds the lock
+func (r *MemoryLogRepository) saveLog(log *entities.Log) {
+	saved := *log
+	r.logs = append(r.logs, &saved)
+	r.byID[saved.ID] = &saved
+}
+
+// FindByID retrieves a raw log by its ID
+func (r *MemoryLogRepository) FindByID(ctx context.Context, id string) (*entities.Log, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log, ok := r.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("log not found")
+	}
+	found := *log
+	return &found, nil
+}
+
+// FindByServerID retrieves the raw logs of a server, newest first
+func (r *MemoryLogRepository) FindByServerID(ctx context.Context, serverID string, limit int, offset int) ([]*entities.Log, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logs := []*entities.Log{}
+	for i := len(r.logs) - 1; i >= 0; i-- {
+		if r.logs[i].ServerID != serverID {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(logs) == limit {
+			break
+		}
+		found := *r.logs[i]
+		logs = append(logs, &found)
+	}
+	return logs, nil
+}
+
+// Count returns the number of raw logs of a server
+func (r *MemoryLogRepository) Count(ctx context.Context, serverID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, log := range r.logs {
+		if log.ServerID == serverID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// FindBatch finds a batch by ID, with the lines parsed and failed so far
+func (r *MemoryLogRepository) FindBatch(ctx context.Context, id string) (*entities.IngestBatch, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, batch := range r.batches {
+		if batch.ID == id {
+			found := *batch
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("batch not found")
+}
+
+// PurgeBatches deletes batches that completed before the given time
+func (r *MemoryLogRepository) PurgeBatches(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	batches := r.batches[:0]
+	for _, batch := range r.batches {
+		if batch.CompletedAt != nil && batch.CompletedAt.Before(before) {
+			purged++
+			continue
+		}
+		batches = append(batches, batch)
+	}
+	r.batches = batches
+	return purged, nil
+}
+
+// Claim marks up to limit pending jobs of servers not in skipServers as
+// processing and returns them in queue order
+func (r *MemoryLogRepository) Claim(ctx context.Context, limit int, skipServers []string) ([]*entities.ParseJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	skip := make(map[string]bool, len(skipServers))
+	for _, serverID := range skipServers {
+		skip[serverID] = true
+	}
+
+	var jobs []*entities.ParseJob
+	for _, queued := range r.jobs {
+		if len(jobs) == limit {
+			break
+		}
+		if queued.job.Status != entities.ParseJobPending || skip[queued.job.ServerID] {
+			continue
+		}
+		queued.job.Status = entities.ParseJobProcessing
+		queued.job.Attempts++
+		queued.lockedAt = time.Now()
+		claimed := queued.job
+		jobs = append(jobs, &claimed)
+	}
+	return jobs, nil
+}
+
+// Complete marks jobs as done and counts them against their batches; the
+// lines of unparseable jobs were stored as failed parses
+func (r *MemoryLogRepository) Complete(ctx context.Context, parsed, unparseable []int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range parsed {
+		if queued := r.processingJob(id); queued != nil {
+			queued.job.Status = entities.ParseJobDone
+			queued.completedAt = now
+			queued.batch.ParsedCount++
+			closeBatch(queued.batch, now)
+		}
+	}
+	for _, id := range unparseable {
+		if queued := r.processingJob(id); queued != nil {
+			queued.job.Status = entities.ParseJobDone
+			queued.completedAt = now
+			queued.batch.FailedCount++
+			closeBatch(queued.batch, now)
+		}
+	}
+	return nil
+}
+
+// Fail gives up on a job: it is marked failed and counts as failed in its
+// batch
+func (r *MemoryLogRepository) Fail(ctx context.Context, id int64, errorMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queued := r.processingJob(id)
+	if queued == nil {
+		return nil
+	}
+	queued.job.Status = entities.ParseJobFailed
+	queued.batch.FailedCount++
+	closeBatch(queued.batch, time.Now())
+	return nil
+}
+
+// RequeueInFlight returns jobs claimed before lockedBefore that were never
+// finished to the pending state
+func (r *MemoryLogRepository) RequeueInFlight(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var requeued int64
+	for _, queued := range r.jobs {
+		if queued.job.Status == entities.ParseJobProcessing && queued.lockedAt.Before(lockedBefore) {
+			queued.job.Status = entities.ParseJobPending
+			requeued++
+		}
+	}
+	return requeued, nil
+}
+
+// PurgeCompleted deletes done jobs that finished before the given time
+func (r *MemoryLogRepository) PurgeCompleted(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	jobs := r.jobs[:0]
+	for _, queued := range r.jobs {
+		if queued.job.Status == entities.ParseJobDone && queued.completedAt.Before(before) {
+			delete(r.jobsByID, queued.job.ID)
+			purged++
+			continue
+		}
+		jobs = append(jobs, queued)
+	}
+	r.jobs = jobs
+	return purged, nil
+}
+
+// PendingByServer counts the jobs waiting to be claimed per server
+func (r *MemoryLogRepository) PendingByServer(ctx context.Context) (map[string]int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := make(map[string]int)
+	for _, queued := range r.jobs {
+		if queued.job.Status == entities.ParseJobPending {
+			pending[queued.job.ServerID]++
+		}
+	}
+	return pending, nil
+}
+
+// UnfinishedJobs returns the number of jobs waiting to be parsed or being
+// parsed, so callers can wait for the queue to drain
+func (r *MemoryLogRepository) UnfinishedJobs() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unfinished := 0
+	for _, queued := range r.jobs {
+		if queued.job.Status == entities.ParseJobPending || queued.job.Status == entities.ParseJobProcessing {
+			unfinished++
+		}
+	}
+	return unfinished
+}
+
+// processingJob finds a claimed job; the caller holds the lock
+func (r *MemoryLogRepository) processingJob(id int64) *memoryParseJob {
+	queued, ok := r.jobsByID[id]
+	if !ok || queued.job.Status != entities.ParseJobProcessing {
+		return nil
+	}
+	return queued
+}
+
+// closeBatch marks a batch complete once every saved line was processed
+func closeBatch(batch *entities.IngestBatch, at time.Time) {
+	if batch.CompletedAt == nil && batch.IsComplete() {
+		batch.CompletedAt = &at
+	}
+}
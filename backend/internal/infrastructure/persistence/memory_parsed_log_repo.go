@@ -0,0 +1,201 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
+)
+
+// MemoryParsedLogRepository implements ParsedLogRepository in memory
+type MemoryParsedLogRepository struct {
+	mu         sync.Mutex
+	parsedLogs []*entities.ParsedLog
+}
+
+// NewMemoryParsedLogRepository creates a new in-memory parsed log repository
+func NewMemoryParsedLogRepository() *MemoryParsedLogRepository {
+	return &MemoryParsedLogRepository{}
+}
+
+// Create saves a new parsed log and sets its ID when it has none, or returns
+// repositories.ErrParsedLogExists when its raw log has an event of its type
+func (r *MemoryParsedLogRepository) Create(ctx context.Context, parsedLog *entities.ParsedLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if parsedLog.RawLogID != "" {
+		for _, saved := range r.parsedLogs {
+			if saved.RawLogID == parsedLog.RawLogID && saved.EventType == parsedLog.EventType {
+				return repositories.ErrParsedLogExists
+			}
+		}
+	}
+	if parsedLog.ID == "" {
+		parsedLog.ID = uuid.New().String()
+	}
+	saved := *parsedLog
+	if saved.ClassificationSource == "" {
+		saved.ClassificationSource = entities.ClassificationParser
+	}
+	r.parsedLogs = append(r.parsedLogs, &saved)
+	return nil
+}
+
+// FindByID retrieves a parsed log by its ID
+func (r *MemoryParsedLogRepository) FindByID(ctx context.Context, id string) (*entities.ParsedLog, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, parsedLog := range r.parsedLogs {
+		if parsedLog.ID == id {
+			found := *parsedLog
+			return &found, nil
+		}
+	}
+	return nil, fmt.Errorf("parsed log not found")
+}
+
+// FindBySessionID retrieves the parsed logs of a game session in log order
+func (r *MemoryParsedLogRepository) FindBySessionID(ctx context.Context, sessionID string, limit int, offset int) ([]*entities.ParsedLog, error) {
+	parsedLogs := r.filter(func(parsedLog *entities.ParsedLog) bool { return parsedLog.SessionID == sessionID })
+	sort.SliceStable(parsedLogs, func(i, j int) bool {
+		a, b := parsedLogs[i], parsedLogs[j]
+		if a.EventTime == nil || b.EventTime == nil {
+			return a.EventTime != nil && b.EventTime == nil
+		}
+		return a.EventTime.Before(*b.EventTime)
+	})
+	return pageParsedLogs(parsedLogs, limit, offset), nil
+}
+
+// FindByEventType retrieves parsed logs of an event type, newest first
+func (r *MemoryParsedLogRepository) FindByEventType(ctx context.Context, eventType string, limit int, offset int) ([]*entities.ParsedLog, error) {
+	parsedLogs := r.filter(func(parsedLog *entities.ParsedLog) bool { return parsedLog.EventType == eventType })
+	for i, j := 0, len(parsedLogs)-1; i < j; i, j = i+1, j-1 {
+		parsedLogs[i], parsedLogs[j] = parsedLogs[j], parsedLogs[i]
+	}
+	return pageParsedLogs(parsedLogs, limit, offset), nil
+}
+
+// List lists every parsed log in the order they were stored
+func (r *MemoryParsedLogRepository) List() []*entities.ParsedLog {
+	return r.filter(func(*entities.ParsedLog) bool { return true })
+}
+
+// filter returns copies of the parsed logs that match, in the order they
+// were stored
+func (r *MemoryParsedLogRepository) filter(match func(*entities.ParsedLog) bool) []*entities.ParsedLog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parsedLogs := []*entities.ParsedLog{}
+	for _, parsedLog := range r.parsedLogs {
+		if match(parsedLog) {
+			found := *parsedLog
+			parsedLogs = append(parsedLogs, &found)
+		}
+	}
+	return parsedLogs
+}
+
+// MemoryFailedParseRepository implements FailedParseRepository in memory
+type MemoryFailedParseRepository struct {
+	mu           sync.Mutex
+	failedParses []*entities.FailedParse
+}
+
+// NewMemoryFailedParseRepository creates a new in-memory failed parse repository
+func NewMemoryFailedParseRepository() *MemoryFailedParseRepository {
+	return &MemoryFailedParseRepository{}
+}
+
+// Create saves a new failed parse record
+func (r *MemoryFailedParseRepository) Create(ctx context.Context, failedParse *entities.FailedParse) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := *failedParse
+	r.failedParses = append(r.failedParses, &saved)
+	return nil
+}
+
+// FindUnresolved retrieves unresolved failed parses, oldest first
+func (r *MemoryFailedParseRepository) FindUnresolved(ctx context.Context, limit int) ([]*entities.FailedParse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failedParses := []*entities.FailedParse{}
+	for _, failedParse := range r.failedParses {
+		if len(failedParses) == limit {
+			break
+		}
+		if !failedParse.Resolved {
+			found := *failedParse
+			failedParses = append(failedParses, &found)
+		}
+	}
+	return failedParses, nil
+}
+
+// MarkResolved marks a failed parse as resolved
+func (r *MemoryFailedParseRepository) MarkResolved(ctx context.Context, id string) error {
+	return r.update(id, func(failedParse *entities.FailedParse) {
+		failedParse.Resolved = true
+		failedParse.NextRetryAt = nil
+	})
+}
+
+// IncrementRetryCount increments the retry count for a failed parse
+func (r *MemoryFailedParseRepository) IncrementRetryCount(ctx context.Context, id string) error {
+	return r.update(id, func(failedParse *entities.FailedParse) {
+		now := time.Now()
+		failedParse.RetryCount++
+		failedParse.LastRetry = &now
+		failedParse.NextRetryAt = nil
+	})
+}
+
+// List lists every failed parse in the order they were stored
+func (r *MemoryFailedParseRepository) List() []*entities.FailedParse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failedParses := make([]*entities.FailedParse, 0, len(r.failedParses))
+	for _, failedParse := range r.failedParses {
+		found := *failedParse
+		failedParses = append(failedParses, &found)
+	}
+	return failedParses
+}
+
+func (r *MemoryFailedParseRepository) update(id string, apply func(*entities.FailedParse)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, failedParse := range r.failedParses {
+		if failedParse.ID == id {
+			apply(failedParse)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed parse not found")
+}
+
+// pageParsedLogs returns a page of parsed logs; a limit of 0 or less
+// returns the rest
+func pageParsedLogs(parsedLogs []*entities.ParsedLog, limit, offset int) []*entities.ParsedLog {
+	if offset >= len(parsedLogs) {
+		return parsedLogs[:0]
+	}
+	parsedLogs = parsedLogs[offset:]
+	if limit > 0 && limit < len(parsedLogs) {
+		parsedLogs = parsedLogs[:limit]
+	}
+	return parsedLogs
+}
@@ -0,0 +1,130 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// MemoryPlayerRepository stores the players seen in the logs in memory
+type MemoryPlayerRepository struct {
+	mu      sync.Mutex
+	players map[string]*entities.Player
+	events  map[string][]string // parsed log ID -> SteamID64s
+}
+
+// NewMemoryPlayerRepository creates a new in-memory player repository
+func NewMemoryPlayerRepository() *MemoryPlayerRepository {
+	return &MemoryPlayerRepository{
+		players: make(map[string]*entities.Player),
+		events:  make(map[string][]string),
+	}
+}
+
+// RecordSightings records that players were seen in a parsed event of a
+// server at the given time, updating their names and servers
+func (r *MemoryPlayerRepository) RecordSightings(ctx context.Context, parsedLogID, serverID string, players []*entities.Player, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, seen := range players {
+		player, ok := r.players[seen.SteamID64]
+		if !ok {
+			player = &entities.Player{
+				SteamID64:   seen.SteamID64,
+				SteamID3:    seen.SteamID3,
+				AccountID:   seen.AccountID,
+				Name:        seen.Name,
+				FirstSeenAt: at,
+				LastSeenAt:  at,
+				CreatedAt:   time.Now(),
+			}
+			r.players[seen.SteamID64] = player
+		}
+
+		// Lines can be parsed out of order; the name of the latest sighting wins
+		if !at.Before(player.LastSeenAt) {
+			player.Name = seen.Name
+			player.LastSeenAt = at
+		}
+		if at.Before(player.FirstSeenAt) {
+			player.FirstSeenAt = at
+		}
+		recordPlayerName(player, seen.Name, at)
+		recordPlayerServer(player, serverID, at)
+
+		r.events[parsedLogID] = append(r.events[parsedLogID], seen.SteamID64)
+	}
+	return nil
+}
+
+// FindBySteamID64 finds a player with their name history, most recent first,
+// and the servers they were seen on
+func (r *MemoryPlayerRepository) FindBySteamID64(ctx context.Context, steamID64 string) (*entities.Player, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	player, ok := r.players[steamID64]
+	if !ok {
+		return nil, fmt.Errorf("player not found")
+	}
+	return copyPlayer(player), nil
+}
+
+// List lists every player by SteamID64
+func (r *MemoryPlayerRepository) List() []*entities.Player {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	players := make([]*entities.Player, 0, len(r.players))
+	for _, player := range r.players {
+		players = append(players, copyPlayer(player))
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].SteamID64 < players[j].SteamID64 })
+	return players
+}
+
+func recordPlayerName(player *entities.Player, name string, at time.Time) {
+	for i := range player.Names {
+		if player.Names[i].Name == name {
+			if at.Before(player.Names[i].FirstSeenAt) {
+				player.Names[i].FirstSeenAt = at
+			}
+			if at.After(player.Names[i].LastSeenAt) {
+				player.Names[i].LastSeenAt = at
+			}
+			return
+		}
+	}
+	player.Names = append(player.Names, entities.PlayerName{Name: name, FirstSeenAt: at, LastSeenAt: at})
+}
+
+func recordPlayerServer(player *entities.Player, serverID string, at time.Time) {
+	for i := range player.Servers {
+		if player.Servers[i].ServerID == serverID {
+			if at.Before(player.Servers[i].FirstSeenAt) {
+				player.Servers[i].FirstSeenAt = at
+			}
+			if at.After(player.Servers[i].LastSeenAt) {
+				player.Servers[i].LastSeenAt = at
+			}
+			return
+		}
+	}
+	player.Servers = append(player.Servers, entities.PlayerServer{ServerID: serverID, FirstSeenAt: at, LastSeenAt: at})
+}
+
+// copyPlayer copies a player with their names, most recent first, and servers
+func copyPlayer(player *entities.Player) *entities.Player {
+	copied := *player
+	copied.Names = append([]entities.PlayerName{}, player.Names...)
+	sort.SliceStable(copied.Names, func(i, j int) bool {
+		return copied.Names[i].LastSeenAt.After(copied.Names[j].LastSeenAt)
+	})
+	copied.Servers = append([]entities.PlayerServer{}, player.Servers...)
+	return &copied
+}
@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// MemoryRoundRepository stores the finished rounds of each session in memory
+type MemoryRoundRepository struct {
+	mu     sync.Mutex
+	rounds []*entities.Round
+	nextID int64
+}
+
+// NewMemoryRoundRepository creates a new in-memory round repository
+func NewMemoryRoundRepository() *MemoryRoundRepository {
+	return &MemoryRoundRepository{}
+}
+
+// Save stores a finished round. A round replayed after a backup restore
+// replaces the earlier round of the same session and round number.
+func (r *MemoryRoundRepository) Save(ctx context.Context, round *entities.Round) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, saved := range r.rounds {
+		if sameSession(saved.SessionID, round.SessionID) && saved.RoundNumber == round.RoundNumber {
+			round.ID = saved.ID
+			round.CreatedAt = saved.CreatedAt
+			updated := *round
+			r.rounds[i] = &updated
+			return nil
+		}
+	}
+
+	r.nextID++
+	round.ID = r.nextID
+	round.CreatedAt = time.Now()
+	saved := *round
+	r.rounds = append(r.rounds, &saved)
+	return nil
+}
+
+// FindLatestBySession finds the highest numbered round of a session
+func (r *MemoryRoundRepository) FindLatestBySession(ctx context.Context, sessionID string) (*entities.Round, error) {
+	rounds, _ := r.ListBySession(ctx, sessionID)
+	if len(rounds) == 0 {
+		return nil, fmt.Errorf("round not found")
+	}
+	return rounds[len(rounds)-1], nil
+}
+
+// ListBySession lists the rounds of a session in order
+func (r *MemoryRoundRepository) ListBySession(ctx context.Context, sessionID string) ([]*entities.Round, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rounds := []*entities.Round{}
+	for _, round := range r.rounds {
+		if round.SessionID != nil && *round.SessionID == sessionID {
+			found := *round
+			rounds = append(rounds, &found)
+		}
+	}
+	sort.Slice(rounds, func(i, j int) bool { return rounds[i].RoundNumber < rounds[j].RoundNumber })
+	return rounds, nil
+}
+
+// sameSession reports whether two rounds belong to the same session. Rounds
+// without a session never conflict, as NULLs do not in a unique index.
+func sameSession(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
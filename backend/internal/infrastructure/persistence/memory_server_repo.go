@@ -0,0 +1,57 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// MemoryServerRepository stores servers in memory
+type MemoryServerRepository struct {
+	mu      sync.Mutex
+	servers map[string]*entities.Server
+}
+
+// NewMemoryServerRepository creates a new in-memory server repository
+func NewMemoryServerRepository() *MemoryServerRepository {
+	return &MemoryServerRepository{servers: make(map[string]*entities.Server)}
+}
+
+// Create creates a new server
+func (r *MemoryServerRepository) Create(ctx context.Context, server *entities.Server) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	saved := *server
+	r.servers[saved.ID] = &saved
+	return nil
+}
+
+// FindByID finds a server by ID
+func (r *MemoryServerRepository) FindByID(ctx context.Context, id string) (*entities.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	server, ok := r.servers[id]
+	if !ok {
+		return nil, fmt.Errorf("server not found")
+	}
+	found := *server
+	return &found, nil
+}
+
+// UpdateLastSeen updates the last seen timestamp
+func (r *MemoryServerRepository) UpdateLastSeen(ctx context.Context, serverID, ipAddress string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if server, ok := r.servers[serverID]; ok {
+		now := time.Now()
+		server.LastSeen = &now
+		server.IPAddress = ipAddress
+	}
+	return nil
+}
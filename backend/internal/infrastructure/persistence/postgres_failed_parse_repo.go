@@ -0,0 +1,229 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresFailedParseRepository implements FailedParseRepository and the
+// retry queue of failed parses using PostgreSQL
+type PostgresFailedParseRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresFailedParseRepository creates a new PostgreSQL failed parse repository
+func NewPostgresFailedParseRepository(db *sqlx.DB) *PostgresFailedParseRepository {
+	return &PostgresFailedParseRepository{db: db}
+}
+
+// failedParseColumns selects a failed parse joined to its raw log as f and r
+const failedParseColumns = `
+	f.id, f.raw_log_id, COALESCE(f.error_message, '') AS error_message,
+	COALESCE(f.retry_count, 0) AS retry_count, f.last_retry, f.next_retry_at,
+	COALESCE(f.resolved, false) AS resolved, COALESCE(f.parser_version, '') AS parser_version,
+	f.created_at, r.server_id, r.content
+`
+
+// retryDueAt is when a failed parse is next retried: when a retry was
+// requested or, otherwise, the backoff after its last attempt. $1 is the
+// base delay and $2 the maximum delay, in seconds.
+const retryDueAt = `COALESCE(f.next_retry_at,
+	COALESCE(f.last_retry, f.created_at) + LEAST($1 * POWER(2, COALESCE(f.retry_count, 0)), $2) * INTERVAL '1 second')`
+
+// retryEligible selects the failed parses that are retried: unresolved ones
+// with retries left, requested ones, and ones that failed under another
+// parser version. $3 is the maximum retries and $4 the parser version.
+const retryEligible = `f.resolved IS NOT TRUE AND (f.next_retry_at IS NOT NULL
+	OR COALESCE(f.retry_count, 0) < $3 OR f.parser_version IS DISTINCT FROM $4)`
+
+// Create saves a new failed parse record
+func (r *PostgresFailedParseRepository) Create(ctx context.Context, failedParse *entities.FailedParse) error {
+	query := `
+		INSERT INTO failed_parses (id, raw_log_id, error_message, retry_count, resolved, parser_version, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+	`
+	_, err := r.db.ExecContext(ctx, query,
+		failedParse.ID, failedParse.RawLogID, failedParse.ErrorMessage, failedParse.RetryCount,
+		failedParse.Resolved, failedParse.ParserVersion, failedParse.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed parse: %w", err)
+	}
+	return nil
+}
+
+// FindUnresolved retrieves unresolved failed parses, oldest first
+func (r *PostgresFailedParseRepository) FindUnresolved(ctx context.Context, limit int) ([]*entities.FailedParse, error) {
+	query := `SELECT ` + failedParseColumns + `
+		FROM failed_parses f
+		JOIN raw_logs r ON r.id = f.raw_log_id
+		WHERE f.resolved IS NOT TRUE
+		ORDER BY f.created_at, f.id
+		LIMIT $1
+	`
+	var failed []*entities.FailedParse
+	if err := r.db.SelectContext(ctx, &failed, query, limit); err != nil {
+		return nil, fmt.Errorf("query unresolved failed parses: %w", err)
+	}
+	return failed, nil
+}
+
+// MarkResolved marks a failed parse as resolved
+func (r *PostgresFailedParseRepository) MarkResolved(ctx context.Context, id string) error {
+	query := `UPDATE failed_parses SET resolved = true, next_retry_at = NULL WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("resolve failed parse: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed parse not found")
+	}
+	return nil
+}
+
+// IncrementRetryCount increments the retry count for a failed parse
+func (r *PostgresFailedParseRepository) IncrementRetryCount(ctx context.Context, id string) error {
+	query := `
+		UPDATE failed_parses
+		SET retry_count = COALESCE(retry_count, 0) + 1, last_retry = NOW(), next_retry_at = NULL
+		WHERE id = $1
+	`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("increment retry count: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("failed parse not found")
+	}
+	return nil
+}
+
+// RecordRetry records a retry that failed again with the error and parser
+// version of that attempt
+func (r *PostgresFailedParseRepository) RecordRetry(ctx context.Context, id, errorMsg, parserVersion string) error {
+	query := `
+		UPDATE failed_parses
+		SET retry_count = COALESCE(retry_count, 0) + 1, last_retry = NOW(), next_retry_at = NULL,
+			claimed_until = NULL, error_message = $2, parser_version = $3
+		WHERE id = $1 AND resolved IS NOT TRUE
+	`
+	if _, err := r.db.ExecContext(ctx, query, id, errorMsg, parserVersion); err != nil {
+		return fmt.Errorf("record retry: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue claims up to limit failed parses whose retry is due, in the order
+// they became due. Claimed rows are skipped by other instances until lease
+// has passed or the retry is recorded, so each retry runs once.
+func (r *PostgresFailedParseRepository) ClaimDue(ctx context.Context, policy entities.RetryPolicy, parserVersion string, limit int, lease time.Duration) ([]*entities.FailedParse, error) {
+	query := `
+		WITH due AS (
+			SELECT f.id
+			FROM failed_parses f
+			WHERE ` + retryEligible + ` AND ` + retryDueAt + ` <= NOW()
+				AND (f.claimed_until IS NULL OR f.claimed_until < NOW())
+			ORDER BY ` + retryDueAt + `, f.id
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		), claimed AS (
+			UPDATE failed_parses f
+			SET claimed_until = NOW() + $6 * INTERVAL '1 second'
+			FROM due
+			WHERE f.id = due.id
+			RETURNING f.*
+		)
+		SELECT ` + failedParseColumns + `
+		FROM claimed f
+		JOIN raw_logs r ON r.id = f.raw_log_id
+		ORDER BY ` + retryDueAt + `, f.id
+	`
+	var failed []*entities.FailedParse
+	err := r.db.SelectContext(ctx, &failed, query,
+		policy.BaseDelay.Seconds(), policy.MaxDelay.Seconds(), policy.MaxRetries, parserVersion, limit,
+		lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("claim due failed parses: %w", err)
+	}
+	return failed, nil
+}
+
+// Resolve stores the event a failed parse was parsed as on retry and marks
+// it resolved. The event is placed in the session and round of the event
+// logged before it on its server.
+func (r *PostgresFailedParseRepository) Resolve(ctx context.Context, failed *entities.FailedParse, line *entities.ReparsedLine, parserVersion string) (*entities.ParsedLog, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin resolve: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the row first so a reparse or another retry cannot replace it
+	result, err := tx.ExecContext(ctx, `
+		UPDATE failed_parses
+		SET resolved = true, retry_count = COALESCE(retry_count, 0) + 1, last_retry = NOW(),
+			next_retry_at = NULL, claimed_until = NULL
+		WHERE id = $1 AND resolved IS NOT TRUE
+	`, failed.ID)
+	if err != nil {
+		return nil, fmt.Errorf("resolve failed parse: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, fmt.Errorf("failed parse not found")
+	}
+
+	eventCtx, err := findEventContext(ctx, tx, line)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := insertParsedLine(ctx, tx, parserVersion, line, eventCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit resolve: %w", err)
+	}
+	return stored, nil
+}
+
+// RequestRetry queues an unresolved failed parse to be retried as soon as
+// possible and returns its 1-based position in the retry queue
+func (r *PostgresFailedParseRepository) RequestRetry(ctx context.Context, id string, policy entities.RetryPolicy, parserVersion string) (int64, error) {
+	var resolved sql.NullBool
+	err := r.db.GetContext(ctx, &resolved, `
+		UPDATE failed_parses
+		SET next_retry_at = CASE WHEN resolved IS TRUE THEN NULL ELSE COALESCE(next_retry_at, NOW()) END
+		WHERE id = $1
+		RETURNING resolved
+	`, id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("failed parse not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("request retry: %w", err)
+	}
+	if resolved.Bool {
+		return 0, fmt.Errorf("failed parse already resolved")
+	}
+
+	var position int64
+	err = r.db.GetContext(ctx, &position, `
+		WITH queue AS (
+			SELECT f.id, `+retryDueAt+` AS due_at
+			FROM failed_parses f
+			WHERE `+retryEligible+`
+		)
+		SELECT COUNT(*) FROM queue q, queue self
+		WHERE self.id = $5 AND (q.due_at, q.id) <= (self.due_at, self.id)
+	`, policy.BaseDelay.Seconds(), policy.MaxDelay.Seconds(), policy.MaxRetries, parserVersion, id)
+	if err != nil {
+		return 0, fmt.Errorf("query retry queue position: %w", err)
+	}
+	return position, nil
+}
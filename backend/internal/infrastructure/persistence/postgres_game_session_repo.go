@@ -0,0 +1,434 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// gameSessionRow is a game_sessions row; metadata is stored as JSONB
+type gameSessionRow struct {
+	ID        string         `db:"id"`
+	ServerID  string         `db:"server_id"`
+	MapName   sql.NullString `db:"map_name"`
+	StartedAt time.Time      `db:"started_at"`
+	EndedAt   *time.Time     `db:"ended_at"`
+	Status    string         `db:"status"`
+	Metadata  []byte         `db:"metadata"`
+
+	ServerName sql.NullString `db:"server_name"`
+	EventCount int64          `db:"event_count"`
+	ScoreCT    *int           `db:"score_ct"`
+	ScoreT     *int           `db:"score_t"`
+}
+
+// GameSessionFilter selects the sessions returned by List. Empty fields do
+// not filter; From and To match sessions that started in [From, To).
+type GameSessionFilter struct {
+	ServerID string
+	MapName  string
+	Status   string
+	From     *time.Time
+	To       *time.Time
+	Limit    int
+	Offset   int
+}
+
+// sessionSummaryColumns selects a session with its server name, event count
+// and latest score; the query must alias game_sessions as s
+const sessionSummaryColumns = `
+	s.id, s.server_id, s.map_name, s.started_at, s.ended_at, s.status, s.metadata,
+	sv.name AS server_name,
+	(SELECT COUNT(*) FROM parsed_logs p WHERE p.session_id = s.id) AS event_count,
+	(score.event_data->>'score_ct')::int AS score_ct,
+	(score.event_data->>'score_t')::int AS score_t
+`
+
+// sessionSummaryJoins adds the tables sessionSummaryColumns reads from
+const sessionSummaryJoins = `
+	LEFT JOIN servers sv ON sv.id = s.server_id
+	LEFT JOIN LATERAL (
+		SELECT p.event_data
+		FROM parsed_logs p
+		WHERE p.session_id = s.id AND p.event_type = 'team_notice'
+		ORDER BY p.event_time DESC NULLS LAST, p.created_at DESC
+		LIMIT 1
+	) score ON true
+`
+
+func (row *gameSessionRow) toEntity() *entities.GameSession {
+	session := &entities.GameSession{
+		ID:        row.ID,
+		ServerID:  row.ServerID,
+		MapName:   row.MapName.String,
+		StartedAt: row.StartedAt,
+		EndedAt:   row.EndedAt,
+		Status:    entities.SessionStatus(row.Status),
+
+		ServerName: row.ServerName.String,
+		EventCount: row.EventCount,
+		ScoreCT:    row.ScoreCT,
+		ScoreT:     row.ScoreT,
+	}
+	if len(row.Metadata) > 0 {
+		json.Unmarshal(row.Metadata, &session.Metadata)
+	}
+	return session
+}
+
+// PostgresGameSessionRepository stores the game sessions found in server logs
+type PostgresGameSessionRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresGameSessionRepository creates a new PostgreSQL game session repository
+func NewPostgresGameSessionRepository(db *sqlx.DB) *PostgresGameSessionRepository {
+	return &PostgresGameSessionRepository{db: db}
+}
+
+// Create creates a new game session
+func (r *PostgresGameSessionRepository) Create(ctx context.Context, session *entities.GameSession) error {
+	metadata, err := json.Marshal(session.Metadata)
+	if err != nil {
+		return fmt.Errorf("marshal session metadata: %w", err)
+	}
+
+	query := `
+		INSERT INTO game_sessions (id, server_id, map_name, started_at, ended_at, status, metadata)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7)
+	`
+	_, err = r.db.ExecContext(ctx, query,
+		session.ID, session.ServerID, session.MapName, session.StartedAt,
+		session.EndedAt, string(session.Status), metadata,
+	)
+	if err != nil {
+		return fmt.Errorf("insert game session: %w", err)
+	}
+	return nil
+}
+
+// Update saves the map, end time, status and metadata of a game session
+func (r *PostgresGameSessionRepository) Update(ctx context.Context, session *entities.GameSession) error {
+	metadata, err := json.Marshal(session.Metadata)
+	if err != nil {
+		return fmt.Errorf("marshal session metadata: %w", err)
+	}
+
+	query := `
+		UPDATE game_sessions
+		SET map_name = NULLIF($2, ''), ended_at = $3, status = $4, metadata = $5
+		WHERE id = $1
+	`
+	result, err := r.db.ExecContext(ctx, query,
+		session.ID, session.MapName, session.EndedAt, string(session.Status), metadata,
+	)
+	if err != nil {
+		return fmt.Errorf("update game session: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("game session not found")
+	}
+	return nil
+}
+
+// FindActiveByServer finds the most recently started active session of a server
+func (r *PostgresGameSessionRepository) FindActiveByServer(ctx context.Context, serverID string) (*entities.GameSession, error) {
+	var row gameSessionRow
+	query := `
+		SELECT id, server_id, map_name, started_at, ended_at, status, metadata
+		FROM game_sessions
+		WHERE server_id = $1 AND status = $2
+		ORDER BY started_at DESC
+		LIMIT 1
+	`
+	err := r.db.GetContext(ctx, &row, query, serverID, string(entities.SessionStatusActive))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("game session not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query active game session: %w", err)
+	}
+	return row.toEntity(), nil
+}
+
+// FindByID finds a game session by ID
+func (r *PostgresGameSessionRepository) FindByID(ctx context.Context, id string) (*entities.GameSession, error) {
+	var row gameSessionRow
+	query := `SELECT ` + sessionSummaryColumns + ` FROM game_sessions s ` + sessionSummaryJoins + ` WHERE s.id = $1`
+	err := r.db.GetContext(ctx, &row, query, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("game session not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query game session: %w", err)
+	}
+	return row.toEntity(), nil
+}
+
+// List lists game sessions matching the filter, most recent first, along
+// with the total number of matching sessions
+func (r *PostgresGameSessionRepository) List(ctx context.Context, filter GameSessionFilter) ([]*entities.GameSession, int64, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.ServerID != "" {
+		addCondition("s.server_id = $%d", filter.ServerID)
+	}
+	if filter.MapName != "" {
+		addCondition("s.map_name = $%d", filter.MapName)
+	}
+	if filter.Status != "" {
+		addCondition("s.status = $%d", filter.Status)
+	}
+	if filter.From != nil {
+		addCondition("s.started_at >= $%d", filter.From.UTC())
+	}
+	if filter.To != nil {
+		addCondition("s.started_at < $%d", filter.To.UTC())
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int64
+	if err := r.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM game_sessions s `+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count game sessions: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM game_sessions s
+		%s
+		%s
+		ORDER BY s.started_at DESC
+		LIMIT $%d OFFSET $%d
+	`, sessionSummaryColumns, sessionSummaryJoins, where, len(args)+1, len(args)+2)
+
+	var rows []gameSessionRow
+	if err := r.db.SelectContext(ctx, &rows, query, append(args, filter.Limit, filter.Offset)...); err != nil {
+		return nil, 0, fmt.Errorf("query game sessions: %w", err)
+	}
+
+	sessions := make([]*entities.GameSession, 0, len(rows))
+	for i := range rows {
+		sessions = append(sessions, rows[i].toEntity())
+	}
+	return sessions, total, nil
+}
+
+// FindLogs lists the parsed events of a session in the order they were
+// logged, along with the total number of events in the session
+func (r *PostgresGameSessionRepository) FindLogs(ctx context.Context, sessionID string, limit, offset int) ([]*entities.ParsedLog, int64, error) {
+	var total int64
+	if err := r.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM parsed_logs WHERE session_id = $1`, sessionID); err != nil {
+		return nil, 0, fmt.Errorf("count session logs: %w", err)
+	}
+
+	query := `
+		SELECT id, raw_log_id, server_id, event_type, event_data, session_id,
+			round_number, game_phase, event_time, created_at
+		FROM parsed_logs
+		WHERE session_id = $1
+		ORDER BY event_time ASC NULLS LAST, created_at ASC
+		LIMIT $2 OFFSET $3
+	`
+	rows, err := r.db.QueryContext(ctx, query, sessionID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query session logs: %w", err)
+	}
+	defer rows.Close()
+
+	logs := []*entities.ParsedLog{}
+	for rows.Next() {
+		var log entities.ParsedLog
+		var rawLogID, eventType sql.NullString
+		var eventData []byte
+		if err := rows.Scan(&log.ID, &rawLogID, &log.ServerID, &eventType, &eventData,
+			&log.SessionID, &log.RoundNumber, &log.GamePhase, &log.EventTime, &log.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("scan session log: %w", err)
+		}
+		log.RawLogID = rawLogID.String
+		log.EventType = eventType.String
+		log.EventData, _ = decodeEventData(eventData)
+		logs = append(logs, &log)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("query session logs: %w", err)
+	}
+	return logs, total, nil
+}
+
+// FindEvents finds the events of the given types in a game session, in the
+// order they were logged. Heuristic guesses are left out, so they never
+// count towards scores.
+func (r *PostgresGameSessionRepository) FindEvents(ctx context.Context, sessionID string, eventTypes []string) ([]*entities.ParsedLog, error) {
+	query := `
+		SELECT id, raw_log_id, server_id, event_type, event_data, session_id,
+			round_number, game_phase, event_time, created_at
+		FROM parsed_logs
+		WHERE session_id = $1 AND event_type = ANY($2) AND classification_source <> 'heuristic'
+		ORDER BY event_time ASC NULLS LAST, created_at ASC
+	`
+	rows, err := r.db.QueryContext(ctx, query, sessionID, pq.Array(eventTypes))
+	if err != nil {
+		return nil, fmt.Errorf("query session events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*entities.ParsedLog{}
+	for rows.Next() {
+		var event entities.ParsedLog
+		var rawLogID, eventType sql.NullString
+		var eventData []byte
+		if err := rows.Scan(&event.ID, &rawLogID, &event.ServerID, &eventType, &eventData,
+			&event.SessionID, &event.RoundNumber, &event.GamePhase, &event.EventTime, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan session event: %w", err)
+		}
+		event.RawLogID = rawLogID.String
+		event.EventType = eventType.String
+		event.EventData, _ = decodeEventData(eventData)
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query session events: %w", err)
+	}
+	return events, nil
+}
+
+// FindByPlayer lists the sessions in which a player took part in a match
+// event of one of the given types, most recent first, along with the total
+// number of such sessions. Heuristic guesses do not count. A limit below 1
+// returns all of them.
+func (r *PostgresGameSessionRepository) FindByPlayer(ctx context.Context, steamID64 string, eventTypes []string, limit, offset int) ([]*entities.GameSession, int64, error) {
+	where := `
+		WHERE s.id IN (
+			SELECT p.session_id
+			FROM parsed_log_players pp
+			JOIN parsed_logs p ON p.id = pp.parsed_log_id
+			WHERE pp.steam_id64 = $1 AND p.event_type = ANY($2) AND p.classification_source <> 'heuristic'
+				AND p.round_number IS NOT NULL AND p.game_phase IS DISTINCT FROM 'warmup'
+		)
+	`
+	args := []interface{}{steamID64, pq.Array(eventTypes)}
+
+	var total int64
+	if err := r.db.GetContext(ctx, &total, `SELECT COUNT(*) FROM game_sessions s `+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count player sessions: %w", err)
+	}
+
+	// LIMIT NULL does not limit
+	var limitArg interface{}
+	if limit > 0 {
+		limitArg = limit
+	}
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM game_sessions s
+		%s
+		%s
+		ORDER BY s.started_at DESC
+		LIMIT $3 OFFSET $4
+	`, sessionSummaryColumns, sessionSummaryJoins, where)
+
+	var rows []gameSessionRow
+	if err := r.db.SelectContext(ctx, &rows, query, append(args, limitArg, offset)...); err != nil {
+		return nil, 0, fmt.Errorf("query player sessions: %w", err)
+	}
+
+	sessions := make([]*entities.GameSession, 0, len(rows))
+	for i := range rows {
+		sessions = append(sessions, rows[i].toEntity())
+	}
+	return sessions, total, nil
+}
+
+// FindPlayerTotals finds a player's stored totals of the given sessions,
+// by session ID. Sessions without stored totals are left out.
+func (r *PostgresGameSessionRepository) FindPlayerTotals(ctx context.Context, steamID64 string, sessionIDs []string) (map[string]*entities.SessionPlayerTotals, error) {
+	totals := make(map[string]*entities.SessionPlayerTotals)
+	if len(sessionIDs) == 0 {
+		return totals, nil
+	}
+
+	query := `
+		SELECT session_id, rounds, score, weapons
+		FROM session_player_stats
+		WHERE steam_id64 = $1 AND session_id = ANY($2)
+	`
+	rows, err := r.db.QueryContext(ctx, query, steamID64, pq.Array(sessionIDs))
+	if err != nil {
+		return nil, fmt.Errorf("query player totals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		playerTotals := &entities.SessionPlayerTotals{SteamID64: steamID64}
+		var score, weapons []byte
+		if err := rows.Scan(&playerTotals.SessionID, &playerTotals.Rounds, &score, &weapons); err != nil {
+			return nil, fmt.Errorf("scan player totals: %w", err)
+		}
+		if err := json.Unmarshal(score, &playerTotals.Score); err != nil {
+			return nil, fmt.Errorf("unmarshal player score: %w", err)
+		}
+		if err := json.Unmarshal(weapons, &playerTotals.Weapons); err != nil {
+			return nil, fmt.Errorf("unmarshal player weapons: %w", err)
+		}
+		totals[playerTotals.SessionID] = playerTotals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query player totals: %w", err)
+	}
+	return totals, nil
+}
+
+// SavePlayerTotals stores the totals of the players of a finished session,
+// replacing any stored before, in one transaction
+func (r *PostgresGameSessionRepository) SavePlayerTotals(ctx context.Context, totals []*entities.SessionPlayerTotals) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO session_player_stats (session_id, steam_id64, rounds, score, weapons)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (session_id, steam_id64) DO UPDATE SET
+			rounds = EXCLUDED.rounds,
+			score = EXCLUDED.score,
+			weapons = EXCLUDED.weapons,
+			created_at = NOW()
+	`
+	for _, playerTotals := range totals {
+		score, err := json.Marshal(playerTotals.Score)
+		if err != nil {
+			return fmt.Errorf("marshal player score: %w", err)
+		}
+		weapons, err := json.Marshal(playerTotals.Weapons)
+		if err != nil {
+			return fmt.Errorf("marshal player weapons: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, query, playerTotals.SessionID, playerTotals.SteamID64,
+			playerTotals.Rounds, score, weapons); err != nil {
+			return fmt.Errorf("save player totals: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit player totals: %w", err)
+	}
+	return nil
+}
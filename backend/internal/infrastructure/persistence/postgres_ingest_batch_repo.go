@@ -0,0 +1,34 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresIngestBatchRepository reads ingestion batch counters
+type PostgresIngestBatchRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresIngestBatchRepository creates a new PostgreSQL ingest batch repository
+func NewPostgresIngestBatchRepository(db *sqlx.DB) *PostgresIngestBatchRepository {
+	return &PostgresIngestBatchRepository{db: db}
+}
+
+// FindByID finds a batch by ID
+func (r *PostgresIngestBatchRepository) FindByID(ctx context.Context, id string) (*entities.IngestBatch, error) {
+	var batch entities.IngestBatch
+	query := `SELECT * FROM ingest_batches WHERE id = $1`
+	err := r.db.GetContext(ctx, &batch, query, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("batch not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query batch: %w", err)
+	}
+	return &batch, nil
+}
@@ -0,0 +1,100 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresLogRepository implements LogRepository using PostgreSQL. Batches
+// are written with PostgresRawLogWriter.
+type PostgresLogRepository struct {
+	db     *sqlx.DB
+	writer *PostgresRawLogWriter
+}
+
+// NewPostgresLogRepository creates a new PostgreSQL raw log repository
+func NewPostgresLogRepository(db *sqlx.DB) *PostgresLogRepository {
+	return &PostgresLogRepository{db: db, writer: NewPostgresRawLogWriter(db)}
+}
+
+const rawLogColumns = `id, server_id, content, received_at AS created_at`
+
+// Create saves a new raw log. It is not queued for parsing.
+func (r *PostgresLogRepository) Create(ctx context.Context, log *entities.Log) error {
+	if log.ID == "" {
+		log.ID = uuid.New().String()
+	}
+	if log.CreatedAt.IsZero() {
+		log.CreatedAt = time.Now()
+	}
+	query := `INSERT INTO raw_logs (id, server_id, content, received_at) VALUES ($1, $2, $3, $4)`
+	if _, err := r.db.ExecContext(ctx, query, log.ID, log.ServerID, log.Content, log.CreatedAt); err != nil {
+		return fmt.Errorf("insert raw log: %w", err)
+	}
+	return nil
+}
+
+// CreateBatch saves the lines of a batch with their parse jobs
+func (r *PostgresLogRepository) CreateBatch(ctx context.Context, batch *entities.IngestBatch, lines []entities.IngestLine, dedupSince time.Time) ([]string, error) {
+	return r.writer.WriteBatch(ctx, batch, lines, dedupSince)
+}
+
+// FindByID retrieves a raw log by its ID
+func (r *PostgresLogRepository) FindByID(ctx context.Context, id string) (*entities.Log, error) {
+	var log rawLogRow
+	err := r.db.GetContext(ctx, &log, `SELECT `+rawLogColumns+` FROM raw_logs WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("log not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query raw log: %w", err)
+	}
+	return log.toEntity(), nil
+}
+
+// FindByServerID retrieves the raw logs of a server, newest first
+func (r *PostgresLogRepository) FindByServerID(ctx context.Context, serverID string, limit int, offset int) ([]*entities.Log, error) {
+	var rows []rawLogRow
+	query := `SELECT ` + rawLogColumns + ` FROM raw_logs WHERE server_id = $1
+		ORDER BY received_at DESC, id DESC LIMIT $2 OFFSET $3`
+	if err := r.db.SelectContext(ctx, &rows, query, serverID, limit, offset); err != nil {
+		return nil, fmt.Errorf("query raw logs: %w", err)
+	}
+	logs := make([]*entities.Log, 0, len(rows))
+	for i := range rows {
+		logs = append(logs, rows[i].toEntity())
+	}
+	return logs, nil
+}
+
+// Count returns the number of raw logs of a server
+func (r *PostgresLogRepository) Count(ctx context.Context, serverID string) (int64, error) {
+	var count int64
+	if err := r.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM raw_logs WHERE server_id = $1`, serverID); err != nil {
+		return 0, fmt.Errorf("count raw logs: %w", err)
+	}
+	return count, nil
+}
+
+// rawLogRow is a raw_logs row; the server of old rows may be missing
+type rawLogRow struct {
+	ID        string         `db:"id"`
+	ServerID  sql.NullString `db:"server_id"`
+	Content   string         `db:"content"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+func (row *rawLogRow) toEntity() *entities.Log {
+	return &entities.Log{
+		ID:        row.ID,
+		ServerID:  row.ServerID.String,
+		Content:   row.Content,
+		CreatedAt: row.CreatedAt,
+	}
+}
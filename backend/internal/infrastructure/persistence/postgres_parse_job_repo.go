@@ -0,0 +1,168 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresParseJobRepository implements the durable parse queue using PostgreSQL
+type PostgresParseJobRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresParseJobRepository creates a new PostgreSQL parse job repository
+func NewPostgresParseJobRepository(db *sqlx.DB) *PostgresParseJobRepository {
+	return &PostgresParseJobRepository{db: db}
+}
+
+// Claim marks up to limit pending jobs of servers not in skipServers as
+// processing and returns them in queue order. SKIP LOCKED lets several
+// consumers claim without blocking.
+func (r *PostgresParseJobRepository) Claim(ctx context.Context, limit int, skipServers []string) ([]*entities.ParseJob, error) {
+	query := `
+		UPDATE parse_jobs j
+		SET status = 'processing', attempts = j.attempts + 1, locked_at = NOW()
+		FROM raw_logs r
+		WHERE r.id = j.raw_log_id AND j.id IN (
+			SELECT id FROM parse_jobs
+			WHERE status = 'pending' AND NOT COALESCE(server_id = ANY($2), FALSE)
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING j.id, j.raw_log_id, j.server_id, j.status, j.attempts, j.created_at, r.content
+	`
+
+	var jobs []*entities.ParseJob
+	if err := r.db.SelectContext(ctx, &jobs, query, limit, pq.Array(skipServers)); err != nil {
+		return nil, fmt.Errorf("claim parse jobs: %w", err)
+	}
+
+	// RETURNING does not preserve the subquery order
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// batchCountQuery adds the jobs finished by the jobs CTE to the given
+// counter of their batches and closes batches with no pending lines
+const batchCountQuery = `
+	UPDATE ingest_batches b
+	SET %[1]s = b.%[1]s + f.n,
+		completed_at = CASE
+			WHEN b.parsed_count + b.failed_count + f.n >= b.saved_count THEN NOW()
+			ELSE b.completed_at
+		END
+	FROM (
+		SELECT batch_id, COUNT(*) AS n FROM jobs
+		WHERE batch_id IS NOT NULL AND status = $%[2]d
+		GROUP BY batch_id
+	) f
+	WHERE b.id = f.batch_id
+`
+
+// Complete marks jobs as done and counts them against their batches; the
+// lines of unparseable jobs were stored as failed parses
+func (r *PostgresParseJobRepository) Complete(ctx context.Context, parsed, unparseable []int64) error {
+	for _, c := range []struct {
+		ids     []int64
+		counter string
+	}{
+		{parsed, "parsed_count"},
+		{unparseable, "failed_count"},
+	} {
+		if len(c.ids) == 0 {
+			continue
+		}
+
+		query := `
+			WITH jobs AS (
+				UPDATE parse_jobs
+				SET status = 'done', locked_at = NULL, last_error = NULL, completed_at = NOW()
+				WHERE id = ANY($1) AND status = 'processing'
+				RETURNING batch_id, status
+			)` + fmt.Sprintf(batchCountQuery, c.counter, 2)
+		if _, err := r.db.ExecContext(ctx, query, pq.Array(c.ids), entities.ParseJobDone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fail gives up on a job: it is marked failed and counts as failed in its
+// batch. It is not put back in the queue, where it would be parsed after
+// later lines of its server.
+func (r *PostgresParseJobRepository) Fail(ctx context.Context, id int64, errorMsg string) error {
+	query := `
+		WITH jobs AS (
+			UPDATE parse_jobs
+			SET status = 'failed', last_error = $2, locked_at = NULL
+			WHERE id = $1 AND status = 'processing'
+			RETURNING batch_id, status
+		)` + fmt.Sprintf(batchCountQuery, "failed_count", 3)
+	_, err := r.db.ExecContext(ctx, query, id, errorMsg, entities.ParseJobFailed)
+	return err
+}
+
+// RequeueInFlight returns jobs claimed before lockedBefore that were never
+// finished, e.g. because the process stopped, to the pending state. Jobs
+// claimed since are left to the instance working on them.
+func (r *PostgresParseJobRepository) RequeueInFlight(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	query := `
+		UPDATE parse_jobs SET status = 'pending', locked_at = NULL
+		WHERE status = 'processing' AND (locked_at IS NULL OR locked_at < $1)
+	`
+	result, err := r.db.ExecContext(ctx, query, lockedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeBatches deletes ingestion batches that completed before the given
+// time; their status can no longer be looked up
+func (r *PostgresParseJobRepository) PurgeBatches(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM ingest_batches WHERE completed_at < $1`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PendingByServer counts the jobs waiting to be claimed per server
+func (r *PostgresParseJobRepository) PendingByServer(ctx context.Context) (map[string]int, error) {
+	var rows []struct {
+		ServerID string `db:"server_id"`
+		Pending  int    `db:"pending"`
+	}
+	query := `
+		SELECT COALESCE(server_id, '') AS server_id, COUNT(*) AS pending
+		FROM parse_jobs WHERE status = 'pending'
+		GROUP BY server_id
+	`
+	if err := r.db.SelectContext(ctx, &rows, query); err != nil {
+		return nil, fmt.Errorf("count pending parse jobs: %w", err)
+	}
+
+	pending := make(map[string]int, len(rows))
+	for _, row := range rows {
+		pending[row.ServerID] = row.Pending
+	}
+	return pending, nil
+}
+
+// PurgeCompleted deletes done jobs that finished before the given time
+func (r *PostgresParseJobRepository) PurgeCompleted(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM parse_jobs WHERE status = 'done' AND completed_at < $1`
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
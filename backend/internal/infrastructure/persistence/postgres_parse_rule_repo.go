@@ -0,0 +1,230 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresParseRuleRepository stores custom parse rules using PostgreSQL
+type PostgresParseRuleRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresParseRuleRepository creates a new PostgreSQL parse rule repository
+func NewPostgresParseRuleRepository(db *sqlx.DB) *PostgresParseRuleRepository {
+	return &PostgresParseRuleRepository{db: db}
+}
+
+// parseRuleRow is a parse_rules row; field types are stored as JSON
+type parseRuleRow struct {
+	ID          string         `db:"id"`
+	Name        string         `db:"name"`
+	Description sql.NullString `db:"description"`
+	Pattern     string         `db:"pattern"`
+	EventType   string         `db:"event_type"`
+	Stage       string         `db:"stage"`
+	Priority    int            `db:"priority"`
+	FieldTypes  []byte         `db:"field_types"`
+	Enabled     bool           `db:"enabled"`
+	CreatedBy   sql.NullString `db:"created_by"`
+	CreatedAt   time.Time      `db:"created_at"`
+	UpdatedAt   time.Time      `db:"updated_at"`
+}
+
+const parseRuleColumns = `id, name, description, pattern, event_type, stage, priority, field_types,
+	enabled, created_by, created_at, updated_at`
+
+func (row *parseRuleRow) toEntity() *entities.ParseRule {
+	rule := &entities.ParseRule{
+		ID:          row.ID,
+		Name:        row.Name,
+		Description: row.Description.String,
+		Pattern:     row.Pattern,
+		EventType:   row.EventType,
+		Stage:       entities.ParseRuleStage(row.Stage),
+		Priority:    row.Priority,
+		Enabled:     row.Enabled,
+		Source:      entities.ParseRuleSourceDatabase,
+		CreatedBy:   row.CreatedBy.String,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+	}
+	json.Unmarshal(row.FieldTypes, &rule.FieldTypes)
+	return rule
+}
+
+func (r *PostgresParseRuleRepository) selectRules(ctx context.Context, query string, args ...interface{}) ([]*entities.ParseRule, error) {
+	var rows []parseRuleRow
+	if err := r.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("query parse rules: %w", err)
+	}
+	rules := make([]*entities.ParseRule, 0, len(rows))
+	for i := range rows {
+		rules = append(rules, rows[i].toEntity())
+	}
+	return rules, nil
+}
+
+// List lists every stored rule in the order rules run
+func (r *PostgresParseRuleRepository) List(ctx context.Context) ([]*entities.ParseRule, error) {
+	return r.selectRules(ctx, `SELECT `+parseRuleColumns+` FROM parse_rules ORDER BY priority, name`)
+}
+
+// FindEnabled lists the enabled rules in the order rules run
+func (r *PostgresParseRuleRepository) FindEnabled(ctx context.Context) ([]*entities.ParseRule, error) {
+	return r.selectRules(ctx, `SELECT `+parseRuleColumns+` FROM parse_rules WHERE enabled ORDER BY priority, name`)
+}
+
+// FindByID retrieves a rule by its ID
+func (r *PostgresParseRuleRepository) FindByID(ctx context.Context, id string) (*entities.ParseRule, error) {
+	rules, err := r.selectRules(ctx, `SELECT `+parseRuleColumns+` FROM parse_rules WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("parse rule not found")
+	}
+	return rules[0], nil
+}
+
+// Version changes whenever a rule is created, updated or deleted, so other
+// instances know to reload
+func (r *PostgresParseRuleRepository) Version(ctx context.Context) (string, error) {
+	var version string
+	err := r.db.GetContext(ctx, &version, `
+		SELECT COUNT(*) || '/' || COALESCE(MAX(updated_at)::text, '') FROM parse_rules
+	`)
+	if err != nil {
+		return "", fmt.Errorf("query parse rule version: %w", err)
+	}
+	return version, nil
+}
+
+// Create saves a new rule
+func (r *PostgresParseRuleRepository) Create(ctx context.Context, rule *entities.ParseRule) error {
+	fieldTypes, err := json.Marshal(rule.FieldTypes)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO parse_rules (name, description, pattern, event_type, stage, priority, field_types,
+			enabled, created_by, created_at, updated_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NOW(), NOW())
+		RETURNING id, created_at, updated_at
+	`
+	err = r.db.QueryRowxContext(ctx, query, rule.Name, rule.Description, rule.Pattern, rule.EventType,
+		rule.Stage, rule.Priority, fieldTypes, rule.Enabled, rule.CreatedBy,
+	).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("parse rule name already exists")
+		}
+		return fmt.Errorf("insert parse rule: %w", err)
+	}
+	rule.Source = entities.ParseRuleSourceDatabase
+	return nil
+}
+
+// Update saves the changes to a rule
+func (r *PostgresParseRuleRepository) Update(ctx context.Context, rule *entities.ParseRule) error {
+	fieldTypes, err := json.Marshal(rule.FieldTypes)
+	if err != nil {
+		return err
+	}
+	query := `
+		UPDATE parse_rules
+		SET name = $2, description = NULLIF($3, ''), pattern = $4, event_type = $5, stage = $6,
+			priority = $7, field_types = $8, enabled = $9, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`
+	err = r.db.QueryRowxContext(ctx, query, rule.ID, rule.Name, rule.Description, rule.Pattern,
+		rule.EventType, rule.Stage, rule.Priority, fieldTypes, rule.Enabled,
+	).Scan(&rule.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("parse rule not found")
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("parse rule name already exists")
+		}
+		return fmt.Errorf("update parse rule: %w", err)
+	}
+	return nil
+}
+
+// Delete deletes a rule
+func (r *PostgresParseRuleRepository) Delete(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM parse_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("delete parse rule: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("parse rule not found")
+	}
+	return nil
+}
+
+// FindDryRunLines retrieves up to limit stored raw logs of a server and
+// receive time range, newest first, with the event types they are stored as
+func (r *PostgresParseRuleRepository) FindDryRunLines(ctx context.Context, serverID string, from, to *time.Time, limit int) ([]*entities.ReparseLine, error) {
+	var conditions []string
+	var args []interface{}
+	if serverID != "" {
+		args = append(args, serverID)
+		conditions = append(conditions, fmt.Sprintf("r.server_id = $%d", len(args)))
+	}
+	if from != nil {
+		args = append(args, *from)
+		conditions = append(conditions, fmt.Sprintf("r.received_at >= $%d", len(args)))
+	}
+	if to != nil {
+		args = append(args, *to)
+		conditions = append(conditions, fmt.Sprintf("r.received_at < $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, limit)
+
+	query := fmt.Sprintf(`
+		SELECT r.id, r.server_id, r.content, r.received_at,
+			ARRAY(SELECT p.event_type FROM parsed_logs p WHERE p.raw_log_id = r.id ORDER BY p.created_at) AS event_types
+		FROM raw_logs r
+		%s
+		ORDER BY r.received_at DESC, r.id DESC
+		LIMIT $%d
+	`, where, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query dry run lines: %w", err)
+	}
+	defer rows.Close()
+
+	lines := []*entities.ReparseLine{}
+	for rows.Next() {
+		var line entities.ReparseLine
+		if err := rows.Scan(&line.RawLogID, &line.ServerID, &line.Content, &line.ReceivedAt, pq.Array(&line.EventTypes)); err != nil {
+			return nil, fmt.Errorf("scan dry run line: %w", err)
+		}
+		lines = append(lines, &line)
+	}
+	return lines, rows.Err()
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
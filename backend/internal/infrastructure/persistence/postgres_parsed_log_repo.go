@@ -0,0 +1,166 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/domain/repositories"
+)
+
+// PostgresParsedLogRepository implements ParsedLogRepository using PostgreSQL
+type PostgresParsedLogRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresParsedLogRepository creates a new PostgreSQL parsed log repository
+func NewPostgresParsedLogRepository(db *sqlx.DB) *PostgresParsedLogRepository {
+	return &PostgresParsedLogRepository{db: db}
+}
+
+// parsedLogRow is a parsed_logs row; event data is stored as JSON
+type parsedLogRow struct {
+	ID                       string          `db:"id"`
+	RawLogID                 sql.NullString  `db:"raw_log_id"`
+	ServerID                 sql.NullString  `db:"server_id"`
+	EventType                sql.NullString  `db:"event_type"`
+	EventData                []byte          `db:"event_data"`
+	GameTime                 sql.NullString  `db:"game_time"`
+	EventTime                *time.Time      `db:"event_time"`
+	SessionID                sql.NullString  `db:"session_id"`
+	RoundNumber              *int            `db:"round_number"`
+	GamePhase                *string         `db:"game_phase"`
+	ParserVersion            sql.NullString  `db:"parser_version"`
+	ClassificationSource     string          `db:"classification_source"`
+	ClassificationConfidence sql.NullFloat64 `db:"classification_confidence"`
+	ParseRule                sql.NullString  `db:"parse_rule"`
+	CreatedAt                time.Time       `db:"created_at"`
+}
+
+const parsedLogColumns = `id, raw_log_id, server_id, event_type, event_data, game_time, event_time,
+	session_id, round_number, game_phase, parser_version, classification_source,
+	classification_confidence, parse_rule, created_at`
+
+func (row *parsedLogRow) toEntity() (*entities.ParsedLog, error) {
+	parsed := &entities.ParsedLog{
+		ID:                   row.ID,
+		RawLogID:             row.RawLogID.String,
+		ServerID:             row.ServerID.String,
+		EventType:            row.EventType.String,
+		GameTime:             row.GameTime.String,
+		EventTime:            row.EventTime,
+		SessionID:            row.SessionID.String,
+		RoundNumber:          row.RoundNumber,
+		GamePhase:            row.GamePhase,
+		CreatedAt:            row.CreatedAt,
+		ParserVersion:        row.ParserVersion.String,
+		ClassificationSource: row.ClassificationSource,
+		ParseRule:            row.ParseRule.String,
+	}
+	if row.ClassificationConfidence.Valid {
+		parsed.Confidence = &row.ClassificationConfidence.Float64
+	}
+	eventData, err := decodeEventData(row.EventData)
+	if err != nil {
+		return nil, fmt.Errorf("decode event data of parsed log %s: %w", row.ID, err)
+	}
+	parsed.EventData = eventData
+	return parsed, nil
+}
+
+// decodeEventData decodes stored event data the way the parser decodes new
+// events, keeping numbers as json.Number so SteamID64s stay exact
+func decodeEventData(data []byte) (map[string]interface{}, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var eventData map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&eventData); err != nil {
+		return nil, err
+	}
+	return eventData, nil
+}
+
+// Create saves a new parsed log and sets its ID when it has none. It returns
+// repositories.ErrParsedLogExists when the raw log already has an event of
+// the same type.
+func (r *PostgresParsedLogRepository) Create(ctx context.Context, parsedLog *entities.ParsedLog) error {
+	if parsedLog.ID == "" {
+		parsedLog.ID = uuid.New().String()
+	}
+	eventData, err := json.Marshal(parsedLog.EventData)
+	if err != nil {
+		return fmt.Errorf("marshal event data: %w", err)
+	}
+
+	query := `
+		INSERT INTO parsed_logs (id, raw_log_id, server_id, event_type, event_data, game_time, event_time,
+			session_id, round_number, game_phase, parser_version, classification_source,
+			classification_confidence, parse_rule, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), $9, $10, NULLIF($11, ''),
+			COALESCE(NULLIF($12, ''), 'parser'), $13, NULLIF($14, ''), $15)
+		ON CONFLICT (raw_log_id, event_type) DO NOTHING
+	`
+	result, err := r.db.ExecContext(ctx, query,
+		parsedLog.ID, parsedLog.RawLogID, parsedLog.ServerID, parsedLog.EventType, eventData,
+		parsedLog.GameTime, parsedLog.EventTime, parsedLog.SessionID, parsedLog.RoundNumber,
+		parsedLog.GamePhase, parsedLog.ParserVersion, parsedLog.ClassificationSource,
+		parsedLog.Confidence, parsedLog.ParseRule, parsedLog.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert parsed log: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return repositories.ErrParsedLogExists
+	}
+	return nil
+}
+
+// FindByID retrieves a parsed log by its ID
+func (r *PostgresParsedLogRepository) FindByID(ctx context.Context, id string) (*entities.ParsedLog, error) {
+	var row parsedLogRow
+	err := r.db.GetContext(ctx, &row, `SELECT `+parsedLogColumns+` FROM parsed_logs WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("parsed log not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query parsed log: %w", err)
+	}
+	return row.toEntity()
+}
+
+// FindBySessionID retrieves the parsed logs of a game session in log order
+func (r *PostgresParsedLogRepository) FindBySessionID(ctx context.Context, sessionID string, limit int, offset int) ([]*entities.ParsedLog, error) {
+	return r.selectParsedLogs(ctx, `SELECT `+parsedLogColumns+` FROM parsed_logs WHERE session_id = $1
+		ORDER BY event_time ASC NULLS LAST, created_at ASC LIMIT $2 OFFSET $3`, sessionID, limit, offset)
+}
+
+// FindByEventType retrieves parsed logs of an event type, newest first
+func (r *PostgresParsedLogRepository) FindByEventType(ctx context.Context, eventType string, limit int, offset int) ([]*entities.ParsedLog, error) {
+	return r.selectParsedLogs(ctx, `SELECT `+parsedLogColumns+` FROM parsed_logs WHERE event_type = $1
+		ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, eventType, limit, offset)
+}
+
+func (r *PostgresParsedLogRepository) selectParsedLogs(ctx context.Context, query string, args ...interface{}) ([]*entities.ParsedLog, error) {
+	var rows []parsedLogRow
+	if err := r.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, fmt.Errorf("query parsed logs: %w", err)
+	}
+	parsedLogs := make([]*entities.ParsedLog, 0, len(rows))
+	for i := range rows {
+		parsedLog, err := rows[i].toEntity()
+		if err != nil {
+			return nil, err
+		}
+		parsedLogs = append(parsedLogs, parsedLog)
+	}
+	return parsedLogs, nil
+}
@@ -0,0 +1,131 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresPlayerRepository stores the players seen in the logs with their
+// names, the servers they played on and the events they took part in
+type PostgresPlayerRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresPlayerRepository creates a new PostgreSQL player repository
+func NewPostgresPlayerRepository(db *sqlx.DB) *PostgresPlayerRepository {
+	return &PostgresPlayerRepository{db: db}
+}
+
+// RecordSightings records the players of a parsed event, seen at the given
+// time: each player's latest name and seen times, their name history, the
+// server and the link to the event
+func (r *PostgresPlayerRepository) RecordSightings(ctx context.Context, parsedLogID, serverID string, players []*entities.Player, at time.Time) error {
+	return recordSightings(ctx, r.db, parsedLogID, serverID, players, at)
+}
+
+// recordSightings records the players of a parsed event. All players of the
+// event are written in one statement, so a sighting is recorded in full or
+// not at all.
+func recordSightings(ctx context.Context, db sqlx.ExecerContext, parsedLogID, serverID string, players []*entities.Player, at time.Time) error {
+	if len(players) == 0 {
+		return nil
+	}
+
+	steamID64s := make([]string, len(players))
+	steamID3s := make([]string, len(players))
+	accountIDs := make([]int64, len(players))
+	names := make([]string, len(players))
+	for i, player := range players {
+		steamID64s[i] = player.SteamID64
+		steamID3s[i] = player.SteamID3
+		accountIDs[i] = player.AccountID
+		names[i] = player.Name
+	}
+
+	// Lines can be parsed out of order; the name of the latest sighting wins
+	query := `
+		WITH sighting AS (
+			SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::bigint[], $4::varchar[])
+				AS s(steam_id64, steam_id3, account_id, name)
+		), upserted_players AS (
+			INSERT INTO players (steam_id64, steam_id3, account_id, name, first_seen_at, last_seen_at)
+			SELECT steam_id64, steam_id3, account_id, name, $5, $5 FROM sighting
+			ON CONFLICT (steam_id64) DO UPDATE SET
+				name = CASE WHEN EXCLUDED.last_seen_at >= players.last_seen_at THEN EXCLUDED.name ELSE players.name END,
+				first_seen_at = LEAST(players.first_seen_at, EXCLUDED.first_seen_at),
+				last_seen_at = GREATEST(players.last_seen_at, EXCLUDED.last_seen_at)
+			RETURNING steam_id64
+		), upserted_names AS (
+			INSERT INTO player_names (steam_id64, name, first_seen_at, last_seen_at)
+			SELECT steam_id64, name, $5, $5 FROM sighting
+			ON CONFLICT (steam_id64, name) DO UPDATE SET
+				first_seen_at = LEAST(player_names.first_seen_at, EXCLUDED.first_seen_at),
+				last_seen_at = GREATEST(player_names.last_seen_at, EXCLUDED.last_seen_at)
+		), upserted_servers AS (
+			INSERT INTO player_servers (steam_id64, server_id, first_seen_at, last_seen_at)
+			SELECT steam_id64, $6, $5, $5 FROM sighting
+			ON CONFLICT (steam_id64, server_id) DO UPDATE SET
+				first_seen_at = LEAST(player_servers.first_seen_at, EXCLUDED.first_seen_at),
+				last_seen_at = GREATEST(player_servers.last_seen_at, EXCLUDED.last_seen_at)
+		)
+		INSERT INTO parsed_log_players (parsed_log_id, steam_id64)
+		SELECT $7, steam_id64 FROM upserted_players
+		ON CONFLICT DO NOTHING
+	`
+	_, err := db.ExecContext(ctx, query,
+		pq.Array(steamID64s), pq.Array(steamID3s), pq.Array(accountIDs), pq.Array(names),
+		at, serverID, parsedLogID,
+	)
+	if err != nil {
+		return fmt.Errorf("record player sightings: %w", err)
+	}
+	return nil
+}
+
+// FindBySteamID64 finds a player with their name history, most recent first,
+// and the servers they were seen on
+func (r *PostgresPlayerRepository) FindBySteamID64(ctx context.Context, steamID64 string) (*entities.Player, error) {
+	var player entities.Player
+	err := r.db.GetContext(ctx, &player, `
+		SELECT steam_id64, steam_id3, account_id, name, first_seen_at, last_seen_at, created_at
+		FROM players
+		WHERE steam_id64 = $1
+	`, steamID64)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("player not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query player: %w", err)
+	}
+
+	player.Names = []entities.PlayerName{}
+	err = r.db.SelectContext(ctx, &player.Names, `
+		SELECT name, first_seen_at, last_seen_at
+		FROM player_names
+		WHERE steam_id64 = $1
+		ORDER BY last_seen_at DESC
+	`, steamID64)
+	if err != nil {
+		return nil, fmt.Errorf("query player names: %w", err)
+	}
+
+	player.Servers = []entities.PlayerServer{}
+	err = r.db.SelectContext(ctx, &player.Servers, `
+		SELECT ps.server_id, s.name AS server_name, ps.first_seen_at, ps.last_seen_at
+		FROM player_servers ps
+		LEFT JOIN servers s ON s.id = ps.server_id
+		WHERE ps.steam_id64 = $1
+		ORDER BY ps.last_seen_at DESC
+	`, steamID64)
+	if err != nil {
+		return nil, fmt.Errorf("query player servers: %w", err)
+	}
+
+	return &player, nil
+}
@@ -0,0 +1,202 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresRawLogWriter bulk-inserts raw log lines and their parse jobs
+type PostgresRawLogWriter struct {
+	db *sqlx.DB
+}
+
+// NewPostgresRawLogWriter creates a new PostgreSQL raw log writer
+func NewPostgresRawLogWriter(db *sqlx.DB) *PostgresRawLogWriter {
+	return &PostgresRawLogWriter{db: db}
+}
+
+// WriteBatch records the batch, saves the lines as raw logs and queues a
+// parse job for each, streaming the lines with COPY inside one transaction.
+//
+// Batches with NoReceipt set are not recorded, and their lines and jobs
+// belong to no batch.
+//
+// If the batch has an idempotency key that the server already used, nothing
+// is saved and the batch is replaced by the original one with Replayed set.
+// Lines whose hash was already saved for the server since dedupSince are
+// skipped and counted in DuplicateCount.
+//
+// It returns the raw log IDs in line order, with an empty ID for skipped
+// lines; on error nothing is saved.
+func (w *PostgresRawLogWriter) WriteBatch(ctx context.Context, batch *entities.IngestBatch, lines []entities.IngestLine, dedupSince time.Time) ([]string, error) {
+	tx, err := w.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize writes per server until commit, so concurrent retries of the
+	// same request cannot both pass the duplicate checks and the parse jobs
+	// of overlapping requests are not numbered in between each other
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, batch.ServerID); err != nil {
+		return nil, fmt.Errorf("lock server: %w", err)
+	}
+
+	if batch.IdempotencyKey != nil {
+		var existing entities.IngestBatch
+		query := `SELECT * FROM ingest_batches WHERE server_id = $1 AND idempotency_key = $2`
+		err := tx.GetContext(ctx, &existing, query, batch.ServerID, *batch.IdempotencyKey)
+		if err == nil {
+			*batch = existing
+			batch.Replayed = true
+			return make([]string, len(lines)), nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("query idempotency key: %w", err)
+		}
+	}
+
+	seen, err := w.savedHashCounts(ctx, tx, batch.ServerID, lines, dedupSince)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(lines))
+	batch.SavedCount = 0
+	batch.DuplicateCount = 0
+	for i, line := range lines {
+		if line.Hash != nil && seen[string(line.Hash)] > 0 {
+			seen[string(line.Hash)]--
+			batch.DuplicateCount++
+			continue
+		}
+		ids[i] = uuid.New().String()
+		batch.SavedCount++
+	}
+
+	batch.CreatedAt = time.Now()
+	if !batch.NoReceipt {
+		batchQuery := `
+			INSERT INTO ingest_batches (id, server_id, idempotency_key, saved_count, duplicate_count, created_at, completed_at)
+			VALUES ($1, $2, $3, $4, $5, $6, CASE WHEN $4 = 0 THEN $6::timestamp END)
+		`
+		if _, err := tx.ExecContext(ctx, batchQuery,
+			batch.ID, batch.ServerID, batch.IdempotencyKey, batch.SavedCount, batch.DuplicateCount, batch.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("create batch: %w", err)
+		}
+	}
+
+	if batch.SavedCount > 0 {
+		if err := w.copyLines(ctx, tx, batch, lines, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit raw logs: %w", err)
+	}
+
+	return ids, nil
+}
+
+// savedHashCounts returns how often each line hash was saved for the server
+// since the given time
+func (w *PostgresRawLogWriter) savedHashCounts(ctx context.Context, tx *sqlx.Tx, serverID string, lines []entities.IngestLine, since time.Time) (map[string]int, error) {
+	var hashes [][]byte
+	for _, line := range lines {
+		if line.Hash != nil {
+			hashes = append(hashes, line.Hash)
+		}
+	}
+
+	seen := make(map[string]int)
+	if len(hashes) == 0 {
+		return seen, nil
+	}
+
+	query := `
+		SELECT content_hash, COUNT(*) FROM raw_logs
+		WHERE server_id = $1 AND content_hash = ANY($2) AND received_at >= $3
+		GROUP BY content_hash
+	`
+	rows, err := tx.QueryContext(ctx, query, serverID, pq.ByteaArray(hashes), since)
+	if err != nil {
+		return nil, fmt.Errorf("query line hashes: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash []byte
+		var count int
+		if err := rows.Scan(&hash, &count); err != nil {
+			return nil, fmt.Errorf("scan line hash: %w", err)
+		}
+		seen[string(hash)] = count
+	}
+	return seen, rows.Err()
+}
+
+// copyLines streams the lines that were given an ID into raw_logs and
+// parse_jobs
+func (w *PostgresRawLogWriter) copyLines(ctx context.Context, tx *sqlx.Tx, batch *entities.IngestBatch, lines []entities.IngestLine, ids []string) error {
+	var batchID interface{} = batch.ID
+	if batch.NoReceipt {
+		batchID = nil
+	}
+
+	rawStmt, err := tx.PrepareContext(ctx, pq.CopyIn("raw_logs", "id", "server_id", "batch_id", "content", "content_hash", "received_at"))
+	if err != nil {
+		return fmt.Errorf("prepare raw log copy: %w", err)
+	}
+	for i, line := range lines {
+		if ids[i] == "" {
+			continue
+		}
+		if _, err := rawStmt.ExecContext(ctx, ids[i], batch.ServerID, batchID, line.Content, line.Hash, batch.CreatedAt); err != nil {
+			rawStmt.Close()
+			return fmt.Errorf("copy raw log: %w", err)
+		}
+	}
+	if _, err := rawStmt.ExecContext(ctx); err != nil {
+		rawStmt.Close()
+		return fmt.Errorf("flush raw logs: %w", err)
+	}
+	if err := rawStmt.Close(); err != nil {
+		return fmt.Errorf("close raw log copy: %w", err)
+	}
+
+	// Job IDs are assigned in COPY order. Jobs are claimed by ID, so the
+	// server lock taken above is what keeps a server's lines in the order
+	// they were received across requests; sequence values of transactions
+	// running at the same time would otherwise interleave.
+	jobStmt, err := tx.PrepareContext(ctx, pq.CopyIn("parse_jobs", "raw_log_id", "server_id", "batch_id"))
+	if err != nil {
+		return fmt.Errorf("prepare parse job copy: %w", err)
+	}
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		if _, err := jobStmt.ExecContext(ctx, id, batch.ServerID, batchID); err != nil {
+			jobStmt.Close()
+			return fmt.Errorf("copy parse job: %w", err)
+		}
+	}
+	if _, err := jobStmt.ExecContext(ctx); err != nil {
+		jobStmt.Close()
+		return fmt.Errorf("flush parse jobs: %w", err)
+	}
+	if err := jobStmt.Close(); err != nil {
+		return fmt.Errorf("close parse job copy: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/config"
+)
+
+const benchServerID = "bench-raw-log-writer"
+
+// benchmarkDB connects to TEST_DATABASE_URL and skips when it is not set
+func benchmarkDB(b *testing.B) *sqlx.DB {
+	b.Helper()
+
+	dbURL := os.Getenv("TEST_DATABASE_URL")
+	if dbURL == "" {
+		b.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sqlx.Connect("postgres", dbURL)
+	if err != nil {
+		b.Fatalf("connect: %v", err)
+	}
+	if err := config.RunMigrations(db); err != nil {
+		b.Fatalf("migrate: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO servers (id, name) VALUES ($1, $1) ON CONFLICT (id) DO NOTHING`, benchServerID); err != nil {
+		b.Fatalf("create server: %v", err)
+	}
+
+	b.Cleanup(func() {
+		db.Exec(`DELETE FROM raw_logs WHERE server_id = $1`, benchServerID)
+		db.Exec(`DELETE FROM ingest_batches WHERE server_id = $1`, benchServerID)
+		db.Exec(`DELETE FROM servers WHERE id = $1`, benchServerID)
+		db.Close()
+	})
+	return db
+}
+
+func benchmarkLines(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf(`L 01/17/2025 - 20:15:%02d.%03d: "Player<2><[U:1:%d]><CT>" [-512 1024 64] attacked "Bot<3><BOT><TERRORIST>" [128 -256 0] with "ak47" (damage "27") (damage_armor "3") (health "73") (armor "97") (hitgroup "chest")`, i%60, i%1000, i)
+	}
+	return lines
+}
+
+// writePerLine is the previous ingestion path: one INSERT per raw log and
+// per parse job inside a single transaction
+func writePerLine(ctx context.Context, db *sqlx.DB, serverID string, lines []string) ([]string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]string, len(lines))
+	now := time.Now()
+	for i, line := range lines {
+		ids[i] = uuid.New().String()
+		if _, err := tx.ExecContext(ctx, `INSERT INTO raw_logs (id, server_id, content, received_at) VALUES ($1, $2, $3, $4)`, ids[i], serverID, line, now); err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO parse_jobs (raw_log_id, server_id) VALUES ($1, $2)`, ids[i], serverID); err != nil {
+			return nil, err
+		}
+	}
+	return ids, tx.Commit()
+}
+
+func benchmarkWrite(b *testing.B, batchSize int, write func(context.Context, []string) ([]string, error)) {
+	lines := benchmarkLines(batchSize)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		ids, err := write(ctx, lines)
+		if err != nil {
+			b.Fatalf("write: %v", err)
+		}
+		if len(ids) != len(lines) {
+			b.Fatalf("got %d ids for %d lines", len(ids), len(lines))
+		}
+	}
+	b.ReportMetric(float64(b.N*batchSize)/time.Since(start).Seconds(), "lines/sec")
+}
+
+// The PRD targets 10,000 lines per second; compare the lines/sec metric of
+// the two paths with:
+//
+//	TEST_DATABASE_URL=postgres://... go test -bench RawLogs -run ^$ ./internal/infrastructure/persistence
+func BenchmarkRawLogsPerLine(b *testing.B) {
+	db := benchmarkDB(b)
+	for _, size := range []int{50, 500} {
+		b.Run(fmt.Sprintf("lines=%d", size), func(b *testing.B) {
+			benchmarkWrite(b, size, func(ctx context.Context, lines []string) ([]string, error) {
+				return writePerLine(ctx, db, benchServerID, lines)
+			})
+		})
+	}
+}
+
+func BenchmarkRawLogsWriteBatch(b *testing.B) {
+	db := benchmarkDB(b)
+	writer := NewPostgresRawLogWriter(db)
+	for _, size := range []int{50, 500} {
+		b.Run(fmt.Sprintf("lines=%d", size), func(b *testing.B) {
+			benchmarkWrite(b, size, func(ctx context.Context, lines []string) ([]string, error) {
+				batch := &entities.IngestBatch{ID: uuid.New().String(), ServerID: benchServerID}
+				ingestLines := make([]entities.IngestLine, len(lines))
+				for i, line := range lines {
+					ingestLines[i] = entities.IngestLine{Content: line}
+				}
+				return writer.WriteBatch(ctx, batch, ingestLines, time.Now())
+			})
+		})
+	}
+}
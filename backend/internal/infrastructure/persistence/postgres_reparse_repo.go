@@ -0,0 +1,438 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// reparseJobRow is a reparse_jobs row; event type changes are stored as JSONB
+type reparseJobRow struct {
+	ID               string         `db:"id"`
+	ServerID         *string        `db:"server_id"`
+	ReceivedFrom     *time.Time     `db:"received_from"`
+	ReceivedTo       *time.Time     `db:"received_to"`
+	StaleOnly        bool           `db:"stale_only"`
+	ChunkSize        int            `db:"chunk_size"`
+	ParserVersion    string         `db:"parser_version"`
+	Status           string         `db:"status"`
+	CursorReceivedAt *time.Time     `db:"cursor_received_at"`
+	CursorRawLogID   *string        `db:"cursor_raw_log_id"`
+	ProcessedCount   int64          `db:"processed_count"`
+	ParsedCount      int64          `db:"parsed_count"`
+	FailedCount      int64          `db:"failed_count"`
+	SkippedCount     int64          `db:"skipped_count"`
+	ChangedCount     int64          `db:"changed_count"`
+	EventTypeChanges []byte         `db:"event_type_changes"`
+	ErrorMessage     *string        `db:"error_message"`
+	CreatedBy        sql.NullString `db:"created_by"`
+	CreatedAt        time.Time      `db:"created_at"`
+	UpdatedAt        time.Time      `db:"updated_at"`
+	CompletedAt      *time.Time     `db:"completed_at"`
+}
+
+const reparseJobColumns = `
+	id, server_id, received_from, received_to, stale_only, chunk_size, parser_version, status,
+	cursor_received_at, cursor_raw_log_id, processed_count, parsed_count, failed_count,
+	skipped_count, changed_count, event_type_changes, error_message, created_by,
+	created_at, updated_at, completed_at
+`
+
+func (row *reparseJobRow) toEntity() *entities.ReparseJob {
+	job := &entities.ReparseJob{
+		ID:               row.ID,
+		ServerID:         row.ServerID,
+		From:             row.ReceivedFrom,
+		To:               row.ReceivedTo,
+		StaleOnly:        row.StaleOnly,
+		ChunkSize:        row.ChunkSize,
+		ParserVersion:    row.ParserVersion,
+		Status:           entities.ReparseJobStatus(row.Status),
+		CursorReceivedAt: row.CursorReceivedAt,
+		CursorRawLogID:   row.CursorRawLogID,
+		Processed:        row.ProcessedCount,
+		Parsed:           row.ParsedCount,
+		Failed:           row.FailedCount,
+		Skipped:          row.SkippedCount,
+		Changed:          row.ChangedCount,
+		EventTypeChanges: []*entities.EventTypeChange{},
+		Error:            row.ErrorMessage,
+		CreatedBy:        row.CreatedBy.String,
+		CreatedAt:        row.CreatedAt,
+		UpdatedAt:        row.UpdatedAt,
+		CompletedAt:      row.CompletedAt,
+	}
+	if len(row.EventTypeChanges) > 0 {
+		json.Unmarshal(row.EventTypeChanges, &job.EventTypeChanges)
+	}
+	return job
+}
+
+// PostgresReparseRepository stores reparse jobs and replaces the parsed and
+// failed rows of the raw logs they reparse
+type PostgresReparseRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresReparseRepository creates a new PostgreSQL reparse repository
+func NewPostgresReparseRepository(db *sqlx.DB) *PostgresReparseRepository {
+	return &PostgresReparseRepository{db: db}
+}
+
+// CreateJob creates a reparse job
+func (r *PostgresReparseRepository) CreateJob(ctx context.Context, job *entities.ReparseJob) error {
+	query := `
+		INSERT INTO reparse_jobs (server_id, received_from, received_to, stale_only, chunk_size,
+			parser_version, status, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))
+		RETURNING id, created_at, updated_at
+	`
+	err := r.db.QueryRowxContext(ctx, query,
+		job.ServerID, job.From, job.To, job.StaleOnly, job.ChunkSize,
+		job.ParserVersion, string(job.Status), job.CreatedBy,
+	).Scan(&job.ID, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert reparse job: %w", err)
+	}
+	return nil
+}
+
+// FindJob finds a reparse job by ID
+func (r *PostgresReparseRepository) FindJob(ctx context.Context, id string) (*entities.ReparseJob, error) {
+	var row reparseJobRow
+	err := r.db.GetContext(ctx, &row, `SELECT `+reparseJobColumns+` FROM reparse_jobs WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("reparse job not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query reparse job: %w", err)
+	}
+	return row.toEntity(), nil
+}
+
+// ListJobs lists reparse jobs, most recent first
+func (r *PostgresReparseRepository) ListJobs(ctx context.Context, limit, offset int) ([]*entities.ReparseJob, error) {
+	var rows []reparseJobRow
+	query := `SELECT ` + reparseJobColumns + ` FROM reparse_jobs ORDER BY created_at DESC LIMIT $1 OFFSET $2`
+	if err := r.db.SelectContext(ctx, &rows, query, limit, offset); err != nil {
+		return nil, fmt.Errorf("query reparse jobs: %w", err)
+	}
+
+	jobs := make([]*entities.ReparseJob, 0, len(rows))
+	for i := range rows {
+		jobs = append(jobs, rows[i].toEntity())
+	}
+	return jobs, nil
+}
+
+// FindUnfinishedJobs finds the jobs that were pending or running, oldest
+// first
+func (r *PostgresReparseRepository) FindUnfinishedJobs(ctx context.Context) ([]*entities.ReparseJob, error) {
+	var rows []reparseJobRow
+	query := `SELECT ` + reparseJobColumns + ` FROM reparse_jobs WHERE status IN ($1, $2) ORDER BY created_at`
+	err := r.db.SelectContext(ctx, &rows, query, string(entities.ReparseJobPending), string(entities.ReparseJobRunning))
+	if err != nil {
+		return nil, fmt.Errorf("query unfinished reparse jobs: %w", err)
+	}
+
+	jobs := make([]*entities.ReparseJob, 0, len(rows))
+	for i := range rows {
+		jobs = append(jobs, rows[i].toEntity())
+	}
+	return jobs, nil
+}
+
+// UpdateStatus saves the status and error of a job, and its completion time
+// once it completed
+func (r *PostgresReparseRepository) UpdateStatus(ctx context.Context, job *entities.ReparseJob) error {
+	query := `
+		UPDATE reparse_jobs
+		SET status = $2, error_message = $3, completed_at = $4, updated_at = NOW()
+		WHERE id = $1
+	`
+	result, err := r.db.ExecContext(ctx, query, job.ID, string(job.Status), job.Error, job.CompletedAt)
+	if err != nil {
+		return fmt.Errorf("update reparse job: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("reparse job not found")
+	}
+	return nil
+}
+
+// derivedEventFilter leaves out the round_stats rows assembled from several
+// lines, which reparsing a single line does not replace
+const derivedEventFilter = `event_type NOT IN ('round_stats', 'round_stats_ref')`
+
+// NextChunk lists the next raw logs of a job after its cursor, in receive
+// order, with the event types they were last parsed as. Lines still waiting
+// in the parse queue are left to it.
+func (r *PostgresReparseRepository) NextChunk(ctx context.Context, job *entities.ReparseJob) ([]*entities.ReparseLine, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if job.ServerID != nil {
+		addCondition("r.server_id = $%d", *job.ServerID)
+	}
+	if job.From != nil {
+		addCondition("r.received_at >= $%d", job.From.UTC())
+	}
+	if job.To != nil {
+		addCondition("r.received_at < $%d", job.To.UTC())
+	}
+	if job.CursorReceivedAt != nil && job.CursorRawLogID != nil {
+		addCondition("(r.received_at, r.id) > ($%d, $%d)", job.CursorReceivedAt.UTC(), *job.CursorRawLogID)
+	}
+	if job.StaleOnly {
+		addCondition(`(
+			EXISTS (SELECT 1 FROM parsed_logs p WHERE p.raw_log_id = r.id AND p.`+derivedEventFilter+`
+				AND p.parser_version IS DISTINCT FROM $%[1]d)
+			OR EXISTS (SELECT 1 FROM failed_parses f WHERE f.raw_log_id = r.id AND f.resolved IS NOT TRUE
+				AND f.parser_version IS DISTINCT FROM $%[1]d)
+		)`, job.ParserVersion)
+	}
+	conditions = append(conditions, `NOT EXISTS (
+		SELECT 1 FROM parse_jobs j WHERE j.raw_log_id = r.id AND j.status IN ('pending', 'processing')
+	)`)
+
+	args = append(args, job.ChunkSize)
+	query := fmt.Sprintf(`
+		SELECT r.id, r.server_id, r.content, r.received_at,
+			ARRAY(
+				SELECT p.event_type FROM parsed_logs p
+				WHERE p.raw_log_id = r.id AND p.`+derivedEventFilter+`
+				UNION ALL
+				SELECT '%s' FROM failed_parses f WHERE f.raw_log_id = r.id AND f.resolved IS NOT TRUE
+			) AS event_types
+		FROM raw_logs r
+		WHERE %s
+		ORDER BY r.received_at, r.id
+		LIMIT $%d
+	`, entities.EventTypeFailed, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query reparse chunk: %w", err)
+	}
+	defer rows.Close()
+
+	lines := []*entities.ReparseLine{}
+	for rows.Next() {
+		var line entities.ReparseLine
+		var serverID sql.NullString
+		if err := rows.Scan(&line.RawLogID, &serverID, &line.Content, &line.ReceivedAt, pq.Array(&line.EventTypes)); err != nil {
+			return nil, fmt.Errorf("scan reparse line: %w", err)
+		}
+		line.ServerID = serverID.String
+		lines = append(lines, &line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query reparse chunk: %w", err)
+	}
+	return lines, nil
+}
+
+// ReplaceChunk replaces the parsed and failed rows of a chunk of reparsed
+// lines and saves the job's progress, in one transaction. Replacement rows
+// keep the session, round and phase of the rows they replace; a line that
+// failed before takes them from the event logged before it on its server.
+// last is the last line of the chunk and becomes the job's cursor.
+//
+// The job's cursor must not have moved since the chunk was read, so two
+// runners of the same job cannot both apply a chunk.
+func (r *PostgresReparseRepository) ReplaceChunk(ctx context.Context, job *entities.ReparseJob, lines []*entities.ReparsedLine, last *entities.ReparseLine) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, line := range lines {
+		if line.Skipped {
+			continue
+		}
+		if err := replaceDerivedRows(ctx, tx, job.ParserVersion, line); err != nil {
+			return err
+		}
+	}
+
+	changes, err := json.Marshal(job.EventTypeChanges)
+	if err != nil {
+		return fmt.Errorf("marshal event type changes: %w", err)
+	}
+	query := `
+		UPDATE reparse_jobs
+		SET cursor_received_at = $4, cursor_raw_log_id = $5,
+			processed_count = $6, parsed_count = $7, failed_count = $8,
+			skipped_count = $9, changed_count = $10, event_type_changes = $11,
+			updated_at = NOW()
+		WHERE id = $1
+			AND cursor_received_at IS NOT DISTINCT FROM $2
+			AND cursor_raw_log_id IS NOT DISTINCT FROM $3
+	`
+	result, err := tx.ExecContext(ctx, query,
+		job.ID, job.CursorReceivedAt, job.CursorRawLogID,
+		last.ReceivedAt, last.RawLogID,
+		job.Processed, job.Parsed, job.Failed, job.Skipped, job.Changed, changes,
+	)
+	if err != nil {
+		return fmt.Errorf("update reparse job progress: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("reparse job was advanced by another run")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit reparse chunk: %w", err)
+	}
+
+	receivedAt, rawLogID := last.ReceivedAt, last.RawLogID
+	job.CursorReceivedAt, job.CursorRawLogID = &receivedAt, &rawLogID
+	return nil
+}
+
+// eventContextRow is where in a match an event happened
+type eventContextRow struct {
+	SessionID   *string    `db:"session_id"`
+	RoundNumber *int       `db:"round_number"`
+	GamePhase   *string    `db:"game_phase"`
+	CreatedAt   *time.Time `db:"created_at"`
+}
+
+// replaceDerivedRows replaces the rows parsed from one raw log. Failed parses
+// that were resolved are kept.
+func replaceDerivedRows(ctx context.Context, tx *sqlx.Tx, parserVersion string, line *entities.ReparsedLine) error {
+	eventCtx, err := findEventContext(ctx, tx, line)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM parsed_logs WHERE raw_log_id = $1 AND `+derivedEventFilter, line.RawLogID); err != nil {
+		return fmt.Errorf("delete parsed logs: %w", err)
+	}
+	// The session's stored player totals are worked out again when next read
+	if eventCtx.SessionID != nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM session_player_stats WHERE session_id = $1`, *eventCtx.SessionID); err != nil {
+			return fmt.Errorf("delete session player totals: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM failed_parses WHERE raw_log_id = $1 AND resolved IS NOT TRUE`, line.RawLogID); err != nil {
+		return fmt.Errorf("delete failed parses: %w", err)
+	}
+
+	if line.Error != "" {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO failed_parses (raw_log_id, error_message, parser_version, created_at)
+			VALUES ($1, $2, $3, NOW())
+		`, line.RawLogID, line.Error, parserVersion)
+		if err != nil {
+			return fmt.Errorf("insert failed parse: %w", err)
+		}
+		return nil
+	}
+	_, err = insertParsedLine(ctx, tx, parserVersion, line, eventCtx)
+	return err
+}
+
+// findEventContext finds where in a match the event parsed from a raw log
+// happened: from the row it was parsed as before or, when there is none,
+// from the event logged before it on its server
+func findEventContext(ctx context.Context, tx *sqlx.Tx, line *entities.ReparsedLine) (eventContextRow, error) {
+	var eventCtx eventContextRow
+	err := tx.GetContext(ctx, &eventCtx, `
+		SELECT session_id, round_number, game_phase, created_at
+		FROM parsed_logs
+		WHERE raw_log_id = $1 AND `+derivedEventFilter+`
+		ORDER BY created_at
+		LIMIT 1
+	`, line.RawLogID)
+	if err == sql.ErrNoRows && line.EventTime != nil {
+		err = tx.GetContext(ctx, &eventCtx, `
+			SELECT session_id, round_number, game_phase, NULL AS created_at
+			FROM parsed_logs
+			WHERE server_id = $1 AND event_time <= $2
+			ORDER BY event_time DESC, created_at DESC
+			LIMIT 1
+		`, line.ServerID, line.EventTime)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return eventCtx, fmt.Errorf("query event context: %w", err)
+	}
+	return eventCtx, nil
+}
+
+// insertParsedLine stores the event parsed from a raw log, links it to its
+// players and returns the stored event
+func insertParsedLine(ctx context.Context, tx *sqlx.Tx, parserVersion string, line *entities.ReparsedLine, eventCtx eventContextRow) (*entities.ParsedLog, error) {
+	createdAt := time.Now()
+	if eventCtx.CreatedAt != nil {
+		createdAt = *eventCtx.CreatedAt
+	}
+	var parsedLogID string
+	err := tx.QueryRowxContext(ctx, `
+		INSERT INTO parsed_logs (raw_log_id, server_id, event_type, event_data, session_id,
+			round_number, game_phase, event_time, parser_version, classification_source,
+			classification_confidence, parse_rule, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE(NULLIF($10, ''), 'parser'), $11,
+			NULLIF($12, ''), $13)
+		RETURNING id
+	`, line.RawLogID, line.ServerID, line.EventType, line.EventData, eventCtx.SessionID,
+		eventCtx.RoundNumber, eventCtx.GamePhase, line.EventTime, parserVersion, line.Source,
+		line.Confidence, line.Rule, createdAt,
+	).Scan(&parsedLogID)
+	if err != nil {
+		return nil, fmt.Errorf("insert parsed log: %w", err)
+	}
+
+	if len(line.Players) > 0 {
+		at := createdAt
+		if line.EventTime != nil {
+			at = *line.EventTime
+		}
+		if err := recordSightings(ctx, tx, parsedLogID, line.ServerID, line.Players, at); err != nil {
+			return nil, err
+		}
+	}
+
+	stored := &entities.ParsedLog{
+		ID:                   parsedLogID,
+		RawLogID:             line.RawLogID,
+		ServerID:             line.ServerID,
+		EventType:            line.EventType,
+		EventTime:            line.EventTime,
+		RoundNumber:          eventCtx.RoundNumber,
+		GamePhase:            eventCtx.GamePhase,
+		CreatedAt:            createdAt,
+		ParserVersion:        parserVersion,
+		ClassificationSource: line.Source,
+		Confidence:           line.Confidence,
+		ParseRule:            line.Rule,
+	}
+	if eventCtx.SessionID != nil {
+		stored.SessionID = *eventCtx.SessionID
+	}
+	if stored.ClassificationSource == "" {
+		stored.ClassificationSource = entities.ClassificationParser
+	}
+	eventData, err := decodeEventData([]byte(line.EventData))
+	if err != nil {
+		return nil, fmt.Errorf("decode event data: %w", err)
+	}
+	stored.EventData = eventData
+	return stored, nil
+}
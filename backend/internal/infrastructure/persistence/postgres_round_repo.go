@@ -0,0 +1,73 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// PostgresRoundRepository stores the finished rounds of each session
+type PostgresRoundRepository struct {
+	db *sqlx.DB
+}
+
+// NewPostgresRoundRepository creates a new PostgreSQL round repository
+func NewPostgresRoundRepository(db *sqlx.DB) *PostgresRoundRepository {
+	return &PostgresRoundRepository{db: db}
+}
+
+// Save stores a finished round. A round replayed after a backup restore
+// replaces the earlier row for the same session and round number.
+func (r *PostgresRoundRepository) Save(ctx context.Context, round *entities.Round) error {
+	query := `
+		INSERT INTO rounds (server_id, session_id, round_number, game_phase, winner, win_reason,
+			started_at, ended_at, duration_ms, score_ct, score_t)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (session_id, round_number) DO UPDATE SET
+			game_phase = EXCLUDED.game_phase,
+			winner = EXCLUDED.winner,
+			win_reason = EXCLUDED.win_reason,
+			started_at = EXCLUDED.started_at,
+			ended_at = EXCLUDED.ended_at,
+			duration_ms = EXCLUDED.duration_ms,
+			score_ct = EXCLUDED.score_ct,
+			score_t = EXCLUDED.score_t
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowxContext(ctx, query,
+		round.ServerID, round.SessionID, round.RoundNumber, string(round.GamePhase),
+		round.Winner, round.WinReason, round.StartedAt, round.EndedAt, round.DurationMs,
+		round.ScoreCT, round.ScoreT,
+	).Scan(&round.ID, &round.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("save round: %w", err)
+	}
+	return nil
+}
+
+// FindLatestBySession finds the highest numbered round of a session
+func (r *PostgresRoundRepository) FindLatestBySession(ctx context.Context, sessionID string) (*entities.Round, error) {
+	var round entities.Round
+	query := `SELECT * FROM rounds WHERE session_id = $1 ORDER BY round_number DESC LIMIT 1`
+	err := r.db.GetContext(ctx, &round, query, sessionID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("round not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query latest round: %w", err)
+	}
+	return &round, nil
+}
+
+// ListBySession lists the rounds of a session in order
+func (r *PostgresRoundRepository) ListBySession(ctx context.Context, sessionID string) ([]*entities.Round, error) {
+	rounds := []*entities.Round{}
+	query := `SELECT * FROM rounds WHERE session_id = $1 ORDER BY round_number`
+	if err := r.db.SelectContext(ctx, &rounds, query, sessionID); err != nil {
+		return nil, fmt.Errorf("query rounds: %w", err)
+	}
+	return rounds, nil
+}
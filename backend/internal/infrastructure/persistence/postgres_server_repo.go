@@ -42,13 +42,16 @@ func (r *PostgresServerRepository) Create(ctx context.Context, server *entities.
 	server.UpdatedAt = time.Now()
 
 	query := `
-		INSERT INTO servers (id, name, ip_address, api_key, description, is_active, created_by, created_at, updated_at, last_seen)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO servers (id, name, ip_address, api_key, description, is_active, created_by, created_at, updated_at, last_seen, log_secret,
+			rate_limit_per_minute, rate_limit_burst, require_api_key, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 	`
 	_, err := r.db.ExecContext(ctx, query,
 		server.ID, server.Name, server.IPAddress, server.APIKey,
 		server.Description, server.IsActive, server.CreatedBy,
 		server.CreatedAt, server.UpdatedAt, server.CreatedAt,
+		server.LogSecret, server.RateLimitPerMinute, server.RateLimitBurst,
+		server.RequireAPIKey, server.Timezone,
 	)
 	return err
 }
@@ -78,17 +81,52 @@ func (r *PostgresServerRepository) FindByAPIKey(ctx context.Context, apiKey stri
 	return &server, err
 }
 
+// FindByLogSecret finds an active server by its sv_logsecret
+func (r *PostgresServerRepository) FindByLogSecret(ctx context.Context, secret string) (*entities.Server, error) {
+	var server entities.Server
+	query := `SELECT * FROM servers WHERE log_secret = $1 AND is_active = true`
+	err := r.db.GetContext(ctx, &server, query, secret)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("server not found or inactive")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query server: %w", err)
+	}
+	return &server, nil
+}
+
+// FindByIPAddress finds the single active server registered for an IP address.
+// It fails if several active servers share the address, since the sender
+// cannot be told apart without a log secret.
+func (r *PostgresServerRepository) FindByIPAddress(ctx context.Context, ipAddress string) (*entities.Server, error) {
+	var servers []*entities.Server
+	query := `SELECT * FROM servers WHERE ip_address = $1 AND is_active = true LIMIT 2`
+	if err := r.db.SelectContext(ctx, &servers, query, ipAddress); err != nil {
+		return nil, fmt.Errorf("query server: %w", err)
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("server not found or inactive")
+	}
+	if len(servers) > 1 {
+		return nil, fmt.Errorf("multiple servers share address %s", ipAddress)
+	}
+	return servers[0], nil
+}
+
 // Update updates a server
 func (r *PostgresServerRepository) Update(ctx context.Context, server *entities.Server) error {
 	server.UpdatedAt = time.Now()
 	query := `
 		UPDATE servers 
-		SET name = $2, ip_address = $3, description = $4, is_active = $5, updated_at = $6
+		SET name = $2, ip_address = $3, description = $4, is_active = $5, updated_at = $6, log_secret = $7,
+			rate_limit_per_minute = $8, rate_limit_burst = $9, require_api_key = $10, timezone = $11
 		WHERE id = $1
 	`
 	_, err := r.db.ExecContext(ctx, query,
 		server.ID, server.Name, server.IPAddress,
 		server.Description, server.IsActive, server.UpdatedAt,
+		server.LogSecret, server.RateLimitPerMinute, server.RateLimitBurst,
+		server.RequireAPIKey, server.Timezone,
 	)
 	return err
 }
@@ -124,21 +162,59 @@ func (r *PostgresServerRepository) List(ctx context.Context, limit, offset int)
 	return servers, err
 }
 
-// RegenerateAPIKey generates a new API key for a server
-func (r *PostgresServerRepository) RegenerateAPIKey(ctx context.Context, serverID string) (string, error) {
+// RegenerateAPIKey generates a new API key for a server. The current key
+// stays valid for the grace period so senders can be moved to the new key;
+// a zero grace period revokes it immediately. It returns the new key and
+// when the previous one expires (nil when it was revoked).
+func (r *PostgresServerRepository) RegenerateAPIKey(ctx context.Context, serverID string, grace time.Duration) (string, *time.Time, error) {
 	var apiKey string
 	err := r.db.GetContext(ctx, &apiKey, "SELECT generate_api_key()")
 	if err != nil {
-		return "", fmt.Errorf("generate api key: %w", err)
+		return "", nil, fmt.Errorf("generate api key: %w", err)
+	}
+
+	now := time.Now()
+	var previousExpires *time.Time
+	if grace > 0 {
+		expires := now.Add(grace)
+		previousExpires = &expires
 	}
 
-	query := `UPDATE servers SET api_key = $2, updated_at = $3 WHERE id = $1`
-	_, err = r.db.ExecContext(ctx, query, serverID, apiKey, time.Now())
+	query := `
+		UPDATE servers
+		SET previous_api_key = CASE WHEN $4::timestamp IS NULL THEN NULL ELSE api_key END,
+			previous_api_key_expires_at = $4,
+			api_key = $2, updated_at = $3
+		WHERE id = $1
+	`
+	result, err := r.db.ExecContext(ctx, query, serverID, apiKey, now, previousExpires)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return "", nil, fmt.Errorf("server not found")
+	}
+
+	return apiKey, previousExpires, nil
+}
 
-	return apiKey, nil
+// RegenerateSigningSecret generates a new request signing secret for a
+// server, replacing the previous one
+func (r *PostgresServerRepository) RegenerateSigningSecret(ctx context.Context, serverID string) (string, error) {
+	var secret string
+	query := `
+		UPDATE servers SET signing_secret = encode(gen_random_bytes(32), 'hex'), updated_at = $2
+		WHERE id = $1
+		RETURNING signing_secret
+	`
+	err := r.db.GetContext(ctx, &secret, query, serverID, time.Now())
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("server not found")
+	}
+	if err != nil {
+		return "", fmt.Errorf("generate signing secret: %w", err)
+	}
+	return secret, nil
 }
 
 // ValidateServerExists checks if a server exists and is active
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+// GetBatch returns the processing status of an ingestion batch, so a sender
+// can confirm every line was handled before discarding its copy
+func GetBatch(batchRepo *persistence.PostgresIngestBatchRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		batchID := c.Param("id")
+		if _, err := uuid.Parse(batchID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batch ID"})
+			return
+		}
+
+		batch, err := batchRepo.FindByID(c.Request.Context(), batchID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"batch_id":      batch.ID,
+			"server_id":     batch.ServerID,
+			"saved_count":   batch.SavedCount,
+			"parsed_count":  batch.ParsedCount,
+			"failed_count":  batch.FailedCount,
+			"pending_count": batch.PendingCount(),
+			"complete":      batch.IsComplete(),
+			"created_at":    batch.CreatedAt,
+			"completed_at":  batch.CompletedAt,
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+)
+
+// RetryFailedParse queues a failed parse to be retried as soon as possible
+// and returns its position in the retry queue
+func RetryFailedParse(retryService *services.FailedParseRetryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		failedID := c.Param("id")
+		if _, err := uuid.Parse(failedID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid failed parse ID"})
+			return
+		}
+
+		position, err := retryService.RequestRetry(c.Request.Context(), failedID)
+		if err != nil {
+			switch err.Error() {
+			case "failed parse not found":
+				c.JSON(http.StatusNotFound, gin.H{"error": "Failed parse not found"})
+			case "failed parse already resolved":
+				c.JSON(http.StatusConflict, gin.H{"error": "Failed parse already resolved"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue retry"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"retrying":       true,
+			"queue_position": position,
+		})
+	}
+}
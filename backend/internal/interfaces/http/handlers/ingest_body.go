@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// errBodyTooLarge is returned when a body decompresses past the size limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// gameTimestampLayout is the timestamp format that starts a game log line
+const gameTimestampLayout = "01/02/2006 - 15:04:05.000"
+
+// ingestRecord is a line sent as a JSON object; Ts is used to stamp lines
+// that do not carry a game timestamp of their own
+type ingestRecord struct {
+	Line string          `json:"line"`
+	Ts   json.RawMessage `json:"ts"`
+}
+
+// decodeIngestBody decompresses the body according to contentEncoding and
+// splits it into log lines according to contentType. At most maxBytes are
+// read after decompression. Lines stamped from a ts get the wall time in
+// location, the timezone their server's log clock runs in.
+func decodeIngestBody(body io.Reader, contentEncoding, contentType string, maxBytes int64, location *time.Location) ([]string, error) {
+	reader, err := decompressBody(body, contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errBodyTooLarge
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		return decodeJSONLines(data, location)
+	case "application/x-ndjson", "application/ndjson":
+		return decodeNDJSONLines(data, location)
+	default:
+		return strings.Split(string(data), "\n"), nil
+	}
+}
+
+// decompressBody wraps the body in a decoder for the given Content-Encoding
+func decompressBody(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return io.NopCloser(body), nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return reader, nil
+	case "zstd":
+		// 8 MiB is the largest window RFC 8878 expects decoders to accept;
+		// the decompressed size itself is limited by the caller
+		decoder, err := zstd.NewReader(body,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(8<<20),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+}
+
+// decodeJSONLines decodes a JSON array whose elements are lines or
+// {line, ts} objects
+func decodeJSONLines(data []byte, location *time.Location) ([]string, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid JSON array: %w", err)
+	}
+
+	lines := make([]string, 0, len(items))
+	for i, item := range items {
+		line, err := decodeJSONLine(item, location)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// decodeNDJSONLines decodes one {line, ts} object per line
+func decodeNDJSONLines(data []byte, location *time.Location) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for n := 1; scanner.Scan(); n++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		line, err := decodeJSONLine(raw, location)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read NDJSON: %w", err)
+	}
+	return lines, nil
+}
+
+// decodeJSONLine decodes a JSON string or {line, ts} object into a log line.
+// The ts stamp is written as wall time in location, since the parser reads
+// log timestamps in the server's timezone.
+func decodeJSONLine(raw json.RawMessage, location *time.Location) (string, error) {
+	var line string
+	if err := json.Unmarshal(raw, &line); err == nil {
+		return line, nil
+	}
+
+	var record ingestRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return "", fmt.Errorf("expected a string or {line, ts} object")
+	}
+	if len(record.Ts) == 0 || strings.HasPrefix(strings.TrimSpace(record.Line), "L ") {
+		return record.Line, nil
+	}
+
+	ts, err := parseRecordTime(record.Ts)
+	if err != nil {
+		return "", err
+	}
+	return "L " + ts.In(location).Format(gameTimestampLayout) + ": " + record.Line, nil
+}
+
+// serverLocation returns the timezone of a server's log clock, UTC unless
+// it has a valid one configured
+func serverLocation(server *entities.Server) *time.Location {
+	if server == nil || server.Timezone == nil || *server.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(*server.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// parseRecordTime accepts an RFC 3339 string or Unix seconds
+func parseRecordTime(raw json.RawMessage) (time.Time, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		ts, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid ts %q: expected RFC 3339", text)
+		}
+		return ts, nil
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(raw, &seconds); err != nil {
+		return time.Time{}, fmt.Errorf("invalid ts: expected RFC 3339 string or Unix seconds")
+	}
+	return time.UnixMilli(int64(seconds * 1000)), nil
+}
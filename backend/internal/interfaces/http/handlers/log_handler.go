@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,73 +12,123 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/noueii/nocs-log-saver/internal/application/commands"
 	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
 )
 
-// HandleLogIngestion handles incoming CS2 server logs
-func HandleLogIngestion(db *sqlx.DB) gin.HandlerFunc {
-	// Create a single stateful parser instance to be reused across requests
-	// This allows it to maintain state for multi-line JSON assembly
-	statefulParser := services.NewStatefulParserService(db)
-	
-	// Start a cleanup goroutine to remove stale buffers
-	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-		for range ticker.C {
-			statefulParser.CleanupOldBuffers(10 * time.Minute)
-		}
-	}()
-	
+// HandleLogIngestion handles incoming CS2 server logs. Bodies may be plain
+// text, a JSON array or NDJSON, optionally gzip or zstd compressed, and are
+// limited to maxBodyBytes after decompression.
+func HandleLogIngestion(ingestLog *commands.IngestLogHandler, maxBodyBytes int64) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		serverID := c.GetString("server_id") // Set by middleware
 		clientIP := c.GetString("client_ip") // Set by middleware
 
-		// Read request body
-		body, err := io.ReadAll(c.Request.Body)
-		if err != nil {
+		// Retries carrying the same key return the original batch
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if len(idempotencyKey) > 255 {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Failed to read request body",
+				"error": "Idempotency-Key must be at most 255 characters",
 			})
 			return
 		}
 
-		// Convert body to string and split by lines
-		content := string(body)
-		lines := strings.Split(content, "\n")
-
-		// Process each log line
-		var savedCount int
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
+		// Decompress and split the body into lines; ts stamps are written in
+		// the server's timezone
+		value, _ := c.Get("server") // Set by middleware
+		server, _ := value.(*entities.Server)
+		lines, err := decodeIngestBody(c.Request.Body,
+			c.GetHeader("Content-Encoding"), c.GetHeader("Content-Type"), maxBodyBytes,
+			serverLocation(server))
+		if errors.Is(err, errBodyTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":     "Request body too large",
+				"max_bytes": maxBodyBytes,
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Failed to read request body: %v", err),
+			})
+			return
+		}
 
-			// Save raw log and get ID
-			logID := uuid.New().String()
-			if err := saveRawLogWithID(db, logID, serverID, line); err != nil {
-				// Log error but continue processing other lines
-				continue
-			}
-			savedCount++
-			
-			// Parse log using stateful parser (handles multi-line JSON)
-			go statefulParser.ParseAndStore(logID, serverID, line)
+		// Save each log line and queue it for parsing; once this returns the
+		// lines are durable and will be parsed even across restarts
+		batch, err := ingestLog.Handle(c.Request.Context(), commands.IngestLogCommand{
+			ServerID:       serverID,
+			Lines:          lines,
+			IdempotencyKey: idempotencyKey,
+			ClientIP:       clientIP,
+		})
+		if errors.Is(err, commands.ErrIPNotWhitelisted) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "IP not whitelisted",
+				"ip":    clientIP,
+			})
+			return
+		}
+		if errors.Is(err, services.ErrQueueFull) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": "Ingestion queue full, retry later",
+			})
+			return
+		}
+		if errors.Is(err, services.ErrPipelineClosed) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Server is shutting down",
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to store logs",
+			})
+			return
 		}
 
-		// Update server last seen
-		updateServerLastSeen(db, serverID, clientIP)
+		// A body without any lines creates no batch
+		var batchID *string
+		var savedCount, duplicateCount int
+		replayed := false
+		if batch != nil {
+			batchID = &batch.ID
+			savedCount = batch.SavedCount
+			duplicateCount = batch.DuplicateCount
+			replayed = batch.Replayed
+		}
+		if replayed {
+			// Nothing from this request was saved
+			savedCount = 0
+			duplicateCount = countLines(lines)
+		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"received":    true,
-			"line_count":  savedCount,
-			"server_id":   serverID,
-			"timestamp":   time.Now().Unix(),
+			"received":        true,
+			"batch_id":        batchID,
+			"line_count":      savedCount,
+			"duplicate_count": duplicateCount,
+			"replayed":        replayed,
+			"server_id":       serverID,
+			"timestamp":       time.Now().Unix(),
 		})
 	}
 }
 
+// countLines counts the non-empty lines of a request body
+func countLines(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count
+}
+
 // saveRawLog saves a single log line to the database
 func saveRawLog(db *sqlx.DB, serverID, content string) error {
 	query := `
@@ -96,36 +146,6 @@ func saveRawLog(db *sqlx.DB, serverID, content string) error {
 	return err
 }
 
-// saveRawLogWithID saves a single log line with a specific ID
-func saveRawLogWithID(db *sqlx.DB, id, serverID, content string) error {
-	query := `
-		INSERT INTO raw_logs (id, server_id, content, received_at)
-		VALUES ($1, $2, $3, $4)
-	`
-	
-	_, err := db.Exec(query, id, serverID, content, time.Now())
-	return err
-}
-
-// updateServerLastSeen updates or creates server record
-func updateServerLastSeen(db *sqlx.DB, serverID, ipAddress string) error {
-	query := `
-		INSERT INTO servers (id, ip_address, last_seen, created_at)
-		VALUES ($1, $2, $3, $4)
-		ON CONFLICT (id) DO UPDATE
-		SET ip_address = $2, last_seen = $3
-	`
-	
-	_, err := db.Exec(query,
-		serverID,
-		ipAddress,
-		time.Now(),
-		time.Now(),
-	)
-	
-	return err
-}
-
 // GetLogs handles fetching logs from the database
 func GetLogs(db *sqlx.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -150,11 +170,30 @@ func GetLogs(db *sqlx.DB) gin.HandlerFunc {
 			offset = o
 		}
 		
+		// Parsed logs can be ordered and filtered by in-game event time
+		timeFilter, err := parseEventTimeFilter(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		
+		// Parsed logs can be filtered to the events of one player, by any
+		// form of SteamID
+		var steamID64 string
+		if steamID := c.Query("steam_id"); steamID != "" {
+			accountID, ok := entities.ParseSteamID(steamID)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid steam_id"})
+				return
+			}
+			steamID64 = entities.SteamID64(accountID)
+		}
+		
 		var logs []gin.H
 		
 		switch logType {
 		case "parsed":
-			logs = getParsedLogsWithEventType(db, serverID, eventType, limit, offset)
+			logs = getParsedLogsWithEventType(db, serverID, eventType, steamID64, timeFilter, limit, offset)
 		case "failed":
 			logs = getFailedLogs(db, serverID, limit, offset)
 		default: // "raw" or empty
@@ -246,7 +285,43 @@ func getRawLogs(db *sqlx.DB, serverID string, limit, offset int) []gin.H {
 	return logs
 }
 
-func getParsedLogsWithEventType(db *sqlx.DB, serverID, eventType string, limit, offset int) []gin.H {
+// eventTimeFilter selects and orders parsed logs by event_time
+type eventTimeFilter struct {
+	SortByEventTime bool
+	Ascending       bool
+	From            *time.Time
+	To              *time.Time
+}
+
+// parseEventTimeFilter reads the sort, order, from and to query parameters
+func parseEventTimeFilter(c *gin.Context) (eventTimeFilter, error) {
+	filter := eventTimeFilter{
+		SortByEventTime: c.Query("sort") == "event_time",
+		Ascending:       c.Query("order") == "asc",
+	}
+	
+	for _, param := range []struct {
+		name   string
+		target **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: expected RFC 3339 time", param.name)
+		}
+		*param.target = &t
+	}
+	
+	return filter, nil
+}
+
+func getParsedLogsWithEventType(db *sqlx.DB, serverID, eventType, steamID64 string, timeFilter eventTimeFilter, limit, offset int) []gin.H {
 	var query string
 	var args []interface{}
 	
@@ -266,19 +341,49 @@ func getParsedLogsWithEventType(db *sqlx.DB, serverID, eventType string, limit,
 		argIndex++
 	}
 	
+	if steamID64 != "" {
+		whereConditions = append(whereConditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM parsed_log_players lp WHERE lp.parsed_log_id = p.id AND lp.steam_id64 = $%d)", argIndex))
+		args = append(args, steamID64)
+		argIndex++
+	}
+	
+	if timeFilter.From != nil {
+		whereConditions = append(whereConditions, fmt.Sprintf("p.event_time >= $%d", argIndex))
+		args = append(args, *timeFilter.From)
+		argIndex++
+	}
+	
+	if timeFilter.To != nil {
+		whereConditions = append(whereConditions, fmt.Sprintf("p.event_time < $%d", argIndex))
+		args = append(args, *timeFilter.To)
+		argIndex++
+	}
+	
 	whereClause := ""
 	if len(whereConditions) > 0 {
 		whereClause = "WHERE " + strings.Join(whereConditions, " AND ")
 	}
 	
+	direction := "DESC"
+	if timeFilter.Ascending {
+		direction = "ASC"
+	}
+	orderBy := "p.created_at " + direction
+	if timeFilter.SortByEventTime {
+		// Lines without a game timestamp sort last either way
+		orderBy = fmt.Sprintf("p.event_time %s NULLS LAST, p.created_at %s", direction, direction)
+	}
+	
 	query = fmt.Sprintf(`
-		SELECT p.id, p.server_id, p.event_type, p.event_data, p.created_at, r.content
+		SELECT p.id, p.server_id, p.event_type, p.event_data, p.round_number, p.game_phase,
+			p.event_time, p.classification_source, p.classification_confidence, p.created_at, r.content
 		FROM parsed_logs p
 		JOIN raw_logs r ON p.raw_log_id = r.id
 		%s
-		ORDER BY p.created_at DESC
+		ORDER BY %s
 		LIMIT $%d OFFSET $%d
-	`, whereClause, argIndex, argIndex+1)
+	`, whereClause, orderBy, argIndex, argIndex+1)
 	
 	args = append(args, limit, offset)
 	
@@ -291,26 +396,43 @@ func getParsedLogsWithEventType(db *sqlx.DB, serverID, eventType string, limit,
 	var logs []gin.H
 	for rows.Next() {
 		var log struct {
-			ID        string          `json:"id"`
-			ServerID  string          `json:"server_id"`
-			EventType string          `json:"event_type"`
-			EventData json.RawMessage `json:"event_data"`
-			CreatedAt time.Time       `json:"created_at"`
-			Content   string          `json:"content"`
+			ID          string          `json:"id"`
+			ServerID    string          `json:"server_id"`
+			EventType   string          `json:"event_type"`
+			EventData   json.RawMessage `json:"event_data"`
+			RoundNumber *int            `json:"round_number"`
+			GamePhase   *string         `json:"game_phase"`
+			EventTime   *time.Time      `json:"event_time"`
+			Source      string          `json:"classification_source"`
+			Confidence  *float64        `json:"classification_confidence"`
+			CreatedAt   time.Time       `json:"created_at"`
+			Content     string          `json:"content"`
 		}
 		
-		if err := rows.Scan(&log.ID, &log.ServerID, &log.EventType, &log.EventData, &log.CreatedAt, &log.Content); err != nil {
+		if err := rows.Scan(&log.ID, &log.ServerID, &log.EventType, &log.EventData, &log.RoundNumber, &log.GamePhase,
+			&log.EventTime, &log.Source, &log.Confidence, &log.CreatedAt, &log.Content); err != nil {
 			continue
 		}
 		
+		var eventTime *string
+		if log.EventTime != nil {
+			formatted := log.EventTime.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+			eventTime = &formatted
+		}
+		
 		logs = append(logs, gin.H{
-			"id":         log.ID,
-			"server_id":  log.ServerID,
-			"content":    log.Content,
-			"event_type": log.EventType,
-			"event_data": log.EventData,
-			"created_at": log.CreatedAt.Format(time.RFC3339),
-			"type":       "parsed",
+			"id":                        log.ID,
+			"server_id":                 log.ServerID,
+			"content":                   log.Content,
+			"event_type":                log.EventType,
+			"event_data":                log.EventData,
+			"round_number":              log.RoundNumber,
+			"game_phase":                log.GamePhase,
+			"event_time":                eventTime,
+			"classification_source":     log.Source,
+			"classification_confidence": log.Confidence,
+			"created_at":                log.CreatedAt.Format(time.RFC3339),
+			"type":                      "parsed",
 		})
 	}
 	
@@ -326,7 +448,8 @@ func getFailedLogs(db *sqlx.DB, serverID string, limit, offset int) []gin.H {
 	
 	if serverID != "" {
 		query = `
-			SELECT f.id, r.server_id, r.content, f.error_message, f.created_at
+			SELECT f.id, r.server_id, r.content, f.error_message, COALESCE(f.retry_count, 0),
+				COALESCE(f.resolved, false), f.created_at
 			FROM failed_parses f
 			JOIN raw_logs r ON f.raw_log_id = r.id
 			WHERE r.server_id = $1
@@ -336,7 +459,8 @@ func getFailedLogs(db *sqlx.DB, serverID string, limit, offset int) []gin.H {
 		args = []interface{}{serverID, limit, offset}
 	} else {
 		query = `
-			SELECT f.id, r.server_id, r.content, f.error_message, f.created_at
+			SELECT f.id, r.server_id, r.content, f.error_message, COALESCE(f.retry_count, 0),
+				COALESCE(f.resolved, false), f.created_at
 			FROM failed_parses f
 			JOIN raw_logs r ON f.raw_log_id = r.id
 			ORDER BY f.created_at DESC
@@ -358,10 +482,12 @@ func getFailedLogs(db *sqlx.DB, serverID string, limit, offset int) []gin.H {
 			ServerID     string    `json:"server_id"`
 			Content      string    `json:"content"`
 			ErrorMessage string    `json:"error_message"`
+			RetryCount   int       `json:"retry_count"`
+			Resolved     bool      `json:"resolved"`
 			CreatedAt    time.Time `json:"created_at"`
 		}
 		
-		if err := rows.Scan(&log.ID, &log.ServerID, &log.Content, &log.ErrorMessage, &log.CreatedAt); err != nil {
+		if err := rows.Scan(&log.ID, &log.ServerID, &log.Content, &log.ErrorMessage, &log.RetryCount, &log.Resolved, &log.CreatedAt); err != nil {
 			continue
 		}
 		
@@ -370,6 +496,8 @@ func getFailedLogs(db *sqlx.DB, serverID string, limit, offset int) []gin.H {
 			"server_id":    log.ServerID,
 			"content":      log.Content,
 			"error_message": log.ErrorMessage,
+			"retry_count":  log.RetryCount,
+			"resolved":     log.Resolved,
 			"created_at":   log.CreatedAt.Format(time.RFC3339),
 			"type":         "failed",
 		})
@@ -391,7 +519,8 @@ func GetEventTypes(db *sqlx.DB) gin.HandlerFunc {
 		
 		if serverID != "" {
 			query = `
-				SELECT DISTINCT event_type, COUNT(*) as count
+				SELECT event_type, COUNT(*) as count,
+					COUNT(*) FILTER (WHERE classification_source = 'heuristic') as heuristic_count
 				FROM parsed_logs
 				WHERE server_id = $1
 				GROUP BY event_type
@@ -400,7 +529,8 @@ func GetEventTypes(db *sqlx.DB) gin.HandlerFunc {
 			args = []interface{}{serverID}
 		} else {
 			query = `
-				SELECT DISTINCT event_type, COUNT(*) as count
+				SELECT event_type, COUNT(*) as count,
+					COUNT(*) FILTER (WHERE classification_source = 'heuristic') as heuristic_count
 				FROM parsed_logs
 				GROUP BY event_type
 				ORDER BY count DESC
@@ -419,14 +549,16 @@ func GetEventTypes(db *sqlx.DB) gin.HandlerFunc {
 		var eventTypes []gin.H
 		for rows.Next() {
 			var eventType string
-			var count int
-			if err := rows.Scan(&eventType, &count); err != nil {
+			var count, heuristicCount int
+			if err := rows.Scan(&eventType, &count, &heuristicCount); err != nil {
 				continue
 			}
 			
+			// heuristic_count of the events were guessed rather than parsed
 			eventTypes = append(eventTypes, gin.H{
-				"type":  eventType,
-				"count": count,
+				"type":            eventType,
+				"count":           count,
+				"heuristic_count": heuristicCount,
 			})
 		}
 		
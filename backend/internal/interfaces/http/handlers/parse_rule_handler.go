@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// ParseRuleHandler handles the admin endpoints for custom parse rules
+type ParseRuleHandler struct {
+	rules *services.ParseRuleService
+}
+
+// NewParseRuleHandler creates a new parse rule handler
+func NewParseRuleHandler(rules *services.ParseRuleService) *ParseRuleHandler {
+	return &ParseRuleHandler{rules: rules}
+}
+
+// ParseRuleRequest is a rule to create, update or dry-run. Rules run after
+// cs2-log unless stage is "before", and are enabled unless enabled is false.
+type ParseRuleRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Description string            `json:"description"`
+	Pattern     string            `json:"pattern" binding:"required"`
+	EventType   string            `json:"event_type" binding:"required"`
+	Stage       string            `json:"stage"`
+	Priority    int               `json:"priority"`
+	FieldTypes  map[string]string `json:"field_types"`
+	Enabled     *bool             `json:"enabled"`
+}
+
+func (req *ParseRuleRequest) toEntity() *entities.ParseRule {
+	rule := &entities.ParseRule{
+		Name:        req.Name,
+		Description: req.Description,
+		Pattern:     req.Pattern,
+		EventType:   req.EventType,
+		Stage:       entities.ParseRuleStage(req.Stage),
+		Priority:    req.Priority,
+		FieldTypes:  req.FieldTypes,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+	}
+	if rule.Stage == "" {
+		rule.Stage = entities.ParseRuleAfter
+	}
+	return rule
+}
+
+// DryRunParseRuleRequest runs a rule against the most recent raw logs of a
+// server and receive time range
+type DryRunParseRuleRequest struct {
+	Rule     ParseRuleRequest `json:"rule" binding:"required"`
+	RuleID   string           `json:"rule_id"` // the stored rule the dry run replaces
+	ServerID string           `json:"server_id"`
+	From     *time.Time       `json:"from"`
+	To       *time.Time       `json:"to"`
+	Limit    int              `json:"limit"`
+}
+
+// List lists the rules of the rules file and the database
+func (h *ParseRuleHandler) List(c *gin.Context) {
+	rules, err := h.rules.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list parse rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// Get gets a rule
+func (h *ParseRuleHandler) Get(c *gin.Context) {
+	ruleID := c.Param("id")
+	if _, err := uuid.Parse(ruleID); err != nil && !strings.HasPrefix(ruleID, "file:") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	rule, err := h.rules.Get(c.Request.Context(), ruleID)
+	if err != nil {
+		h.respondError(c, err, "Failed to get parse rule")
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// Create creates a rule; it applies to lines parsed from then on
+func (h *ParseRuleHandler) Create(c *gin.Context) {
+	var req ParseRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := req.toEntity()
+	rule.CreatedBy = c.GetString("username")
+	if err := h.rules.Create(c.Request.Context(), rule); err != nil {
+		h.respondError(c, err, "Failed to create parse rule")
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// Update replaces a stored rule. Rules of the rules file are read-only.
+func (h *ParseRuleHandler) Update(c *gin.Context) {
+	ruleID, ok := storedRuleID(c)
+	if !ok {
+		return
+	}
+
+	var req ParseRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := req.toEntity()
+	rule.ID = ruleID
+	if err := h.rules.Update(c.Request.Context(), rule); err != nil {
+		h.respondError(c, err, "Failed to update parse rule")
+		return
+	}
+
+	updated, err := h.rules.Get(c.Request.Context(), ruleID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get parse rule"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete deletes a stored rule
+func (h *ParseRuleHandler) Delete(c *gin.Context) {
+	ruleID, ok := storedRuleID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.rules.Delete(c.Request.Context(), ruleID); err != nil {
+		h.respondError(c, err, "Failed to delete parse rule")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Parse rule deleted successfully"})
+}
+
+// Reload reloads the rules from the rules file and the database
+func (h *ParseRuleHandler) Reload(c *gin.Context) {
+	if err := h.rules.Reload(c.Request.Context()); err != nil {
+		if errors.Is(err, services.ErrInvalidParseRule) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload parse rules"})
+		}
+		return
+	}
+
+	rules, err := h.rules.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list parse rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// DryRun runs a rule against stored raw logs without storing anything and
+// reports how many lines it matches and how their event types would change
+func (h *ParseRuleHandler) DryRun(c *gin.Context) {
+	var req DryRunParseRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	rule := req.Rule.toEntity()
+	rule.ID = req.RuleID
+	result, err := h.rules.DryRun(c.Request.Context(), rule, req.ServerID, req.From, req.To, req.Limit)
+	if err != nil {
+		h.respondError(c, err, "Failed to dry-run parse rule")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// storedRuleID reads the ID of a rule stored in the database
+func storedRuleID(c *gin.Context) (string, bool) {
+	ruleID := c.Param("id")
+	if _, err := uuid.Parse(ruleID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID; rules of the rules file are read-only"})
+		return "", false
+	}
+	return ruleID, true
+}
+
+// respondError maps parse rule errors to responses
+func (h *ParseRuleHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidParseRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err.Error() == "parse rule not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Parse rule not found"})
+	case err.Error() == "parse rule name already exists":
+		c.JSON(http.StatusConflict, gin.H{"error": "Parse rule name already exists"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
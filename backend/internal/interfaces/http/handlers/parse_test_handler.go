@@ -32,8 +32,9 @@ type ParseTestResponse struct {
 	Results      []ParseTestResult  `json:"results"`
 }
 
-// HandleParseTest handles testing log parsing without saving to database
-func HandleParseTest(db *sqlx.DB) gin.HandlerFunc {
+// HandleParseTest handles testing log parsing without saving to database.
+// Lines are classified with the loaded parse rules.
+func HandleParseTest(db *sqlx.DB, rules *services.ParseRuleEngine) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ParseTestRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -49,6 +50,7 @@ func HandleParseTest(db *sqlx.DB) gin.HandlerFunc {
 		
 		// Create parser service
 		parserService := services.NewParserService(db)
+		parserService.UseRules(rules)
 		
 		// Process each line
 		var results []ParseTestResult
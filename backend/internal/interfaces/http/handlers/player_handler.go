@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+const (
+	defaultPlayerMatchPageSize = 20
+	maxPlayerMatchPageSize     = 100
+)
+
+// PlayerHandler handles the player profile endpoints
+type PlayerHandler struct {
+	profiles *services.PlayerProfileService
+}
+
+// NewPlayerHandler creates a new player handler
+func NewPlayerHandler(profiles *services.PlayerProfileService) *PlayerHandler {
+	return &PlayerHandler{profiles: profiles}
+}
+
+// Profile gets a player's lifetime and per-map statistics. The SteamID may
+// be given as SteamID3, SteamID2 or SteamID64.
+func (h *PlayerHandler) Profile(c *gin.Context) {
+	steamID64, ok := steamIDParam(c)
+	if !ok {
+		return
+	}
+
+	profile, err := h.profiles.Profile(c.Request.Context(), steamID64)
+	if err != nil {
+		if err.Error() == "player not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get player profile"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// Matches lists the matches a player played in with their score in each,
+// most recent first, with limit/offset pagination
+func (h *PlayerHandler) Matches(c *gin.Context) {
+	steamID64, ok := steamIDParam(c)
+	if !ok {
+		return
+	}
+
+	limit, offset := pagination(c, defaultPlayerMatchPageSize, maxPlayerMatchPageSize)
+	matches, total, err := h.profiles.Matches(c.Request.Context(), steamID64, limit, offset)
+	if err != nil {
+		if err.Error() == "player not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list player matches"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"steam_id64": steamID64,
+		"matches":    matches,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// steamIDParam reads the steamid path parameter as a SteamID64, responding
+// with 400 when it is not a SteamID
+func steamIDParam(c *gin.Context) (string, bool) {
+	accountID, ok := entities.ParseSteamID(c.Param("steamid"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid steam_id"})
+		return "", false
+	}
+	return entities.SteamID64(accountID), true
+}
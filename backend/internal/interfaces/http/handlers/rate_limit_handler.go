@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/interfaces/http/middleware"
+)
+
+// GetRateLimitStats returns the allowed and rejected request counters of the
+// ingestion rate limiter
+func GetRateLimitStats(limiter *middleware.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		servers := limiter.Stats()
+
+		var allowed, rejected uint64
+		for _, s := range servers {
+			allowed += s.Allowed
+			rejected += s.Rejected
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"allowed":  allowed,
+			"rejected": rejected,
+			"servers":  servers,
+		})
+	}
+}
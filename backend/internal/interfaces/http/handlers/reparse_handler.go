@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+const (
+	defaultReparseJobPageSize = 20
+	maxReparseJobPageSize     = 100
+)
+
+// ReparseHandler handles the admin endpoints that reparse stored logs
+type ReparseHandler struct {
+	reparse *services.ReparseService
+	jobs    *persistence.PostgresReparseRepository
+
+	// runCtx outlives requests; jobs stopped by it resume on the next start
+	runCtx context.Context
+}
+
+// NewReparseHandler creates a new reparse handler. Jobs run in the
+// background until they complete or runCtx is done.
+func NewReparseHandler(runCtx context.Context, reparse *services.ReparseService, jobs *persistence.PostgresReparseRepository) *ReparseHandler {
+	return &ReparseHandler{
+		reparse: reparse,
+		jobs:    jobs,
+		runCtx:  runCtx,
+	}
+}
+
+// CreateReparseJobRequest selects the raw logs to reparse by server and
+// receive time; empty fields do not filter
+type CreateReparseJobRequest struct {
+	ServerID  string     `json:"server_id"`
+	From      *time.Time `json:"from"`
+	To        *time.Time `json:"to"`
+	StaleOnly bool       `json:"stale_only"` // only lines parsed by another parser version
+	ChunkSize int        `json:"chunk_size"`
+}
+
+// Create starts a reparse job in the background
+func (h *ReparseHandler) Create(c *gin.Context) {
+	var req CreateReparseJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job := &entities.ReparseJob{
+		From:      req.From,
+		To:        req.To,
+		StaleOnly: req.StaleOnly,
+		ChunkSize: req.ChunkSize,
+		CreatedBy: c.GetString("username"),
+	}
+	if req.ServerID != "" {
+		job.ServerID = &req.ServerID
+	}
+	if job.From != nil && job.To != nil && !job.From.Before(*job.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	if err := h.reparse.Create(c.Request.Context(), job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create reparse job"})
+		return
+	}
+	if err := h.reparse.Start(h.runCtx, job.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start reparse job"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// List lists reparse jobs, most recent first
+func (h *ReparseHandler) List(c *gin.Context) {
+	limit, offset := pagination(c, defaultReparseJobPageSize, maxReparseJobPageSize)
+	jobs, err := h.jobs.ListJobs(c.Request.Context(), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list reparse jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs":           jobs,
+		"parser_version": h.reparse.ParserVersion(),
+		"limit":          limit,
+		"offset":         offset,
+	})
+}
+
+// Get gets a reparse job with its progress and event type changes
+func (h *ReparseHandler) Get(c *gin.Context) {
+	jobID := c.Param("id")
+	if _, err := uuid.Parse(jobID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := h.jobs.FindJob(c.Request.Context(), jobID)
+	if err != nil {
+		if err.Error() == "reparse job not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Reparse job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reparse job"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// Resume restarts a failed or interrupted job after the last chunk it
+// reparsed
+func (h *ReparseHandler) Resume(c *gin.Context) {
+	jobID := c.Param("id")
+	if _, err := uuid.Parse(jobID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	err := h.reparse.Start(h.runCtx, jobID)
+	switch {
+	case err == nil:
+	case err == services.ErrReparseJobRunning || err == services.ErrReparseJobCompleted:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err.Error() == "reparse job not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reparse job not found"})
+		return
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resume reparse job"})
+		return
+	}
+
+	job, err := h.jobs.FindJob(c.Request.Context(), jobID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reparse job"})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
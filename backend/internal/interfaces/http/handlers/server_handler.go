@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -11,25 +12,36 @@ import (
 
 // ServerHandler handles server management endpoints
 type ServerHandler struct {
-	serverRepo *persistence.PostgresServerRepository
+	serverRepo  *persistence.PostgresServerRepository
+	apiKeyGrace time.Duration // how long a replaced API key keeps working
 }
 
 // NewServerHandler creates a new server handler
-func NewServerHandler(serverRepo *persistence.PostgresServerRepository) *ServerHandler {
-	return &ServerHandler{serverRepo: serverRepo}
+func NewServerHandler(serverRepo *persistence.PostgresServerRepository, apiKeyGrace time.Duration) *ServerHandler {
+	return &ServerHandler{serverRepo: serverRepo, apiKeyGrace: apiKeyGrace}
 }
 
 // CreateServerRequest represents a request to create a server
 type CreateServerRequest struct {
-	Name        string `json:"name" binding:"required,min=1,max=100"`
-	Description string `json:"description"`
+	Name               string  `json:"name" binding:"required,min=1,max=100"`
+	Description        string  `json:"description"`
+	LogSecret          *string `json:"log_secret"`
+	RateLimitPerMinute *int    `json:"rate_limit_per_minute" binding:"omitempty,min=0"`
+	RateLimitBurst     *int    `json:"rate_limit_burst" binding:"omitempty,min=0"`
+	RequireAPIKey      bool    `json:"require_api_key"`
+	Timezone           *string `json:"timezone"` // IANA name, e.g. Europe/Berlin
 }
 
 // UpdateServerRequest represents a request to update a server
 type UpdateServerRequest struct {
-	Name        string `json:"name" binding:"required,min=1,max=100"`
-	Description string `json:"description"`
-	IsActive    bool   `json:"is_active"`
+	Name               string  `json:"name" binding:"required,min=1,max=100"`
+	Description        string  `json:"description"`
+	IsActive           bool    `json:"is_active"`
+	LogSecret          *string `json:"log_secret"`
+	RateLimitPerMinute *int    `json:"rate_limit_per_minute" binding:"omitempty,min=0"` // 0 restores the default
+	RateLimitBurst     *int    `json:"rate_limit_burst" binding:"omitempty,min=0"`      // 0 restores the default
+	RequireAPIKey      *bool   `json:"require_api_key"`
+	Timezone           *string `json:"timezone"` // empty restores UTC
 }
 
 // List lists all active servers
@@ -84,12 +96,22 @@ func (h *ServerHandler) Create(c *gin.Context) {
 		return
 	}
 
+	if !validTimezone(req.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+		return
+	}
+
 	server := &entities.Server{
-		Name:        req.Name,
-		Description: &req.Description,
-		IsActive:    true,
-		CreatedBy:   &userID,
-		IPAddress:   c.ClientIP(), // Initial IP, will be updated when server connects
+		Name:               req.Name,
+		Description:        &req.Description,
+		IsActive:           true,
+		CreatedBy:          &userID,
+		IPAddress:          c.ClientIP(), // Initial IP, will be updated when server connects
+		LogSecret:          req.LogSecret,
+		RateLimitPerMinute: rateLimitOverride(req.RateLimitPerMinute),
+		RateLimitBurst:     rateLimitOverride(req.RateLimitBurst),
+		RequireAPIKey:      req.RequireAPIKey,
+		Timezone:           emptyToNil(req.Timezone),
 	}
 
 	if err := h.serverRepo.Create(c.Request.Context(), server); err != nil {
@@ -119,6 +141,29 @@ func (h *ServerHandler) Update(c *gin.Context) {
 	server.Name = req.Name
 	server.Description = &req.Description
 	server.IsActive = req.IsActive
+	if req.LogSecret != nil {
+		// An empty secret clears it so the server is matched by address again
+		server.LogSecret = req.LogSecret
+		if *req.LogSecret == "" {
+			server.LogSecret = nil
+		}
+	}
+	if req.RateLimitPerMinute != nil {
+		server.RateLimitPerMinute = rateLimitOverride(req.RateLimitPerMinute)
+	}
+	if req.RateLimitBurst != nil {
+		server.RateLimitBurst = rateLimitOverride(req.RateLimitBurst)
+	}
+	if req.RequireAPIKey != nil {
+		server.RequireAPIKey = *req.RequireAPIKey
+	}
+	if req.Timezone != nil {
+		if !validTimezone(req.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+			return
+		}
+		server.Timezone = emptyToNil(req.Timezone)
+	}
 
 	if err := h.serverRepo.Update(c.Request.Context(), server); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
@@ -128,6 +173,52 @@ func (h *ServerHandler) Update(c *gin.Context) {
 	c.JSON(http.StatusOK, server)
 }
 
+// RegenerateSigningSecret generates a new secret for signing ingestion
+// requests. It is only returned once.
+func (h *ServerHandler) RegenerateSigningSecret(c *gin.Context) {
+	serverID := c.Param("id")
+
+	secret, err := h.serverRepo.RegenerateSigningSecret(c.Request.Context(), serverID)
+	if err != nil {
+		if err.Error() == "server not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate signing secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"signing_secret": secret,
+		"message":        "Signing secret regenerated successfully",
+	})
+}
+
+// validTimezone reports whether the timezone is unset or a known IANA name
+func validTimezone(timezone *string) bool {
+	if timezone == nil || *timezone == "" {
+		return true
+	}
+	_, err := time.LoadLocation(*timezone)
+	return err == nil
+}
+
+// emptyToNil clears optional string settings sent as ""
+func emptyToNil(value *string) *string {
+	if value == nil || *value == "" {
+		return nil
+	}
+	return value
+}
+
+// rateLimitOverride treats 0 as "use the default limit"
+func rateLimitOverride(value *int) *int {
+	if value == nil || *value == 0 {
+		return nil
+	}
+	return value
+}
+
 // Delete deactivates a server
 func (h *ServerHandler) Delete(c *gin.Context) {
 	serverID := c.Param("id")
@@ -140,18 +231,32 @@ func (h *ServerHandler) Delete(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Server deactivated successfully"})
 }
 
-// RegenerateAPIKey generates a new API key for a server
+// RegenerateAPIKey generates a new API key for a server. The previous key
+// keeps working for the configured grace period, which can be overridden
+// with the grace query parameter (e.g. ?grace=1h, or ?grace=0 to revoke it
+// immediately).
 func (h *ServerHandler) RegenerateAPIKey(c *gin.Context) {
 	serverID := c.Param("id")
 	
-	apiKey, err := h.serverRepo.RegenerateAPIKey(c.Request.Context(), serverID)
+	grace := h.apiKeyGrace
+	if value := c.Query("grace"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grace period"})
+			return
+		}
+		grace = parsed
+	}
+
+	apiKey, previousExpires, err := h.serverRepo.RegenerateAPIKey(c.Request.Context(), serverID, grace)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate API key"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"api_key": apiKey,
-		"message": "API key regenerated successfully",
+		"api_key":                     apiKey,
+		"previous_api_key_expires_at": previousExpires,
+		"message":                     "API key regenerated successfully",
 	})
 }
\ No newline at end of file
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
+)
+
+const (
+	defaultSessionPageSize = 50
+	maxSessionPageSize     = 500
+	defaultSessionLogLimit = 1000
+	maxSessionLogLimit     = 10000
+)
+
+// SessionHandler handles the game session query endpoints
+type SessionHandler struct {
+	sessionRepo *persistence.PostgresGameSessionRepository
+	scoreboards *services.ScoreboardService
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(sessionRepo *persistence.PostgresGameSessionRepository, scoreboards *services.ScoreboardService) *SessionHandler {
+	return &SessionHandler{
+		sessionRepo: sessionRepo,
+		scoreboards: scoreboards,
+	}
+}
+
+// List lists game sessions, filtered by server_id, map, status and a
+// from/to range on the start time, with limit/offset pagination
+func (h *SessionHandler) List(c *gin.Context) {
+	filter := persistence.GameSessionFilter{
+		ServerID: c.Query("server_id"),
+		MapName:  c.Query("map"),
+		Status:   c.Query("status"),
+	}
+
+	switch entities.SessionStatus(filter.Status) {
+	case "", entities.SessionStatusActive, entities.SessionStatusCompleted, entities.SessionStatusTerminated:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active, completed or terminated"})
+		return
+	}
+
+	for _, param := range []struct {
+		name   string
+		target **time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param.name + ": expected RFC 3339 time"})
+			return
+		}
+		*param.target = &t
+	}
+
+	filter.Limit, filter.Offset = pagination(c, defaultSessionPageSize, maxSessionPageSize)
+
+	sessions, total, err := h.sessionRepo.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"total":    total,
+		"limit":    filter.Limit,
+		"offset":   filter.Offset,
+	})
+}
+
+// Get gets a single game session by ID
+func (h *SessionHandler) Get(c *gin.Context) {
+	session, err := h.sessionRepo.FindByID(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if err.Error() == "game session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// Logs lists the parsed events of a game session in the order they were logged
+func (h *SessionHandler) Logs(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := h.sessionRepo.FindByID(c.Request.Context(), sessionID); err != nil {
+		if err.Error() == "game session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		}
+		return
+	}
+
+	limit, offset := pagination(c, defaultSessionLogLimit, maxSessionLogLimit)
+	logs, total, err := h.sessionRepo.FindLogs(c.Request.Context(), sessionID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list session logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"logs":       logs,
+		"total":      total,
+		"limit":      limit,
+		"offset":     offset,
+	})
+}
+
+// Scoreboard gets the per-player scoreboard of a game session, together with
+// how it compares to the last round_stats block the server logged
+func (h *SessionHandler) Scoreboard(c *gin.Context) {
+	sessionID := c.Param("id")
+	if _, err := h.sessionRepo.FindByID(c.Request.Context(), sessionID); err != nil {
+		if err.Error() == "game session not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get session"})
+		}
+		return
+	}
+
+	scoreboard, err := h.scoreboards.Build(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build scoreboard"})
+		return
+	}
+
+	c.JSON(http.StatusOK, scoreboard)
+}
+
+// pagination reads the limit and offset query parameters, clamping limit
+// to [1, maxLimit]
+func pagination(c *gin.Context, defaultLimit, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	offset, err := strconv.Atoi(c.Query("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
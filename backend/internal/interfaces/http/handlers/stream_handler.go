@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"golang.org/x/net/websocket"
+)
+
+// streamWriteTimeout is how long a client may take to accept one message
+// before its stream is closed
+const streamWriteTimeout = 10 * time.Second
+
+// droppedMessage tells a client that fell too far behind why its stream ended
+const droppedMessage = "Stream fell behind and was dropped; reconnect to resume"
+
+// StreamHandler streams parsed events to clients as they are stored
+type StreamHandler struct {
+	events    *services.EventHub
+	heartbeat time.Duration
+}
+
+// NewStreamHandler creates a new stream handler that sends a heartbeat to
+// idle clients every heartbeat interval
+func NewStreamHandler(events *services.EventHub, heartbeat time.Duration) *StreamHandler {
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	return &StreamHandler{
+		events:    events,
+		heartbeat: heartbeat,
+	}
+}
+
+// streamMessage is a message of the WebSocket stream
+type streamMessage struct {
+	Type  string              `json:"type"` // event, heartbeat or dropped
+	Event *entities.ParsedLog `json:"event,omitempty"`
+	Time  *time.Time          `json:"time,omitempty"`
+	Error string              `json:"error,omitempty"`
+}
+
+// Stream pushes parsed events as they are stored, filtered by server_id and
+// event_type. Clients get Server-Sent Events unless they open a WebSocket.
+// A client that falls too far behind is sent a dropped message and
+// disconnected.
+func (h *StreamHandler) Stream(c *gin.Context) {
+	filter := services.EventFilter{
+		ServerID:  c.Query("server_id"),
+		EventType: c.Query("event_type"),
+	}
+	if filter.ServerID != "" {
+		if _, err := uuid.Parse(filter.ServerID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+			return
+		}
+	}
+
+	sub := h.events.Subscribe(filter)
+	if sub == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Event stream is shutting down"})
+		return
+	}
+	defer h.events.Unsubscribe(sub)
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		h.streamWebSocket(c, sub)
+		return
+	}
+	h.streamSSE(c, sub)
+}
+
+// streamSSE sends each event as an SSE message with the parsed log as its
+// data, heartbeats as comments and a final dropped event for slow clients
+func (h *StreamHandler) streamSSE(c *gin.Context, sub *services.EventSubscription) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // keep proxies from buffering the stream
+	c.Status(http.StatusOK)
+
+	rc := http.NewResponseController(c.Writer)
+	send := func(message string) bool {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := c.Writer.WriteString(message); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send(": connected\n\n") {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var message string
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				if sub.Dropped() {
+					data, _ := json.Marshal(gin.H{"error": droppedMessage})
+					send(fmt.Sprintf("event: dropped\ndata: %s\n\n", data))
+				}
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			message = fmt.Sprintf("id: %s\ndata: %s\n\n", event.ID, data)
+		case now := <-heartbeat.C:
+			message = fmt.Sprintf(": heartbeat %s\n\n", now.UTC().Format(time.RFC3339))
+		}
+		if !send(message) {
+			return
+		}
+	}
+}
+
+// streamWebSocket sends events, heartbeats and the dropped notice as JSON
+// messages. Origins are not checked, as for the rest of the public API.
+func (h *StreamHandler) streamWebSocket(c *gin.Context, sub *services.EventSubscription) {
+	server := websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// Clients send nothing; reading only notices when they go away
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var discard []byte
+				for websocket.Message.Receive(ws, &discard) == nil {
+				}
+			}()
+
+			heartbeat := time.NewTicker(h.heartbeat)
+			defer heartbeat.Stop()
+
+			for {
+				var message streamMessage
+				select {
+				case <-closed:
+					return
+				case event, ok := <-sub.Events():
+					if !ok {
+						if sub.Dropped() {
+							ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+							websocket.JSON.Send(ws, streamMessage{Type: "dropped", Error: droppedMessage})
+						}
+						return
+					}
+					message = streamMessage{Type: "event", Event: event}
+				case now := <-heartbeat.C:
+					now = now.UTC()
+					message = streamMessage{Type: "heartbeat", Time: &now}
+				}
+				ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if err := websocket.JSON.Send(ws, message); err != nil {
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
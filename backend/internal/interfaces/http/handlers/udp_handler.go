@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/interfaces/udp"
+)
+
+// GetUDPStats returns packet counters for the UDP log listener
+func GetUDPStats(listener *udp.LogListener) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if listener == nil {
+			c.JSON(http.StatusOK, gin.H{"enabled": false})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"enabled": true,
+			"stats":   listener.Stats(),
+		})
+	}
+}
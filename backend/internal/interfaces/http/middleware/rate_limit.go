@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// RateLimitStats holds the request counters for one server
+type RateLimitStats struct {
+	ServerID  string    `json:"server_id"`
+	PerMinute int       `json:"per_minute"`
+	Burst     int       `json:"burst"`
+	Allowed   uint64    `json:"allowed"`
+	Rejected  uint64    `json:"rejected"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// tokenBucket refills at perMinute tokens per minute up to burst tokens
+type tokenBucket struct {
+	perMinute int
+	burst     int
+	tokens    float64
+	updated   time.Time
+
+	allowed  uint64
+	rejected uint64
+}
+
+// RateLimiter limits requests per server_id with a token bucket. Buckets are
+// only created for servers that passed authentication, so they are kept for
+// the life of the process along with their counters.
+type RateLimiter struct {
+	perMinute int
+	burst     int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter creates a rate limiter with the default limits used for
+// servers without overrides
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	return &RateLimiter{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// Middleware rejects requests over the server's limit with 429. It must run
+// after ServerAuthMiddleware, which sets the server in the context.
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serverID := c.GetString("server_id")
+		perMinute, burst := l.perMinute, l.burst
+		if value, ok := c.Get("server"); ok {
+			if server, ok := value.(*entities.Server); ok {
+				if server.RateLimitPerMinute != nil {
+					perMinute = *server.RateLimitPerMinute
+				}
+				if server.RateLimitBurst != nil {
+					burst = *server.RateLimitBurst
+				}
+			}
+		}
+
+		allowed, remaining, retryAfter, resetAfter := l.take(serverID, perMinute, burst, time.Now())
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(perMinute))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(resetAfter).Unix(), 10))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Rate limit exceeded",
+				"retry_after": int(math.Ceil(retryAfter.Seconds())),
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Stats returns the counters of every server that has sent a request
+func (l *RateLimiter) Stats() []RateLimitStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make([]RateLimitStats, 0, len(l.buckets))
+	for serverID, b := range l.buckets {
+		stats = append(stats, RateLimitStats{
+			ServerID:  serverID,
+			PerMinute: b.perMinute,
+			Burst:     b.burst,
+			Allowed:   b.allowed,
+			Rejected:  b.rejected,
+			LastSeen:  b.updated,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ServerID < stats[j].ServerID })
+	return stats
+}
+
+// take removes a token from the server's bucket. It returns whether the
+// request is allowed, the whole tokens left, how long until a token is
+// available and how long until the bucket is full again.
+func (l *RateLimiter) take(serverID string, perMinute, burst int, now time.Time) (bool, int, time.Duration, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[serverID]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), updated: now}
+		l.buckets[serverID] = b
+	}
+
+	// Limits can change when the server is edited; keep the tokens earned
+	b.perMinute = perMinute
+	b.burst = burst
+
+	rate := float64(perMinute) / 60 // tokens per second
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+		b.allowed++
+	} else {
+		b.rejected++
+	}
+
+	var retryAfter, resetAfter time.Duration
+	if rate > 0 {
+		if !allowed {
+			retryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		}
+		resetAfter = time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second))
+	} else if !allowed {
+		// A zero rate never refills; ask clients to back off for a minute
+		retryAfter = time.Minute
+		resetAfter = time.Minute
+	}
+
+	return allowed, int(b.tokens), retryAfter, resetAfter
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+	"github.com/noueii/nocs-log-saver/pkg/logclient"
+)
+
+const maxNonceLength = 128
+
+var (
+	errSigningNotConfigured = errors.New("server has no signing secret")
+	errSignatureHeaders     = errors.New("signature requires timestamp and nonce headers")
+	errSignatureExpired     = errors.New("signature timestamp outside the allowed clock skew")
+	errNonceReused          = errors.New("signature nonce already used")
+)
+
+// SignatureVerifier checks HMAC-signed ingestion requests and remembers
+// nonces for as long as their timestamp is acceptable, so a captured request
+// cannot be replayed
+type SignatureVerifier struct {
+	maxSkew      time.Duration
+	maxBodyBytes int64
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time // server_id:nonce -> when it can be forgotten
+	lastPrune time.Time
+}
+
+// NewSignatureVerifier creates a verifier accepting timestamps within maxSkew
+// of the local clock and bodies up to maxBodyBytes as sent
+func NewSignatureVerifier(maxSkew time.Duration, maxBodyBytes int64) *SignatureVerifier {
+	return &SignatureVerifier{
+		maxSkew:      maxSkew,
+		maxBodyBytes: maxBodyBytes,
+		nonces:       make(map[string]time.Time),
+		lastPrune:    time.Now(),
+	}
+}
+
+// Verify checks the request signature of a server. It returns false without
+// an error when the request is not signed. The body is read and replaced so
+// later handlers can still read it.
+func (v *SignatureVerifier) Verify(c *gin.Context, server *entities.Server) (bool, error) {
+	signature := c.GetHeader(logclient.HeaderSignature)
+	if signature == "" {
+		return false, nil
+	}
+	if server.SigningSecret == nil || *server.SigningSecret == "" {
+		return false, errSigningNotConfigured
+	}
+
+	nonce := c.GetHeader(logclient.HeaderNonce)
+	timestamp, err := strconv.ParseInt(c.GetHeader(logclient.HeaderTimestamp), 10, 64)
+	if err != nil || nonce == "" || len(nonce) > maxNonceLength {
+		return false, errSignatureHeaders
+	}
+
+	now := time.Now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return false, errSignatureExpired
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, v.maxBodyBytes))
+	if err != nil {
+		return false, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := logclient.Verify(*server.SigningSecret, timestamp, nonce, body, signature); err != nil {
+		return false, err
+	}
+
+	// Only nonces of valid signatures are recorded, so forged requests
+	// cannot use up a sender's nonces
+	if !v.useNonce(server.ID+":"+nonce, signedAt.Add(v.maxSkew), now) {
+		return false, errNonceReused
+	}
+
+	return true, nil
+}
+
+// useNonce records a nonce until expiresAt and reports whether it was unused
+func (v *SignatureVerifier) useNonce(key string, expiresAt, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if now.Sub(v.lastPrune) > v.maxSkew {
+		for k, expires := range v.nonces {
+			if now.After(expires) {
+				delete(v.nonces, k)
+			}
+		}
+		v.lastPrune = now
+	}
+
+	if expires, ok := v.nonces[key]; ok && now.Before(expires) {
+		return false
+	}
+	v.nonces[key] = expiresAt
+	return true
+}
@@ -1,15 +1,21 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/noueii/nocs-log-saver/internal/infrastructure/persistence"
 )
 
-// ServerAuthMiddleware validates that the server ID exists and is active
-// It also validates the API key if provided in the query parameters
-func ServerAuthMiddleware(serverRepo *persistence.PostgresServerRepository) gin.HandlerFunc {
+// ServerAuthMiddleware validates that the server ID exists and is active.
+// It also validates the API key, sent as "Authorization: Bearer srv_..." or
+// in the key query parameter, and HMAC request signatures. A valid signature
+// stands in for the API key; servers with require_api_key reject requests
+// that have neither.
+func ServerAuthMiddleware(serverRepo *persistence.PostgresServerRepository, signatures *SignatureVerifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		serverID := c.Param("server_id")
 		if serverID == "" {
@@ -37,23 +43,51 @@ func ServerAuthMiddleware(serverRepo *persistence.PostgresServerRepository) gin.
 			return
 		}
 
+		// Check the request signature if the request is signed
+		signed, err := signatures.Verify(c, server)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid request signature", "reason": err.Error()})
+			}
+			c.Abort()
+			return
+		}
+
 		// Check API key if provided
-		apiKey := c.Query("key")
+		apiKey := requestAPIKey(c)
 		if apiKey != "" {
 			// If API key is provided, it must match
-			if server.APIKey != apiKey {
+			if !server.MatchesAPIKey(apiKey, time.Now()) {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
 				c.Abort()
 				return
 			}
+		} else if server.RequireAPIKey && !signed {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key required"})
+			c.Abort()
+			return
 		}
-		// Note: In the future, we can make API key required by checking:
-		// else if server.RequireAPIKey { ... }
 
-		// Store server ID and client IP in context for later use
+		// Store server, server ID and client IP in context for later use
+		c.Set("server", server)
 		c.Set("server_id", serverID)
 		c.Set("client_ip", c.ClientIP())
 
 		c.Next()
 	}
+}
+
+// requestAPIKey returns the key from the Authorization header, falling back
+// to the key query parameter
+func requestAPIKey(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return c.Query("key")
 }
\ No newline at end of file
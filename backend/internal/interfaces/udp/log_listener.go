@@ -0,0 +1,452 @@
+package udp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/noueii/nocs-log-saver/internal/application/commands"
+	"github.com/noueii/nocs-log-saver/internal/application/services"
+	"github.com/noueii/nocs-log-saver/internal/domain/entities"
+)
+
+// Source engine log packets (logaddress_add) start with four 0xFF bytes and a
+// type byte: 'R' for plain lines, 'S' when the line is prefixed with sv_logsecret.
+const (
+	packetHeader     = "\xff\xff\xff\xff"
+	packetTypePlain  = 'R'
+	packetTypeSecret = 'S'
+
+	maxPacketSize   = 65507
+	packetQueueSize = 4096
+
+	// Packets are saved in batches of up to packetBatchSize packets, collected
+	// for at most packetBatchWait, so a busy server costs one transaction per
+	// batch instead of one per packet
+	packetBatchSize = 256
+	packetBatchWait = 20 * time.Millisecond
+
+	serverCacheTTL     = time.Minute
+	lastSeenUpdateRate = 30 * time.Second
+)
+
+// Reasons a packet or line can be dropped
+const (
+	DropMalformed      = "malformed"
+	DropUnknownServer  = "unknown_server"
+	DropSecretRequired = "secret_required"
+	DropNotWhitelisted = "not_whitelisted"
+	DropQueueFull      = "queue_full"
+	DropShuttingDown   = "shutting_down"
+	DropSaveFailed     = "save_failed"
+	DropDuplicate      = "duplicate"
+)
+
+// ServerResolver looks up the server a packet belongs to
+type ServerResolver interface {
+	FindByLogSecret(ctx context.Context, secret string) (*entities.Server, error)
+	FindByIPAddress(ctx context.Context, ipAddress string) (*entities.Server, error)
+}
+
+// LineIngester saves log lines for a server and queues them for parsing
+type LineIngester interface {
+	Handle(ctx context.Context, cmd commands.IngestLogCommand) (*entities.IngestBatch, error)
+}
+
+// ServerStats holds packet counters for a single server
+type ServerStats struct {
+	ServerID     string            `json:"server_id"`
+	Packets      uint64            `json:"packets"`
+	Lines        uint64            `json:"lines"`
+	Dropped      map[string]uint64 `json:"dropped"`
+	LastSender   string            `json:"last_sender"`
+	LastPacketAt time.Time         `json:"last_packet_at"`
+
+	lastSeenUpdate time.Time
+}
+
+// Stats is a snapshot of the listener counters
+type Stats struct {
+	Address  string            `json:"address"`
+	Received uint64            `json:"received"`
+	Dropped  map[string]uint64 `json:"dropped"` // packets that could not be attributed to a server
+	Servers  []ServerStats     `json:"servers"`
+}
+
+type packet struct {
+	sender *net.UDPAddr
+	data   []byte
+}
+
+// packetBatch holds the lines of consecutive packets a server sent from one
+// address
+type packetBatch struct {
+	server  *entities.Server
+	sender  *net.UDPAddr
+	packets int
+	lines   []string
+}
+
+type cachedServer struct {
+	server    *entities.Server
+	expiresAt time.Time
+}
+
+// LogListener receives Source engine log packets over UDP and feeds them
+// through the same save and parse path as the HTTP ingestion endpoint
+type LogListener struct {
+	addr     string
+	servers  ServerResolver
+	ingester LineIngester
+
+	conn    *net.UDPConn
+	packets chan packet
+	wg      sync.WaitGroup
+
+	cacheMu sync.Mutex
+	cache   map[string]cachedServer
+
+	statsMu  sync.Mutex
+	received uint64
+	dropped  map[string]uint64
+	stats    map[string]*ServerStats
+}
+
+// NewLogListener creates a new UDP log listener
+func NewLogListener(addr string, servers ServerResolver, ingester LineIngester) *LogListener {
+	return &LogListener{
+		addr:     addr,
+		servers:  servers,
+		ingester: ingester,
+		packets:  make(chan packet, packetQueueSize),
+		cache:    make(map[string]cachedServer),
+		dropped:  make(map[string]uint64),
+		stats:    make(map[string]*ServerStats),
+	}
+}
+
+// Start binds the UDP socket and starts receiving packets
+func (l *LogListener) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", l.addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+
+	// A single worker keeps lines from each server in arrival order
+	l.wg.Add(2)
+	go l.readLoop()
+	go l.processLoop()
+
+	return nil
+}
+
+// Stop closes the socket and waits for queued packets to be processed
+func (l *LogListener) Stop() {
+	if l.conn == nil {
+		return
+	}
+	l.conn.Close()
+	l.wg.Wait()
+}
+
+// Stats returns a snapshot of the packet counters
+func (l *LogListener) Stats() Stats {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+
+	snapshot := Stats{
+		Address:  l.addr,
+		Received: l.received,
+		Dropped:  copyCounters(l.dropped),
+		Servers:  make([]ServerStats, 0, len(l.stats)),
+	}
+	for _, s := range l.stats {
+		server := *s
+		server.Dropped = copyCounters(s.Dropped)
+		snapshot.Servers = append(snapshot.Servers, server)
+	}
+	sort.Slice(snapshot.Servers, func(i, j int) bool {
+		return snapshot.Servers[i].ServerID < snapshot.Servers[j].ServerID
+	})
+
+	return snapshot
+}
+
+// readLoop reads packets off the socket without blocking on the database
+func (l *LogListener) readLoop() {
+	defer l.wg.Done()
+	defer close(l.packets)
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, sender, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("UDP log listener read error: %v", err)
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buf[:n])
+
+		l.statsMu.Lock()
+		l.received++
+		l.statsMu.Unlock()
+
+		select {
+		case l.packets <- packet{sender: sender, data: data}:
+		default:
+			l.countDrop(nil, DropQueueFull, 1)
+		}
+	}
+}
+
+// processLoop resolves the sending servers and ingests the packet lines in
+// batches
+func (l *LogListener) processLoop() {
+	defer l.wg.Done()
+
+	for p := range l.packets {
+		for _, batch := range l.collectPackets(p) {
+			l.ingestBatch(batch)
+		}
+	}
+}
+
+// collectPackets gathers the packets that arrive within packetBatchWait of
+// the first one, up to packetBatchSize, into batches per server and sender.
+// The batches are in arrival order, so each server's lines stay in order.
+func (l *LogListener) collectPackets(first packet) []*packetBatch {
+	var batches []*packetBatch
+	latest := make(map[string]*packetBatch) // by server ID
+	add := func(p packet) {
+		server, lines, ok := l.decodeLines(p)
+		if !ok {
+			return
+		}
+		batch := latest[server.ID]
+		if batch == nil || !batch.sender.IP.Equal(p.sender.IP) {
+			batch = &packetBatch{server: server, sender: p.sender}
+			latest[server.ID] = batch
+			batches = append(batches, batch)
+		}
+		batch.packets++
+		batch.lines = append(batch.lines, lines...)
+	}
+
+	add(first)
+	timer := time.NewTimer(packetBatchWait)
+	defer timer.Stop()
+	for n := 1; n < packetBatchSize; n++ {
+		select {
+		case p, ok := <-l.packets:
+			if !ok {
+				return batches
+			}
+			add(p)
+		case <-timer.C:
+			return batches
+		}
+	}
+	return batches
+}
+
+// decodeLines resolves the server a packet belongs to and splits it into
+// lines. Packets that cannot be attributed to a server are counted as
+// dropped.
+func (l *LogListener) decodeLines(p packet) (*entities.Server, []string, bool) {
+	secret, payload, ok := decodePacket(p.data)
+	if !ok {
+		l.countDrop(nil, DropMalformed, 1)
+		return nil, nil, false
+	}
+
+	server, err := l.resolveServer(secret, p.sender.IP.String())
+	if err != nil || server == nil {
+		l.countDrop(nil, DropUnknownServer, 1)
+		return nil, nil, false
+	}
+
+	// A server with a configured secret must send it, otherwise anyone on
+	// the same host could write into its logs
+	if secret == "" && server.LogSecret != nil && *server.LogSecret != "" {
+		l.countDrop(server, DropSecretRequired, 1)
+		return nil, nil, false
+	}
+
+	return server, strings.Split(payload, "\n"), true
+}
+
+// ingestBatch saves the lines of a batch of packets in one go
+func (l *LogListener) ingestBatch(b *packetBatch) {
+	var lineCount int
+	for _, line := range b.lines {
+		if strings.TrimSpace(line) != "" {
+			lineCount++
+		}
+	}
+
+	// Record the sender as the server's address every so often
+	l.statsMu.Lock()
+	keepAddress := true
+	if stats := l.serverStats(b.server.ID); time.Since(stats.lastSeenUpdate) > lastSeenUpdateRate {
+		stats.lastSeenUpdate = time.Now()
+		keepAddress = false
+	}
+	l.statsMu.Unlock()
+
+	var saved, duplicates int
+	batch, err := l.ingester.Handle(context.Background(), commands.IngestLogCommand{
+		ServerID:    b.server.ID,
+		Lines:       b.lines,
+		ClientIP:    b.sender.IP.String(),
+		KeepAddress: keepAddress,
+		NoReceipt:   true, // nobody can ask for the status of a packet
+	})
+	if batch != nil {
+		saved = batch.SavedCount
+		duplicates = batch.DuplicateCount
+	}
+
+	l.statsMu.Lock()
+	stats := l.serverStats(b.server.ID)
+	stats.Packets += uint64(b.packets)
+	stats.Lines += uint64(saved)
+	stats.LastSender = b.sender.String()
+	stats.LastPacketAt = time.Now()
+	if duplicates > 0 {
+		stats.Dropped[DropDuplicate] += uint64(duplicates)
+	}
+	if lost := lineCount - saved - duplicates; lost > 0 {
+		reason := DropSaveFailed
+		switch {
+		case errors.Is(err, services.ErrQueueFull):
+			reason = DropQueueFull
+		case errors.Is(err, services.ErrPipelineClosed):
+			reason = DropShuttingDown
+		case errors.Is(err, commands.ErrIPNotWhitelisted):
+			reason = DropNotWhitelisted
+		}
+		stats.Dropped[reason] += uint64(lost)
+	}
+	l.statsMu.Unlock()
+}
+
+// resolveServer maps a log secret or sender address to a registered server.
+// Lookups, including misses, are cached briefly to keep the database out of
+// the per-packet path. Failed queries are not cached, so a database hiccup
+// only drops the packets received while it lasts.
+func (l *LogListener) resolveServer(secret, ip string) (*entities.Server, error) {
+	key := "addr:" + ip
+	if secret != "" {
+		key = "secret:" + secret
+	}
+
+	l.cacheMu.Lock()
+	cached, ok := l.cache[key]
+	l.cacheMu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.server, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var server *entities.Server
+	var err error
+	if secret != "" {
+		server, err = l.servers.FindByLogSecret(ctx, secret)
+	} else {
+		server, err = l.servers.FindByIPAddress(ctx, ip)
+	}
+	if err != nil {
+		server = nil
+		if !isUnknownServer(err) {
+			return nil, err
+		}
+	}
+
+	l.cacheMu.Lock()
+	l.cache[key] = cachedServer{server: server, expiresAt: time.Now().Add(serverCacheTTL)}
+	l.cacheMu.Unlock()
+
+	return server, err
+}
+
+// isUnknownServer reports whether a lookup failed because no single active
+// server matches, rather than because the query failed
+func isUnknownServer(err error) bool {
+	return err.Error() == "server not found or inactive" ||
+		strings.HasPrefix(err.Error(), "multiple servers share address")
+}
+
+// countDrop records dropped packets, per server when the server is known
+func (l *LogListener) countDrop(server *entities.Server, reason string, n uint64) {
+	l.statsMu.Lock()
+	defer l.statsMu.Unlock()
+
+	if server == nil {
+		l.dropped[reason] += n
+		return
+	}
+	l.serverStats(server.ID).Dropped[reason] += n
+}
+
+// serverStats returns the counters for a server; statsMu must be held
+func (l *LogListener) serverStats(serverID string) *ServerStats {
+	stats, ok := l.stats[serverID]
+	if !ok {
+		stats = &ServerStats{
+			ServerID: serverID,
+			Dropped:  make(map[string]uint64),
+		}
+		l.stats[serverID] = stats
+	}
+	return stats
+}
+
+// decodePacket splits a log packet into its secret (if any) and log text
+func decodePacket(data []byte) (secret, payload string, ok bool) {
+	if len(data) < len(packetHeader)+1 || !bytes.HasPrefix(data, []byte(packetHeader)) {
+		return "", "", false
+	}
+
+	packetType := data[len(packetHeader)]
+	body := string(bytes.TrimRight(data[len(packetHeader)+1:], "\x00"))
+
+	switch packetType {
+	case packetTypePlain:
+		return "", body, true
+	case packetTypeSecret:
+		// The secret runs up to the "L " that starts the log line
+		idx := strings.Index(body, "L ")
+		if idx <= 0 {
+			return "", "", false
+		}
+		return body[:idx], body[idx:], true
+	default:
+		return "", "", false
+	}
+}
+
+func copyCounters(counters map[string]uint64) map[string]uint64 {
+	out := make(map[string]uint64, len(counters))
+	for k, v := range counters {
+		out[k] = v
+	}
+	return out
+}
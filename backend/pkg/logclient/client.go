@@ -0,0 +1,84 @@
+package logclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client sends log lines for one server
+type Client struct {
+	BaseURL       string // e.g. https://logs.example.com
+	ServerID      string
+	SigningSecret string // signs requests when set
+	APIKey        string // sent as a bearer token when set
+	HTTPClient    *http.Client
+}
+
+// Receipt is the response to an accepted batch
+type Receipt struct {
+	Received       bool    `json:"received"`
+	BatchID        *string `json:"batch_id"`
+	LineCount      int     `json:"line_count"`
+	DuplicateCount int     `json:"duplicate_count"`
+	Replayed       bool    `json:"replayed"`
+}
+
+// NewClient creates a client that signs requests with signingSecret
+func NewClient(baseURL, serverID, signingSecret string) *Client {
+	return &Client{
+		BaseURL:       strings.TrimRight(baseURL, "/"),
+		ServerID:      serverID,
+		SigningSecret: signingSecret,
+		HTTPClient:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Send posts the lines as a plain-text batch. A non-empty idempotencyKey
+// lets the batch be retried safely.
+func (c *Client) Send(ctx context.Context, lines []string, idempotencyKey string) (*Receipt, error) {
+	body := []byte(strings.Join(lines, "\n"))
+
+	url := fmt.Sprintf("%s/logs/%s", c.BaseURL, c.ServerID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	if c.SigningSecret != "" {
+		if err := SignRequest(req, c.SigningSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ingest failed: %s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	var receipt Receipt
+	if err := json.Unmarshal(respBody, &receipt); err != nil {
+		return nil, fmt.Errorf("decode receipt: %w", err)
+	}
+	return &receipt, nil
+}
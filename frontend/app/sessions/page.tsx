@@ -12,6 +12,7 @@ import {
   TableHeader,
   TableRow,
 } from '@/components/ui/table';
+import { api, GameSession } from '@/lib/api';
 
 interface Session {
   id: string;
@@ -19,7 +20,7 @@ interface Session {
   server_name?: string;
   match_id?: string;
   phase: 'warmup' | 'live' | 'halftime' | 'overtime' | 'postgame';
-  status: 'active' | 'completed';
+  status: 'active' | 'completed' | 'terminated';
   started_at: string;
   ended_at?: string;
   map?: string;
@@ -32,6 +33,24 @@ interface Session {
   player_count?: number;
 }
 
+// toSession maps a session from the API onto the table row shape. Sessions
+// are live once the match has started and postgame once they have ended.
+const toSession = (session: GameSession): Session => ({
+  id: session.id,
+  server_id: session.server_id,
+  server_name: session.server_name,
+  phase: session.status !== 'active'
+    ? 'postgame'
+    : session.metadata?.match_start_at ? 'live' : 'warmup',
+  status: session.status,
+  started_at: session.started_at,
+  ended_at: session.ended_at,
+  map: session.map_name,
+  score: session.score_ct !== undefined && session.score_t !== undefined
+    ? { ct: session.score_ct, t: session.score_t }
+    : undefined,
+});
+
 export default function SessionsPage() {
   const [sessions, setSessions] = useState<Session[]>([]);
   const [loading, setLoading] = useState(true);
@@ -41,55 +60,15 @@ export default function SessionsPage() {
     loadSessions();
     const interval = setInterval(loadSessions, 10000); // Refresh every 10 seconds
     return () => clearInterval(interval);
-  }, []);
+  }, [filter]);
 
   const loadSessions = async () => {
     try {
-      // In a real app, this would fetch from the API
-      // const data = await api.getSessions();
-      // For now, using mock data
-      const mockSessions: Session[] = [
-        {
-          id: 'session-1',
-          server_id: 'server-1',
-          server_name: 'EU West #1',
-          match_id: 'match-123',
-          phase: 'live',
-          status: 'active',
-          started_at: new Date(Date.now() - 1800000).toISOString(), // 30 minutes ago
-          map: 'de_dust2',
-          score: { ct: 8, t: 5 },
-          round: 13,
-          max_rounds: 30,
-          player_count: 10,
-        },
-        {
-          id: 'session-2',
-          server_id: 'server-2',
-          server_name: 'US East #2',
-          match_id: 'match-124',
-          phase: 'warmup',
-          status: 'active',
-          started_at: new Date(Date.now() - 300000).toISOString(), // 5 minutes ago
-          map: 'de_mirage',
-          player_count: 6,
-        },
-        {
-          id: 'session-3',
-          server_id: 'server-3',
-          server_name: 'Asia #1',
-          match_id: 'match-122',
-          phase: 'postgame',
-          status: 'completed',
-          started_at: new Date(Date.now() - 7200000).toISOString(), // 2 hours ago
-          ended_at: new Date(Date.now() - 3600000).toISOString(), // 1 hour ago
-          map: 'de_inferno',
-          score: { ct: 16, t: 14 },
-          round: 30,
-          max_rounds: 30,
-        },
-      ];
-      setSessions(mockSessions);
+      const data = await api.getSessions({
+        status: filter === 'all' ? undefined : filter,
+        limit: 100,
+      });
+      setSessions(data.sessions.map(toSession));
     } catch (error) {
       console.error('Failed to load sessions:', error);
     } finally {
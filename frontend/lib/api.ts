@@ -5,6 +5,8 @@ export interface Server {
   name: string;
   ip_address: string;
   api_key: string;
+  require_api_key?: boolean;
+  previous_api_key_expires_at?: string;
   description: string;
   is_active: boolean;
   last_seen: string;
@@ -13,6 +15,41 @@ export interface Server {
   updated_at: string;
 }
 
+export interface GameSession {
+  id: string;
+  server_id: string;
+  server_name?: string;
+  map_name?: string;
+  started_at: string;
+  ended_at?: string;
+  status: 'active' | 'completed' | 'terminated';
+  metadata?: Record<string, any>;
+  event_count: number;
+  score_ct?: number;
+  score_t?: number;
+}
+
+export interface SessionFilter {
+  server_id?: string;
+  map?: string;
+  status?: GameSession['status'];
+  from?: string;
+  to?: string;
+  limit?: number;
+  offset?: number;
+}
+
+export interface SessionLog {
+  id: string;
+  raw_log_id: string;
+  server_id: string;
+  event_type: string;
+  event_data: Record<string, any>;
+  event_time?: string;
+  session_id: string;
+  created_at: string;
+}
+
 export interface Log {
   id: string;
   server_id: string;
@@ -97,7 +134,7 @@ class ApiClient {
     });
   }
 
-  async regenerateApiKey(id: string): Promise<{ api_key: string; message: string }> {
+  async regenerateApiKey(id: string): Promise<{ api_key: string; previous_api_key_expires_at: string | null; message: string }> {
     const token = localStorage.getItem('access_token');
     return this.request(`/api/admin/servers/${id}/regenerate-key`, {
       method: 'POST',
@@ -117,6 +154,24 @@ class ApiClient {
     return this.request(`/api/logs?${params.toString()}`);
   }
 
+  // Game Sessions
+  async getSessions(filter: SessionFilter = {}): Promise<{ sessions: GameSession[]; total: number; limit: number; offset: number }> {
+    const params = new URLSearchParams();
+    Object.entries(filter).forEach(([key, value]) => {
+      if (value !== undefined && value !== '') params.append(key, String(value));
+    });
+
+    return this.request(`/api/sessions?${params.toString()}`);
+  }
+
+  async getSession(id: string): Promise<GameSession> {
+    return this.request(`/api/sessions/${id}`);
+  }
+
+  async getSessionLogs(id: string, limit = 1000, offset = 0): Promise<{ session_id: string; logs: SessionLog[]; total: number; limit: number; offset: number }> {
+    return this.request(`/api/sessions/${id}/logs?limit=${limit}&offset=${offset}`);
+  }
+
   // Stats
   async getStats(): Promise<any> {
     return this.request('/api/stats');